@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	policyClient "policy-server/client"
+	"strings"
 
 	"github.com/cloudfoundry/cli/plugin"
 	"github.com/pivotal-cf-experimental/rainmaker"
@@ -46,8 +47,14 @@ func (p *Plugin) Run(cliConnection plugin.CliConnection, args []string) {
 		traceWriter = os.Stdout
 	}
 
+	// the cli returns a token along with its type
+	tokenSource := policyClient.TokenSourceFunc(func() (string, error) {
+		token, err := cliConnection.AccessToken()
+		return strings.TrimPrefix(token, "bearer "), err
+	})
+
 	runner := &Runner{
-		Client:        policyClient.NewOuterClient("http://127.0.0.1:5555", http.DefaultClient),
+		Client:        policyClient.NewOuterClient("http://127.0.0.1:5555", http.DefaultClient, tokenSource),
 		UserLogger:    logger,
 		CliConnection: cliConnection,
 		Rainmaker: rainmaker.NewClient(rainmaker.Config{
@@ -78,21 +85,29 @@ func (p *Plugin) GetMetadata() plugin.PluginMetadata {
 				Name:     CommandAllow,
 				HelpText: "Allow direct network traffic from one app to another",
 				UsageDetails: plugin.Usage{
-					Usage: fmt.Sprintf("cf %s SOURCE_APP DESTINATION_APP", CommandAllow),
+					Usage: fmt.Sprintf("cf %s SOURCE_APP DESTINATION_APP [--protocol PROTOCOL] [--port PORT]", CommandAllow),
+					Options: map[string]string{
+						"-protocol": "Protocol to allow: tcp, udp or icmp (default: all traffic)",
+						"-port":     "Port or range of ports to allow, e.g. 8080 or 8080-8090 (tcp and udp only)",
+					},
 				},
 			},
 			plugin.Command{
 				Name:     CommandDisallow,
 				HelpText: "Remove an existing net-allow rule",
 				UsageDetails: plugin.Usage{
-					Usage: fmt.Sprintf("cf %s SOURCE_APP DESTINATION_APP", CommandDisallow),
+					Usage: fmt.Sprintf("cf %s SOURCE_APP DESTINATION_APP [--protocol PROTOCOL] [--port PORT]", CommandDisallow),
+					Options: map[string]string{
+						"-protocol": "Protocol of the rule to remove",
+						"-port":     "Port or range of ports of the rule to remove",
+					},
 				},
 			},
 			plugin.Command{
 				Name:     CommandList,
-				HelpText: "List all network allow rules",
+				HelpText: "List network allow rules, all of them or those to or from an app",
 				UsageDetails: plugin.Usage{
-					Usage: fmt.Sprintf("cf %s", CommandList),
+					Usage: fmt.Sprintf("cf %s [APP]", CommandList),
 				},
 			},
 		},
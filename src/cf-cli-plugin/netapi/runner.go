@@ -1,8 +1,12 @@
 package netapi
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
+	policyClient "policy-server/client"
 	"policy-server/models"
+	"strconv"
 	"strings"
 
 	"github.com/cloudfoundry/cli/plugin"
@@ -13,18 +17,50 @@ const (
 	CommandAllow    = "net-allow"
 	CommandDisallow = "net-disallow"
 	CommandList     = "net-list"
+
+	listPageSize = 100
 )
 
 type client interface {
-	AddRule(rule models.Rule) error
+	AddRule(rule models.Rule) (models.Rule, bool, error)
 	DeleteRule(rule models.Rule) error
-	ListRules() ([]models.Rule, error)
+	ListRules(options policyClient.ListOptions) ([]models.Rule, error)
+	ListGroups() ([]models.Group, error)
 }
 
 type userLogger interface {
 	Printf(format string, v ...interface{})
 }
 
+type unauthorizedError interface {
+	Unauthorized() bool
+}
+
+type forbiddenError interface {
+	Forbidden() bool
+}
+
+type notFoundError interface {
+	NotFound() bool
+}
+
+type tagSpaceError interface {
+	TagSpaceExhausted() bool
+}
+
+func explain(err error) error {
+	if e, ok := err.(unauthorizedError); ok && e.Unauthorized() {
+		return fmt.Errorf("%s, try logging in again with cf login", err)
+	}
+	if e, ok := err.(forbiddenError); ok && e.Forbidden() {
+		return fmt.Errorf("%s, you may only connect apps in spaces where you are a developer", err)
+	}
+	if e, ok := err.(tagSpaceError); ok && e.TagSpaceExhausted() {
+		return fmt.Errorf("%s, ask your operator to free up packet tags", err)
+	}
+	return err
+}
+
 type Runner struct {
 	Client        client
 	UserLogger    userLogger
@@ -32,6 +68,59 @@ type Runner struct {
 	Rainmaker     rainmaker.Client
 }
 
+func parseRuleArgs(args []string) ([]string, string, string, error) {
+	flags := flag.NewFlagSet("rule", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	protocol := flags.String("protocol", "", "")
+	port := flags.String("port", "", "")
+
+	names := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, "", "", err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		names = append(names, args[0])
+		args = args[1:]
+	}
+	return names, *protocol, *port, nil
+}
+
+func parsePorts(ports string) (int, int, error) {
+	if ports == "" {
+		return 0, 0, nil
+	}
+	bounds := strings.SplitN(ports, "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", ports)
+	}
+	end := start
+	if len(bounds) == 2 {
+		end, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port %q", ports)
+		}
+	}
+	return start, end, nil
+}
+
+func formatRule(sourceName, destinationName string, rule models.Rule) string {
+	formatted := fmt.Sprintf("%s --> %s", sourceName, destinationName)
+	if rule.Protocol != "" {
+		formatted += " " + rule.Protocol
+	}
+	if rule.StartPort == rule.EndPort && rule.StartPort != 0 {
+		formatted += fmt.Sprintf(":%d", rule.StartPort)
+	} else if rule.StartPort != 0 {
+		formatted += fmt.Sprintf(":%d-%d", rule.StartPort, rule.EndPort)
+	}
+	return formatted
+}
+
 func (r *Runner) getRule(sourceName, destinationName string) (models.Rule, error) {
 	app1, err := r.CliConnection.GetApp(sourceName)
 	if err != nil {
@@ -44,20 +133,20 @@ func (r *Runner) getRule(sourceName, destinationName string) (models.Rule, error
 	return models.Rule{Source: app1.Guid, Destination: app2.Guid}, nil
 }
 
-func (r *Runner) resolveAndPrettyPrint(rule models.Rule, token string) (string, error) {
+func (r *Runner) resolveAndPrettyPrint(rule models.Rule, token string, names map[string]string) (string, error) {
 	token = strings.TrimPrefix(token, "bearer ") // rainmaker adds its own bearer
-	app1, err := r.Rainmaker.Applications.Get(rule.Source, token)
-	if err != nil {
-		return "", fmt.Errorf("resolve %s: %s", rule.Source, err)
-	}
-	app2, err := r.Rainmaker.Applications.Get(rule.Destination, token)
-	if err != nil {
-		return "", fmt.Errorf("resolve %s: %s", rule.Destination, err)
+	for _, guid := range []string{rule.Source, rule.Destination} {
+		if _, ok := names[guid]; ok {
+			continue
+		}
+		app, err := r.Rainmaker.Applications.Get(guid, token)
+		if err != nil {
+			return "", fmt.Errorf("resolve %s: %s", guid, err)
+		}
+		names[guid] = app.Name
 	}
 
-	sourceName := app1.Name
-	destinationName := app2.Name
-	return fmt.Sprintf("%s --> %s", sourceName, destinationName), nil
+	return formatRule(names[rule.Source], names[rule.Destination], rule), nil
 }
 
 func (r *Runner) Run(args []string) error {
@@ -78,13 +167,34 @@ func (r *Runner) Run(args []string) error {
 
 	switch command {
 	case CommandList:
-		rules, err := r.Client.ListRules()
+		options := policyClient.ListOptions{PageSize: listPageSize}
+		switch len(args) {
+		case 1:
+		case 2:
+			app, err := r.CliConnection.GetApp(args[1])
+			if err != nil {
+				return fmt.Errorf("getting app %s: %s", args[1], err)
+			}
+			options.Filter.Group = app.Guid
+		default:
+			return fmt.Errorf("too many arguments, try -h")
+		}
+		rules, err := r.Client.ListRules(options)
+		if err != nil {
+			return fmt.Errorf("list: %s", explain(err))
+		}
+		// rules may name groups rather than apps, and groups go by their names
+		groups, err := r.Client.ListGroups()
 		if err != nil {
-			return fmt.Errorf("list: %s", err)
+			return fmt.Errorf("list: %s", explain(err))
+		}
+		names := map[string]string{}
+		for _, group := range groups {
+			names[group.Name] = group.Name
 		}
 		prettyPrintedRules := []string{}
 		for _, rule := range rules {
-			prettyPrintedRule, err := r.resolveAndPrettyPrint(rule, token)
+			prettyPrintedRule, err := r.resolveAndPrettyPrint(rule, token, names)
 			if err != nil {
 				return fmt.Errorf("parsing rules: %s", err)
 			}
@@ -95,28 +205,51 @@ func (r *Runner) Run(args []string) error {
 			r.UserLogger.Printf("%s\n", ppr)
 		}
 	case CommandAllow, CommandDisallow:
-		if len(args) != 3 {
+		names, protocol, ports, err := parseRuleArgs(args[1:])
+		if err != nil {
+			return fmt.Errorf("%s, try -h", err)
+		}
+		if len(names) != 2 {
 			return fmt.Errorf("missing required arguments, try -h")
 		}
-		sourceName := args[1]
-		destinationName := args[2]
+		if ports != "" && protocol == "" {
+			return fmt.Errorf("--port requires --protocol tcp or udp")
+		}
+		sourceName := names[0]
+		destinationName := names[1]
 		rule, err := r.getRule(sourceName, destinationName)
 		if err != nil {
 			return fmt.Errorf("%s", err)
 		}
+		rule.Protocol = protocol
+		rule.StartPort, rule.EndPort, err = parsePorts(ports)
+		if err != nil {
+			return err
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid rule: %s", err)
+		}
 		switch command {
 		case CommandAllow:
-			err = r.Client.AddRule(rule)
+			_, created, err := r.Client.AddRule(rule)
 			if err != nil {
-				return fmt.Errorf("allow: %s", err)
+				return fmt.Errorf("allow: %s", explain(err))
+			}
+			if !created {
+				r.UserLogger.Printf("already allowed %s\n", formatRule(sourceName, destinationName, rule))
+				return nil
 			}
-			r.UserLogger.Printf("allowed %s --> %s\n", sourceName, destinationName)
+			r.UserLogger.Printf("allowed %s\n", formatRule(sourceName, destinationName, rule))
 		case CommandDisallow:
 			err = r.Client.DeleteRule(rule)
+			if e, ok := err.(notFoundError); ok && e.NotFound() {
+				r.UserLogger.Printf("already disallowed %s\n", formatRule(sourceName, destinationName, rule))
+				return nil
+			}
 			if err != nil {
-				return fmt.Errorf("disallow: %s", err)
+				return fmt.Errorf("disallow: %s", explain(err))
 			}
-			r.UserLogger.Printf("disallowed %s --> %s\n", sourceName, destinationName)
+			r.UserLogger.Printf("disallowed %s\n", formatRule(sourceName, destinationName, rule))
 		}
 	default:
 		return fmt.Errorf("unknown command: %s", command)
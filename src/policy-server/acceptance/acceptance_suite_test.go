@@ -1,11 +1,26 @@
 package acceptance_test
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
-	"math/rand"
+	"math/big"
+	mathrand "math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"policy-server/config"
+	"policy-server/models"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	gconfig "github.com/onsi/ginkgo/config"
@@ -17,7 +32,14 @@ import (
 
 const DEFAULT_TIMEOUT = "5s"
 
-var serverBinPath string
+var (
+	serverBinPath string
+
+	signingKey     *rsa.PrivateKey
+	signingKeyPath string
+
+	certs certificates
+)
 
 func TestAcceptance(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -45,16 +67,29 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 	Expect(json.Unmarshal(marshaledBytes, &data)).To(Succeed())
 	serverBinPath = data.ServerBinPath
 
-	rand.Seed(gconfig.GinkgoConfig.RandomSeed + int64(GinkgoParallelNode()))
+	mathrand.Seed(gconfig.GinkgoConfig.RandomSeed + int64(GinkgoParallelNode()))
+
+	var err error
+	signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	signingKeyPath = writePublicKey(&signingKey.PublicKey)
+
+	certs = generateCertificates()
 })
 
 var _ = SynchronizedAfterSuite(func() {
 	// run on all nodes
+	Expect(os.Remove(signingKeyPath)).To(Succeed())
+	Expect(os.RemoveAll(certs.Dir)).To(Succeed())
 }, func() {
 	// run only on node 1
 	gexec.CleanupBuildArtifacts()
 })
 
+func added(_ models.Rule, _ bool, err error) error {
+	return err
+}
+
 func VerifyTCPConnection(address string) error {
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
@@ -73,3 +108,141 @@ func WriteConfigFile(serverConfig *config.ServerConfig) string {
 
 	return configFile.Name()
 }
+
+func writePublicKey(key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyFile, err := ioutil.TempFile("", "signing-key")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der})).To(Succeed())
+	Expect(keyFile.Close()).To(Succeed())
+
+	return keyFile.Name()
+}
+
+type certificates struct {
+	Dir string
+
+	CACert     string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+
+	OtherClientCert string
+	OtherClientKey  string
+
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+}
+
+func (c certificates) writeServerCertificate(dir, name string) *big.Int {
+	server, serverKey := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "policy-server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, c.ca, c.caKey)
+	writeCertificate(dir, name, server, serverKey)
+	return server.SerialNumber
+}
+
+func generateCertificates() certificates {
+	dir, err := ioutil.TempDir("", "certs")
+	Expect(err).NotTo(HaveOccurred())
+	certs := certificates{Dir: dir}
+
+	ca, caKey := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "policy-server-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	certs.CACert, _ = writeCertificate(dir, "ca", ca, caKey)
+	certs.ca, certs.caKey = ca, caKey
+
+	certs.writeServerCertificate(dir, "server")
+	certs.ServerCert, certs.ServerKey = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	clientTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	client, clientKey := newCertificate(clientTemplate, ca, caKey)
+	certs.ClientCert, certs.ClientKey = writeCertificate(dir, "client", client, clientKey)
+
+	otherCA, otherCAKey := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	otherClient, otherClientKey := newCertificate(clientTemplate, otherCA, otherCAKey)
+	certs.OtherClientCert, certs.OtherClientKey = writeCertificate(dir, "other-client", otherClient, otherClientKey)
+
+	return certs
+}
+
+func newCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template.SerialNumber = big.NewInt(mathrand.Int63())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert, key
+}
+
+func writeCertificate(dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	certPath := filepath.Join(dir, name+".crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	Expect(ioutil.WriteFile(certPath, certPEM, 0600)).To(Succeed())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	keyPath := filepath.Join(dir, name+".key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	Expect(ioutil.WriteFile(keyPath, keyPEM, 0600)).To(Succeed())
+
+	return certPath, keyPath
+}
+
+func signToken(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	encode := func(value interface{}) string {
+		payload, err := json.Marshal(value)
+		Expect(err).NotTo(HaveOccurred())
+		return base64.RawURLEncoding.EncodeToString(payload)
+	}
+
+	signed := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func adminClaims() map[string]interface{} {
+	claims := userClaims()
+	claims["scope"] = []string{"openid", "cloud_controller.read", config.DefaultAdminScope}
+	return claims
+}
+
+func userClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":   "some-user-guid",
+		"user_name": "some-user",
+		"client_id": "cf",
+		"scope":     []string{"openid", "cloud_controller.read"},
+		"aud":       []string{"cf", config.DefaultAuthAudience},
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}
+}
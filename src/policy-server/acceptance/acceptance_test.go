@@ -1,16 +1,35 @@
 package acceptance_test
 
 import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"policy-server/api"
+	"policy-server/audit"
 	"policy-server/client"
 	"policy-server/config"
+	"policy-server/fakes"
 	"policy-server/models"
+	"policy-server/mutualtls"
+	"strings"
+	"syscall"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
 
 	"github.com/pivotal-golang/lager/lagertest"
@@ -18,36 +37,81 @@ import (
 
 var _ = Describe("Policy server", func() {
 	var (
-		session        *gexec.Session
-		address        string
-		configFilePath string
-		outerClient    *client.OuterClient
-		innerClient    *client.InnerClient
+		session         *gexec.Session
+		address         string
+		innerAddress    string
+		debugAddress    string
+		innerHTTPClient *http.Client
+		serverConfig    *config.ServerConfig
+		configFilePath  string
+		outerClient     *client.OuterClient
+		innerClient     *client.InnerClient
+		cloudController *fakes.CloudController
+		auditDir        string
+		ccServer        *httptest.Server
 
 		logger *lagertest.TestLogger
 	)
 
 	BeforeEach(func() {
 		address = fmt.Sprintf("127.0.0.1:%d", 4001+GinkgoParallelNode())
+		innerAddress = fmt.Sprintf("127.0.0.1:%d", 6001+GinkgoParallelNode())
+		debugAddress = fmt.Sprintf("127.0.0.1:%d", 7001+GinkgoParallelNode())
 
 		logger = lagertest.NewTestLogger("test")
-		configFilePath = WriteConfigFile(&config.ServerConfig{
-			ListenAddress: address,
-		})
+		cloudController = fakes.NewCloudController()
+		ccServer = httptest.NewServer(cloudController)
+
+		var err error
+		auditDir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		serverConfig = &config.ServerConfig{
+			ListenAddress:      address,
+			InnerListenAddress: innerAddress,
+			DebugListenAddress: debugAddress,
+			InnerTLS: config.TLSConfig{
+				CertPath:   certs.ServerCert,
+				KeyPath:    certs.ServerKey,
+				CACertPath: certs.CACert,
+			},
+			Auth: config.AuthConfig{
+				SigningKeyPaths:    []string{signingKeyPath},
+				CloudControllerURL: ccServer.URL,
+			},
+			Audit: config.AuditConfig{
+				Path: filepath.Join(auditDir, "audit.log"),
+			},
+		}
+
+		token := signToken(signingKey, adminClaims())
+		outerClient = client.NewOuterClient("http://"+address, http.DefaultClient, client.TokenSourceFunc(func() (string, error) {
+			return token, nil
+		}))
+
+		tlsConfig, err := mutualtls.NewClientTLSConfig(certs.ClientCert, certs.ClientKey, certs.CACert)
+		Expect(err).NotTo(HaveOccurred())
+		innerHTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		innerClient = client.NewInnerClient("https://"+innerAddress, innerHTTPClient)
+	})
 
+	startServer := func() {
 		serverCmd := exec.Command(serverBinPath, "-configFile", configFilePath)
 		var err error
 		session, err = gexec.Start(serverCmd, GinkgoWriter, GinkgoWriter)
 		Expect(err).NotTo(HaveOccurred())
+	}
 
-		outerClient = client.NewOuterClient("http://"+address, http.DefaultClient)
-		innerClient = client.NewInnerClient("http://"+address, http.DefaultClient)
+	JustBeforeEach(func() {
+		configFilePath = WriteConfigFile(serverConfig)
+		startServer()
 	})
 
 	AfterEach(func() {
 		session.Interrupt()
 		Eventually(session, DEFAULT_TIMEOUT).Should(gexec.Exit(0))
 		Expect(os.Remove(configFilePath)).To(Succeed())
+		ccServer.Close()
+		Expect(os.RemoveAll(auditDir)).To(Succeed())
 	})
 
 	var serverIsAvailable = func() error {
@@ -79,15 +143,15 @@ var _ = Describe("Policy server", func() {
 			Expect(groupRules[1].AllowedSources).To(BeEmpty())
 
 			By("listing the rules from the outside")
-			rules, err := outerClient.ListRules()
+			rules, err := outerClient.ListRules(client.ListOptions{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(rules).To(BeEmpty())
 
 			By("adding a new rule")
-			Expect(outerClient.AddRule(models.Rule{
+			Expect(added(outerClient.AddRule(models.Rule{
 				Source:      "group1",
 				Destination: "group2",
-			})).To(Succeed())
+			}))).To(Succeed())
 
 			By("getting the packet tags for the two groups")
 			groupRules, err = innerClient.GetWhitelists([]string{"group1", "group2", "group3"})
@@ -112,10 +176,10 @@ var _ = Describe("Policy server", func() {
 			Expect(group1Tag).NotTo(Equal(group2Tag))
 
 			By("adding a second rule")
-			Expect(outerClient.AddRule(models.Rule{
+			Expect(added(outerClient.AddRule(models.Rule{
 				Source:      "group2",
 				Destination: "group3",
-			})).To(Succeed())
+			}))).To(Succeed())
 
 			By("getting the packet tags for the third group")
 			groupRules, err = innerClient.GetWhitelists([]string{"group3"})
@@ -125,7 +189,7 @@ var _ = Describe("Policy server", func() {
 			Expect(groupRules[0].Destination.Tag).NotTo(BeNil())
 			group3Tag := *groupRules[0].Destination.Tag
 			Expect(groupRules[0].AllowedSources).To(HaveLen(1))
-			Expect(groupRules[0].AllowedSources[0]).To(BeEquivalentTo(models.TaggedGroup{
+			Expect(groupRules[0].AllowedSources[0]).To(BeEquivalentTo(models.AllowedSource{
 				ID:  "group2",
 				Tag: &group2Tag,
 			}))
@@ -133,10 +197,10 @@ var _ = Describe("Policy server", func() {
 			Expect(group3Tag).NotTo(Equal(group2Tag))
 
 			By("adding a third rule")
-			Expect(outerClient.AddRule(models.Rule{
+			Expect(added(outerClient.AddRule(models.Rule{
 				Source:      "group2",
 				Destination: "group2",
-			})).To(Succeed())
+			}))).To(Succeed())
 
 			By("getting the packet tags for the second group")
 			groupRules, err = innerClient.GetWhitelists([]string{"group2"})
@@ -145,25 +209,34 @@ var _ = Describe("Policy server", func() {
 			Expect(groupRules[0].Destination.ID).To(Equal("group2"))
 			Expect(*groupRules[0].Destination.Tag).To(Equal(group2Tag))
 			Expect(groupRules[0].AllowedSources).To(HaveLen(2))
-			Expect(groupRules[0].AllowedSources).To(ContainElement(BeEquivalentTo(models.TaggedGroup{
+			Expect(groupRules[0].AllowedSources).To(ContainElement(BeEquivalentTo(models.AllowedSource{
 				ID:  "group1",
 				Tag: &group1Tag,
 			})))
-			Expect(groupRules[0].AllowedSources).To(ContainElement(BeEquivalentTo(models.TaggedGroup{
+			Expect(groupRules[0].AllowedSources).To(ContainElement(BeEquivalentTo(models.AllowedSource{
 				ID:  "group2",
 				Tag: &group2Tag,
 			})))
 
 			By("listing the rules from the outside")
-			rules, err = outerClient.ListRules()
+			rules, err = outerClient.ListRules(client.ListOptions{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(rules).To(HaveLen(3))
 			Expect(rules).To(ConsistOf([]models.Rule{
-				{Source: "group1", Destination: "group2"},
-				{Source: "group2", Destination: "group3"},
-				{Source: "group2", Destination: "group2"},
+				{ID: "1", Source: "group1", Destination: "group2"},
+				{ID: "2", Source: "group2", Destination: "group3"},
+				{ID: "3", Source: "group2", Destination: "group2"},
 			}))
 
+			By("adding the first rule again")
+			rule, created, err := outerClient.AddRule(models.Rule{
+				Source:      "group1",
+				Destination: "group2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(rule.ID).To(Equal("1"))
+
 			By("removing the second rule")
 			Expect(outerClient.DeleteRule(models.Rule{
 				Source:      "group2",
@@ -171,21 +244,1528 @@ var _ = Describe("Policy server", func() {
 			})).To(Succeed())
 
 			By("listing the rules")
-			rules, err = outerClient.ListRules()
+			rules, err = outerClient.ListRules(client.ListOptions{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(rules).To(HaveLen(2))
 			Expect(rules).To(ConsistOf([]models.Rule{
-				{Source: "group1", Destination: "group2"},
-				{Source: "group2", Destination: "group2"},
+				{ID: "1", Source: "group1", Destination: "group2"},
+				{ID: "3", Source: "group2", Destination: "group2"},
 			}))
 
-			By("getting the packet tags for the third group")
+			By("checking that the third group no longer has a packet tag")
 			groupRules, err = innerClient.GetWhitelists([]string{"group3"})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(groupRules).To(HaveLen(1))
 			Expect(groupRules[0].Destination.ID).To(Equal("group3"))
-			Expect(*groupRules[0].Destination.Tag).To(Equal(group3Tag))
+			Expect(groupRules[0].Destination.Tag).To(BeNil())
 			Expect(groupRules[0].AllowedSources).To(BeEmpty())
+
+			By("checking the tag allocation counts")
+			stats, err := innerClient.GetTagStats()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(Equal(models.TagStats{
+				Allocated:   3,
+				Freed:       1,
+				InUse:       2,
+				Quarantined: 1,
+				Capacity:    4294967295,
+			}))
+
+			By("re-adding the second rule within the quarantine period")
+			Expect(added(outerClient.AddRule(models.Rule{
+				Source:      "group2",
+				Destination: "group3",
+			}))).To(Succeed())
+
+			By("checking that the third group gets its old tag back")
+			groupRules, err = innerClient.GetWhitelists([]string{"group3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*groupRules[0].Destination.Tag).To(Equal(group3Tag))
+		})
+	})
+
+	Describe("listing rules", func() {
+		JustBeforeEach(func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			batch := models.Batch{}
+			for i := 0; i < 12; i++ {
+				batch.Add = append(batch.Add, models.Rule{
+					Source:      fmt.Sprintf("group%d", i%3),
+					Destination: fmt.Sprintf("group%d", i),
+				})
+			}
+			batch.Add[0].Owner = "pipeline"
+			_, err := outerClient.ApplyBatch(batch)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		ids := func(rules []models.Rule) []string {
+			found := []string{}
+			for _, rule := range rules {
+				found = append(found, rule.ID)
+			}
+			return found
+		}
+
+		It("should filter the rules by their groups and owner", func() {
+			rules, err := outerClient.ListRules(client.ListOptions{Filter: models.RuleFilter{Source: "group1"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids(rules)).To(Equal([]string{"2", "5", "8", "11"}))
+
+			rules, err = outerClient.ListRules(client.ListOptions{Filter: models.RuleFilter{Destination: "group1"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids(rules)).To(Equal([]string{"2"}))
+
+			rules, err = outerClient.ListRules(client.ListOptions{Filter: models.RuleFilter{Group: "group2"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids(rules)).To(Equal([]string{"3", "6", "9", "12"}))
+
+			rules, err = outerClient.ListRules(client.ListOptions{Filter: models.RuleFilter{Owner: "pipeline"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{{ID: "1", Source: "group0", Destination: "group0", Owner: "pipeline"}}))
+		})
+
+		It("should page through the rules in the order of their IDs", func() {
+			pages := outerClient.Pages(client.ListOptions{PageSize: 5})
+			found := [][]string{}
+			for pages.Next() {
+				found = append(found, ids(pages.Rules()))
+			}
+			Expect(pages.Err()).NotTo(HaveOccurred())
+			Expect(found).To(Equal([][]string{
+				{"1", "2", "3", "4", "5"},
+				{"6", "7", "8", "9", "10"},
+				{"11", "12"},
+			}))
+
+			By("picking up after a rule that has since been deleted")
+			pages = outerClient.Pages(client.ListOptions{PageSize: 5, Filter: models.RuleFilter{Source: "group1"}})
+			Expect(pages.Next()).To(BeTrue())
+			Expect(ids(pages.Rules())).To(Equal([]string{"2", "5", "8", "11"}))
+			Expect(pages.Next()).To(BeFalse())
+
+			pages = outerClient.Pages(client.ListOptions{PageSize: 2, Filter: models.RuleFilter{Source: "group1"}})
+			Expect(pages.Next()).To(BeTrue())
+			Expect(ids(pages.Rules())).To(Equal([]string{"2", "5"}))
+			Expect(outerClient.DeleteRule(models.Rule{ID: "5"})).To(Succeed())
+			Expect(pages.Next()).To(BeTrue())
+			Expect(ids(pages.Rules())).To(Equal([]string{"8", "11"}))
+			Expect(pages.Next()).To(BeFalse())
+			Expect(pages.Err()).NotTo(HaveOccurred())
+		})
+
+		It("should link to the next page, and reject bad page sizes and cursors", func() {
+			request := func(query string) *http.Response {
+				req, err := http.NewRequest("GET", "http://"+address+"/v1/policies?"+query, nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Authorization", "Bearer "+signToken(signingKey, adminClaims()))
+				resp, err := http.DefaultClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				return resp
+			}
+
+			resp := request("group=group0&limit=2")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Link")).To(Equal(`</v1/policies?cursor=NA&group=group0&limit=2>; rel="next"`))
+
+			resp = request("group=group0&limit=2&cursor=NA")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Link")).To(BeEmpty())
+
+			Expect(request("limit=0").StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(request("limit=1001").StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(request("cursor=!").StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("authentication", func() {
+		clientWithToken := func(token string) *client.OuterClient {
+			return client.NewOuterClient("http://"+address, http.DefaultClient, client.TokenSourceFunc(func() (string, error) {
+				return token, nil
+			}))
+		}
+
+		It("should reject requests to the outer API without a valid token", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			By("rejecting requests without a token")
+			_, err := client.NewOuterClient("http://"+address, http.DefaultClient, nil).ListRules(client.ListOptions{})
+			Expect(err).To(MatchError("list rules: missing bearer token"))
+			Expect(err.(client.Error).Unauthorized()).To(BeTrue())
+
+			By("rejecting expired tokens")
+			claims := userClaims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			_, err = clientWithToken(signToken(signingKey, claims)).ListRules(client.ListOptions{})
+			Expect(err).To(MatchError(ContainSubstring("invalid token: token expired")))
+
+			By("rejecting tokens meant for someone else")
+			claims = userClaims()
+			claims["aud"] = []string{"cf"}
+			_, _, err = clientWithToken(signToken(signingKey, claims)).AddRule(models.Rule{Source: "group1", Destination: "group2"})
+			Expect(err).To(MatchError(ContainSubstring("invalid token")))
+
+			By("rejecting tokens signed by another key")
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			err = clientWithToken(signToken(otherKey, userClaims())).DeleteRule(models.Rule{ID: "1"})
+			Expect(err).To(MatchError(ContainSubstring("invalid token: invalid signature")))
+
+			By("leaving the inner API alone")
+			_, err = innerClient.GetWhitelists([]string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("accepting valid tokens")
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Eventually(session.Out, DEFAULT_TIMEOUT).Should(gbytes.Say(`"user":"some-user"`))
+		})
+
+		Context("when auth is disabled", func() {
+			BeforeEach(func() {
+				serverConfig.Auth = config.AuthConfig{Disabled: true}
+			})
+
+			It("should accept requests without a token", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				_, err := client.NewOuterClient("http://"+address, http.DefaultClient, nil).ListRules(client.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when auth is not configured", func() {
+			BeforeEach(func() {
+				serverConfig.Auth = config.AuthConfig{}
+			})
+
+			It("should accept requests without a token, and warn about it", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				Expect(session.Out).To(gbytes.Say("auth-not-configured"))
+				_, err := client.NewOuterClient("http://"+address, http.DefaultClient, nil).ListRules(client.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		It("should refuse to start without signing keys once auth is configured", func() {
+			unauthenticatedConfig := *serverConfig
+			unauthenticatedConfig.ListenAddress = fmt.Sprintf("127.0.0.1:%d", 5001+GinkgoParallelNode())
+			unauthenticatedConfig.Auth = config.AuthConfig{CloudControllerURL: ccServer.URL}
+			unauthenticatedConfigPath := WriteConfigFile(&unauthenticatedConfig)
+			defer os.Remove(unauthenticatedConfigPath)
+
+			unauthenticated, err := gexec.Start(exec.Command(serverBinPath, "-configFile", unauthenticatedConfigPath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(unauthenticated, DEFAULT_TIMEOUT).Should(gexec.Exit(1))
+		})
+	})
+
+	Describe("authorization", func() {
+		var developerClient *client.OuterClient
+
+		BeforeEach(func() {
+			cloudController.AppSpaces = map[string]string{
+				"app-a": "space-1",
+				"app-b": "space-1",
+				"app-c": "space-2",
+				"app-d": "space-3",
+				"app-e": "space-3",
+			}
+			cloudController.DeveloperSpaces["some-user-guid"] = []string{"space-1"}
+			cloudController.AuditorSpaces["some-user-guid"] = []string{"space-2"}
+
+			token := signToken(signingKey, userClaims())
+			developerClient = client.NewOuterClient("http://"+address, http.DefaultClient, client.TokenSourceFunc(func() (string, error) {
+				return token, nil
+			}))
+		})
+
+		It("should only let developers connect apps in their own spaces", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			By("allowing rules between apps the user develops")
+			own, _, err := developerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-b"})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("forbidding rules with apps the user only audits or cannot see")
+			_, _, err = developerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-c"})
+			Expect(err).To(MatchError("add rule: may not manage rule from app-a to app-c"))
+			Expect(err.(client.Error).Forbidden()).To(BeTrue())
+			_, _, err = developerClient.AddRule(models.Rule{Source: "app-d", Destination: "app-a"})
+			Expect(err).To(MatchError(ContainSubstring("may not manage rule")))
+
+			By("letting admins manage any rule")
+			audited, _, err := outerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-c"})
+			Expect(err).NotTo(HaveOccurred())
+			hidden, _, err := outerClient.AddRule(models.Rule{Source: "app-d", Destination: "app-e"})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("forbidding deletes of other rules, even by ID")
+			err = developerClient.DeleteRule(models.Rule{ID: audited.ID})
+			Expect(err).To(MatchError(ContainSubstring("may not manage rule")))
+			_, err = developerClient.ApplyBatch(models.Batch{
+				Delete: []models.Rule{{ID: hidden.ID, Source: "app-a", Destination: "app-b"}},
+			})
+			Expect(err).To(MatchError(ContainSubstring("may not manage rule")))
+
+			By("forbidding syncs that would delete other rules")
+			_, err = outerClient.SyncRules("some-owner", []models.Rule{{Source: "app-d", Destination: "app-e"}}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = developerClient.SyncRules("some-owner", []models.Rule{{Source: "app-a", Destination: "app-b"}}, false)
+			Expect(err).To(MatchError(ContainSubstring("may not manage rule")))
+
+			By("only listing rules with apps the user can see")
+			rules, err := developerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(ConsistOf(own, audited))
+
+			rules, err = outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(4))
+
+			By("deleting the user's own rules")
+			Expect(developerClient.DeleteRule(models.Rule{ID: own.ID})).To(Succeed())
+		})
+
+		It("should not let users take over an app by naming a group after it", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "app-e", Destination: "app-d"}))).To(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "app-e", Destination: "deleted-app"}))).To(Succeed())
+
+			By("refusing names of apps, even those the user cannot see")
+			_, err := developerClient.CreateGroup("app-d")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+			_, err = developerClient.CreateGroup("app-a")
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+			_, err = outerClient.CreateGroup("app-d")
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+
+			By("refusing names that rules already use")
+			_, err = developerClient.CreateGroup("deleted-app")
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+
+			By("leaving the victim's whitelist alone")
+			_, err = developerClient.AddMembers("app-d", []string{"app-a"})
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+			whitelists, err := innerClient.GetWhitelists([]string{"app-a", "app-d"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Group).To(BeEmpty())
+			Expect(whitelists[0].AllowedSources).To(BeEmpty())
+			Expect(whitelists[1].AllowedSources).To(HaveLen(1))
+
+			By("still letting users create groups with other names")
+			_, err = developerClient.CreateGroup("frontends")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.NewOuterClient("http://"+address, http.DefaultClient, nil).CreateGroup("backends")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse to start without a cloud controller unless auth is disabled", func() {
+			unauthorizedConfig := *serverConfig
+			unauthorizedConfig.ListenAddress = fmt.Sprintf("127.0.0.1:%d", 5001+GinkgoParallelNode())
+			unauthorizedConfig.Auth.CloudControllerURL = ""
+			unauthorizedConfigPath := WriteConfigFile(&unauthorizedConfig)
+			defer os.Remove(unauthorizedConfigPath)
+
+			unauthorized, err := gexec.Start(exec.Command(serverBinPath, "-configFile", unauthorizedConfigPath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(unauthorized, DEFAULT_TIMEOUT).Should(gexec.Exit(1))
+		})
+	})
+
+	Describe("audit log", func() {
+		var developerClient *client.OuterClient
+
+		BeforeEach(func() {
+			cloudController.AppSpaces = map[string]string{"app-a": "space-1", "app-b": "space-1", "app-c": "space-2"}
+			cloudController.DeveloperSpaces["some-user-guid"] = []string{"space-1"}
+
+			token := signToken(signingKey, userClaims())
+			developerClient = client.NewOuterClient("http://"+address, http.DefaultClient, client.TokenSourceFunc(func() (string, error) {
+				return token, nil
+			}))
+		})
+
+		It("should record every attempt to change rules, and let admins read them", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			started := time.Now().Add(-time.Second)
+
+			own, _, err := developerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-b"})
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = developerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-c"})
+			Expect(err).To(MatchError(ContainSubstring("may not manage rule")))
+			_, err = client.NewOuterClient("http://"+address, http.DefaultClient, nil).ApplyBatch(models.Batch{})
+			Expect(err).To(MatchError(ContainSubstring("missing bearer token")))
+			Expect(outerClient.DeleteRule(models.Rule{ID: own.ID})).To(Succeed())
+
+			records, err := outerClient.ListAudit(audit.Filter{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(4))
+
+			Expect(records[0].Action).To(Equal("add"))
+			Expect(records[0].Actor).To(Equal("some-user"))
+			Expect(records[0].ActorID).To(Equal("some-user-guid"))
+			Expect(records[0].SourceIP).To(Equal("127.0.0.1"))
+			Expect(records[0].Outcome).To(Equal(audit.OutcomeSuccess))
+			Expect(records[0].Add).To(Equal([]models.Rule{own}))
+			Expect(records[0].Revision).To(Equal(int64(1)))
+			Expect(records[0].Time).To(BeTemporally(">=", started))
+
+			Expect(records[1].Outcome).To(Equal(audit.OutcomeForbidden))
+			Expect(records[1].Add).To(Equal([]models.Rule{{Source: "app-a", Destination: "app-c"}}))
+
+			Expect(records[2].Action).To(Equal("batch"))
+			Expect(records[2].Outcome).To(Equal(audit.OutcomeUnauthenticated))
+			Expect(records[2].Actor).To(BeEmpty())
+
+			By("recording the rule that a delete by ID removed")
+			Expect(records[3].Action).To(Equal("delete"))
+			Expect(records[3].Delete).To(Equal([]models.Rule{own}))
+			Expect(records[3].Revision).To(Equal(int64(2)))
+
+			By("filtering by actor and time")
+			records, err = outerClient.ListAudit(audit.Filter{Actor: "some-user", From: started})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(3))
+			records, err = outerClient.ListAudit(audit.Filter{To: started})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(BeEmpty())
+
+			By("keeping the records from everyone else")
+			_, err = developerClient.ListAudit(audit.Filter{})
+			Expect(err).To(MatchError("list audit: only admins may read the audit log"))
+
+			By("writing them to the audit log rather than the operational log")
+			contents, err := ioutil.ReadFile(serverConfig.Audit.Path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(string(contents), "\n")).To(Equal(4))
+		})
+
+		Context("when auditing is not configured", func() {
+			BeforeEach(func() {
+				serverConfig.Audit = config.AuditConfig{}
+			})
+
+			It("should start without an audit log, and warn about it", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				Expect(session.Out).To(gbytes.Say("audit-not-configured"))
+				Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			})
+		})
+
+		It("should refuse to start without an audit path for the file log", func() {
+			unauditedConfig := *serverConfig
+			unauditedConfig.ListenAddress = fmt.Sprintf("127.0.0.1:%d", 5001+GinkgoParallelNode())
+			unauditedConfig.Audit = config.AuditConfig{Type: config.AuditTypeFile}
+			unauditedConfigPath := WriteConfigFile(&unauditedConfig)
+			defer os.Remove(unauditedConfigPath)
+
+			unaudited, err := gexec.Start(exec.Command(serverBinPath, "-configFile", unauditedConfigPath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(unaudited, DEFAULT_TIMEOUT).Should(gexec.Exit(1))
+		})
+	})
+
+	Describe("history and rollback", func() {
+		It("should list every change and restore the rules of an earlier revision", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			first, _, err := outerClient.AddRule(models.Rule{Source: "app-a", Destination: "app-b"})
+			Expect(err).NotTo(HaveOccurred())
+			whitelists, err := innerClient.GetWhitelists([]string{"app-b"})
+			Expect(err).NotTo(HaveOccurred())
+			tagBefore := whitelists[0].Destination.Tag
+
+			_, err = outerClient.ApplyBatch(models.Batch{
+				Add:    []models.Rule{{Source: "app-b", Destination: "app-c"}},
+				Delete: []models.Rule{{ID: first.ID}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			history, err := outerClient.History()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Actor).To(Equal("some-user"))
+			Expect(history[0].Action).To(Equal(models.ChangeAdd))
+			Expect(history[0].Added).To(Equal([]models.Rule{first}))
+			Expect(history[1].Revision).To(Equal(int64(2)))
+			Expect(history[1].Deleted).To(Equal([]models.Rule{first}))
+
+			result, err := outerClient.Rollback(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(int64(3)))
+			Expect(result.Added).To(HaveLen(1))
+			Expect(result.Added[0].Equals(first)).To(BeTrue())
+
+			rules, err := outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal(result.Added))
+
+			By("giving the restored groups their old tags")
+			whitelists, err = innerClient.GetWhitelists([]string{"app-b"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(Equal(tagBefore))
+
+			By("recording the rollback in the history and the audit log")
+			history, err = outerClient.History()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(3))
+			Expect(history[2].Action).To(Equal(models.ChangeRollback))
+			Expect(*history[2].RollbackTo).To(Equal(int64(1)))
+
+			records, err := outerClient.ListAudit(audit.Filter{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records[len(records)-1].Action).To(Equal("rollback"))
+			Expect(records[len(records)-1].Revision).To(Equal(int64(3)))
+
+			By("rejecting revisions outside the history")
+			_, err = outerClient.Rollback(4)
+			Expect(err).To(MatchError("rollback: revision 4 not found in history"))
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+			Expect(err.(client.Error).Details).To(Equal(map[string]interface{}{"revision": 4.0}))
+		})
+
+		It("should only let admins read the history and roll back", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			token := signToken(signingKey, userClaims())
+			developerClient := client.NewOuterClient("http://"+address, http.DefaultClient, client.TokenSourceFunc(func() (string, error) {
+				return token, nil
+			}))
+
+			_, err := developerClient.History()
+			Expect(err).To(MatchError("list history: only admins may read the history"))
+			_, err = developerClient.Rollback(0)
+			Expect(err).To(MatchError("rollback: only admins may roll back the rules"))
+		})
+	})
+
+	Describe("error responses", func() {
+		It("should describe errors with a code, a message and details", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			request, err := http.NewRequest("POST", "http://"+address+"/rules/delete",
+				strings.NewReader(`{"group1": "some-app", "group2": "other-app"}`))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "Bearer "+signToken(signingKey, adminClaims()))
+			resp, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(MatchJSON(`{
+				"code": "not_found",
+				"message": "the rule to delete does not exist",
+				"details": {"rule": {"group1": "some-app", "group2": "other-app"}}
+			}`))
+		})
+
+		It("should describe responses without an error body by their status", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			_, err := client.NewInnerClient("http://"+address, http.DefaultClient).GetWhitelists([]string{"group1"})
+			Expect(err).To(MatchError("list rules: unexpected status code: 404 Not Found"))
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+		})
+	})
+
+	Describe("v1 API", func() {
+		var spec *openAPIDocument
+
+		v1Request := func(method, path, body string) (*http.Response, []byte) {
+			request, err := http.NewRequest(method, "http://"+address+path, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("Authorization", "Bearer "+signToken(signingKey, adminClaims()))
+			resp, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			payload, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return resp, payload
+		}
+
+		// paths are written as in the spec
+		expectDocumented := func(method, documentedPath, path, body string, status int) []byte {
+			resp, payload := v1Request(method, path, body)
+			Expect(resp.StatusCode).To(Equal(status), string(payload))
+			Expect(spec.Validate(method, documentedPath, resp, payload)).To(Succeed())
+			Expect(resp.Header.Get("Deprecation")).To(BeEmpty())
+			return payload
+		}
+
+		JustBeforeEach(func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			resp, err := http.Get("http://" + address + "/v1/spec")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			document, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(document).To(MatchJSON(api.V1))
+			spec, err = parseOpenAPI(document)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should manage policies as resources, as the spec describes", func() {
+			By("adding a rule")
+			resp, payload := v1Request("POST", "/v1/policies", `{"group1": "group1", "group2": "group2"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(spec.Validate("POST", "/v1/policies", resp, payload)).To(Succeed())
+			var rule models.Rule
+			Expect(json.Unmarshal(payload, &rule)).To(Succeed())
+			Expect(resp.Header.Get("Location")).To(Equal("/v1/policies/" + rule.ID))
+
+			By("adding it again")
+			expectDocumented("POST", "/v1/policies", "/v1/policies", `{"group1": "group1", "group2": "group2"}`, http.StatusOK)
+
+			By("reading the rules")
+			Expect(expectDocumented("GET", "/v1/policies", "/v1/policies", "", http.StatusOK)).To(MatchJSON(
+				`[{"id": "` + rule.ID + `", "group1": "group1", "group2": "group2"}]`))
+			Expect(expectDocumented("GET", "/v1/policies/{id}", "/v1/policies/"+rule.ID, "", http.StatusOK)).To(MatchJSON(
+				`{"id": "` + rule.ID + `", "group1": "group1", "group2": "group2"}`))
+
+			By("changing them in other ways")
+			expectDocumented("POST", "/v1/policies/batch", "/v1/policies/batch",
+				`{"add": [{"group1": "group2", "group2": "group3", "protocol": "tcp", "start_port": 80, "end_port": 80}]}`, http.StatusOK)
+			expectDocumented("PUT", "/v1/owners/{owner}/policies", "/v1/owners/pipeline/policies?dry_run=true",
+				`[{"group1": "group3", "group2": "group1"}]`, http.StatusOK)
+			expectDocumented("GET", "/v1/policies/history", "/v1/policies/history", "", http.StatusOK)
+			expectDocumented("POST", "/v1/policies/rollback", "/v1/policies/rollback?revision=1", "", http.StatusOK)
+			expectDocumented("GET", "/v1/audit", "/v1/audit", "", http.StatusOK)
+
+			By("deleting the rule")
+			expectDocumented("DELETE", "/v1/policies/{id}", "/v1/policies/"+rule.ID, "", http.StatusNoContent)
+			expectDocumented("GET", "/v1/policies/{id}", "/v1/policies/"+rule.ID, "", http.StatusNotFound)
+			expectDocumented("DELETE", "/v1/policies/{id}", "/v1/policies/"+rule.ID, "", http.StatusNotFound)
+
+			By("describing rejected requests")
+			expectDocumented("POST", "/v1/policies", "/v1/policies", `{"group1": "group1"}`, http.StatusBadRequest)
+			expectDocumented("POST", "/v1/policies/rollback", "/v1/policies/rollback?revision=99", "", http.StatusNotFound)
+			expectDocumented("PUT", "/v1/owners/{owner}/policies", "/v1/owners/pipeline/policies",
+				`[{"group1": "group3", "group2": "group1", "owner": "someone-else"}]`, http.StatusConflict)
+			resp, err := http.Get("http://" + address + "/v1/policies")
+			Expect(err).NotTo(HaveOccurred())
+			payload, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(spec.Validate("GET", "/v1/policies", resp, payload)).To(Succeed())
+		})
+
+		It("should keep the routes from before versioning as deprecated aliases", func() {
+			resp, payload := v1Request("POST", "/rules/add", `{"group1": "group1", "group2": "group2"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(resp.Header.Get("Deprecation")).To(Equal("true"))
+			Expect(resp.Header.Get("Link")).To(Equal(`</v1/policies>; rel="successor-version"`))
+			var rule models.Rule
+			Expect(json.Unmarshal(payload, &rule)).To(Succeed())
+
+			resp, payload = v1Request("GET", "/rules", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Deprecation")).To(Equal("true"))
+			Expect(payload).To(MatchJSON(`[{"id": "` + rule.ID + `", "group1": "group1", "group2": "group2"}]`))
+
+			resp, _ = v1Request("POST", "/rules/delete", `{"group1": "group1", "group2": "group2"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Link")).To(Equal(`</v1/policies/{id}>; rel="successor-version"`))
+
+			resp, _ = v1Request("GET", "/audit", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Deprecation")).To(Equal("true"))
+		})
+
+		It("should manage groups as resources, as the spec describes", func() {
+			expectDocumented("POST", "/v1/groups", "/v1/groups", `{"name": "frontends"}`, http.StatusCreated)
+			expectDocumented("POST", "/v1/groups", "/v1/groups", `{"name": "frontends"}`, http.StatusConflict)
+			expectDocumented("POST", "/v1/groups", "/v1/groups", `{}`, http.StatusBadRequest)
+
+			Expect(expectDocumented("POST", "/v1/groups/{name}/members", "/v1/groups/frontends/members",
+				`{"members": ["app1", "app2"]}`, http.StatusOK)).To(MatchJSON(`{"name": "frontends", "members": ["app1", "app2"]}`))
+			Expect(expectDocumented("GET", "/v1/groups", "/v1/groups", "", http.StatusOK)).To(MatchJSON(
+				`{"groups": [{"name": "frontends", "members": ["app1", "app2"]}]}`))
+			expectDocumented("GET", "/v1/groups/{name}", "/v1/groups/frontends", "", http.StatusOK)
+			Expect(expectDocumented("DELETE", "/v1/groups/{name}/members/{member}", "/v1/groups/frontends/members/app1",
+				"", http.StatusOK)).To(MatchJSON(`{"name": "frontends", "members": ["app2"]}`))
+			expectDocumented("GET", "/v1/policies/history", "/v1/policies/history", "", http.StatusOK)
+			expectDocumented("GET", "/v1/audit", "/v1/audit", "", http.StatusOK)
+
+			expectDocumented("GET", "/v1/groups/{name}", "/v1/groups/backends", "", http.StatusNotFound)
+			expectDocumented("DELETE", "/v1/groups/{name}/members/{member}", "/v1/groups/frontends/members/app1",
+				"", http.StatusNotFound)
+			expectDocumented("POST", "/v1/groups/{name}/members", "/v1/groups/frontends/members",
+				`{"members": []}`, http.StatusBadRequest)
+		})
+	})
+
+	Describe("metrics", func() {
+		It("should serve metrics on the debug address only", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			_, err := innerClient.GetWhitelists([]string{"group2"})
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := http.Get("http://" + debugAddress + "/metrics")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(body)).To(ContainSubstring(`policy_server_http_requests_total{route="rules_add",code="201"} 1`))
+			Expect(string(body)).To(ContainSubstring(`policy_server_http_response_size_bytes_count{route="whitelists"} 1`))
+			Expect(string(body)).To(ContainSubstring(`policy_server_store_operation_duration_seconds_count{operation="get-whitelists"} 1`))
+			Expect(string(body)).To(ContainSubstring("policy_server_rules 1\n"))
+			Expect(string(body)).To(ContainSubstring("policy_server_groups 2\n"))
+			Expect(string(body)).To(ContainSubstring("policy_server_tags_in_use 2\n"))
+			Expect(string(body)).To(ContainSubstring("policy_server_tags_capacity 4.294967295e+09\n"))
+
+			By("not serving them on the outer API")
+			resp, err = http.Get("http://" + address + "/metrics")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("health and readiness", func() {
+		getReady := func() (int, map[string]interface{}) {
+			resp, err := http.Get("http://" + address + "/ready")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			var ready map[string]interface{}
+			Expect(json.NewDecoder(resp.Body).Decode(&ready)).To(Succeed())
+			return resp.StatusCode, ready
+		}
+
+		It("should report health and readiness without a token", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			resp, err := http.Get("http://" + address + "/health")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			status, ready := getReady()
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(ready).To(Equal(map[string]interface{}{
+				"ready": true,
+				"checks": map[string]interface{}{
+					"serving": "ok",
+					"store":   "ok",
+					"tags":    "ok",
+				},
+			}))
+		})
+
+		Context("when every packet tag is in use", func() {
+			BeforeEach(func() {
+				serverConfig.TagBits = 2
+			})
+
+			It("should report not ready", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+				Expect(added(outerClient.AddRule(models.Rule{Source: "group3", Destination: "group1"}))).To(Succeed())
+
+				status, ready := getReady()
+				Expect(status).To(Equal(http.StatusServiceUnavailable))
+				Expect(ready["ready"]).To(BeFalse())
+				Expect(ready["checks"]).To(HaveKeyWithValue("tags", "no packet tags left to allocate"))
+				Expect(ready["checks"]).To(HaveKeyWithValue("store", "ok"))
+			})
+		})
+
+		Context("when there is a drain period", func() {
+			BeforeEach(func() {
+				serverConfig.DrainSeconds = 60
+			})
+
+			It("should report not ready while it drains, and keep serving", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				Eventually(func() (int, error) {
+					status, _ := getReady()
+					return status, nil
+				}, DEFAULT_TIMEOUT).Should(Equal(http.StatusOK))
+
+				session.Interrupt()
+				Eventually(func() int {
+					status, _ := getReady()
+					return status
+				}, DEFAULT_TIMEOUT).Should(Equal(http.StatusServiceUnavailable))
+				_, ready := getReady()
+				Expect(ready["checks"]).To(HaveKeyWithValue("serving", "starting or draining"))
+
+				groupRules, err := innerClient.GetWhitelists([]string{"group1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(groupRules).To(HaveLen(1))
+				Consistently(session).ShouldNot(gexec.Exit())
+
+				By("stopping at once when terminated")
+				session.Terminate()
+				Eventually(session, DEFAULT_TIMEOUT).Should(gexec.Exit(0))
+			})
+		})
+	})
+
+	Describe("pprof", func() {
+		It("should not serve profiles unless they are enabled", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			resp, err := http.Get("http://" + debugAddress + "/debug/pprof/")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		Context("when pprof is enabled", func() {
+			BeforeEach(func() {
+				serverConfig.EnablePprof = true
+			})
+
+			It("should serve profiles on the debug address", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+				for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine", "/debug/pprof/cmdline"} {
+					resp, err := http.Get("http://" + debugAddress + path)
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusOK), path)
+				}
+
+				resp, err := http.Get("http://" + address + "/debug/pprof/")
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+
+			It("should refuse to start without a debug address", func() {
+				undebuggableConfig := *serverConfig
+				undebuggableConfig.ListenAddress = fmt.Sprintf("127.0.0.1:%d", 5001+GinkgoParallelNode())
+				undebuggableConfig.DebugListenAddress = ""
+				undebuggableConfigPath := WriteConfigFile(&undebuggableConfig)
+				defer os.Remove(undebuggableConfigPath)
+
+				undebuggable, err := gexec.Start(exec.Command(serverBinPath, "-configFile", undebuggableConfigPath), GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(undebuggable, DEFAULT_TIMEOUT).Should(gexec.Exit(1))
+			})
+		})
+	})
+
+	Describe("inner API", func() {
+		It("should only serve clients with a certificate signed by the CA", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			By("serving clients with a trusted certificate")
+			_, err := innerClient.GetWhitelists([]string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("rejecting clients without a certificate")
+			caOnly, err := mutualtls.NewClientTLSConfig(certs.ClientCert, certs.ClientKey, certs.CACert)
+			Expect(err).NotTo(HaveOccurred())
+			caOnly.Certificates = nil
+			anonymousClient := client.NewInnerClient("https://"+innerAddress, &http.Client{
+				Transport: &http.Transport{TLSClientConfig: caOnly},
+			})
+			_, err = anonymousClient.GetWhitelists([]string{"group1"})
+			Expect(err).To(HaveOccurred())
+
+			By("rejecting clients with a certificate signed by another CA")
+			untrustedClient, err := client.NewInnerTLSClient("https://"+innerAddress, certs.OtherClientCert, certs.OtherClientKey, certs.CACert)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = untrustedClient.GetWhitelists([]string{"group1"})
+			Expect(err).To(HaveOccurred())
+
+			By("rejecting plain HTTP")
+			_, err = client.NewInnerClient("http://"+innerAddress, http.DefaultClient).GetWhitelists([]string{"group1"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should keep the inner and outer APIs on their own addresses", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			_, err := client.NewInnerClient("http://"+address, http.DefaultClient).GetWhitelists([]string{"group1"})
+			Expect(err).To(MatchError(ContainSubstring("404")))
+
+			resp, err := innerHTTPClient.Get("https://" + innerAddress + "/rules")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("should serve the whitelists of every group, and in the compact format", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			_, err := outerClient.ApplyBatch(models.Batch{Add: []models.Rule{
+				{Source: "group1", Destination: "group2"},
+				{Source: "group3", Destination: "group2", Protocol: "tcp", StartPort: 8080, EndPort: 8080},
+				{Source: "group2", Destination: "group1"},
+			}})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("leaving out the groups")
+			whitelists, err := innerClient.GetWhitelists(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists).To(HaveLen(2))
+			Expect(whitelists[0].Destination.ID).To(Equal("group1"))
+			Expect(whitelists[1].Destination.ID).To(Equal("group2"))
+			Expect(whitelists[1].AllowedSources).To(HaveLen(2))
+			tag1 := whitelists[0].Destination.Tag.String()
+			tag2 := whitelists[1].Destination.Tag.String()
+			tag3 := whitelists[1].AllowedSources[1].Tag.String()
+
+			By("asking for the compact format")
+			compact, revision, err := innerClient.GetCompactWhitelists(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(revision).To(Equal(int64(1)))
+			Expect(compact).To(Equal(models.CompactWhitelists{
+				Groups: map[string]string{tag1: "group1", tag2: "group2", tag3: "group3"},
+				Whitelists: map[string][]models.CompactSource{
+					tag1: {{Tag: tag2}},
+					tag2: {{Tag: tag1}, {Tag: tag3, Protocol: "tcp", StartPort: 8080, EndPort: 8080}},
+				},
+			}))
+
+			compact, _, err = innerClient.GetCompactWhitelists([]string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compact.Whitelists).To(HaveLen(1))
+			Expect(compact.Whitelists).To(HaveKey(tag1))
+
+			resp, err := innerHTTPClient.Get("https://" + innerAddress + "/whitelists?format=xml")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		Context("when the history is short", func() {
+			BeforeEach(func() {
+				serverConfig.Store.HistorySize = 2
+			})
+
+			It("should keep a view of the whitelists up to date with the changes since its revision", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				_, _, err := outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"})
+				Expect(err).NotTo(HaveOccurred())
+
+				view := innerClient.NewWhitelistView(nil)
+				Expect(view.Update()).To(Succeed())
+				Expect(view.Revision()).To(Equal(int64(1)))
+
+				By("applying the changes since its revision")
+				_, _, err = outerClient.AddRule(models.Rule{Source: "group3", Destination: "group2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(outerClient.DeleteRule(models.Rule{Source: "group1", Destination: "group2"})).To(Succeed())
+
+				changes, err := innerClient.GetWhitelistChanges(1, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes.Revision).To(Equal(int64(3)))
+				Expect(changes.Changes).To(HaveLen(1))
+				Expect(changes.Changes[0].Added).To(HaveLen(1))
+				Expect(changes.Changes[0].Removed).To(Equal([]models.AllowedSource{{ID: "group1"}}))
+
+				Expect(view.Update()).To(Succeed())
+				Expect(view.Revision()).To(Equal(int64(3)))
+				whitelists, err := innerClient.GetWhitelists(nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(view.Whitelists()).To(Equal(whitelists))
+
+				By("requiring a resync once the history no longer reaches back")
+				for _, group := range []string{"group4", "group5", "group6"} {
+					_, _, err = outerClient.AddRule(models.Rule{Source: group, Destination: "group1"})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				_, err = innerClient.GetWhitelistChanges(3, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(client.Error).ResyncRequired()).To(BeTrue())
+				Expect(err.(client.Error).StatusCode).To(Equal(http.StatusGone))
+
+				Expect(view.Update()).To(Succeed())
+				Expect(view.Revision()).To(Equal(int64(6)))
+				whitelists, err = innerClient.GetWhitelists(nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(view.Whitelists()).To(Equal(whitelists))
+			})
+		})
+
+		Context("when inner TLS is disabled", func() {
+			BeforeEach(func() {
+				serverConfig.InnerTLS = config.TLSConfig{Disabled: true}
+			})
+
+			It("should serve plain HTTP", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				_, err := client.NewInnerClient("http://"+innerAddress, http.DefaultClient).GetWhitelists([]string{"group1"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when no inner listen address is configured", func() {
+			BeforeEach(func() {
+				serverConfig.InnerListenAddress = ""
+				serverConfig.InnerTLS = config.TLSConfig{}
+			})
+
+			It("should serve the inner API beside the outer one, and warn about it", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+				Expect(session.Out).To(gbytes.Say("inner-server-not-configured"))
+				_, err := client.NewInnerClient("http://"+address, http.DefaultClient).GetWhitelists([]string{"group1"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		It("should refuse to start without certificates unless inner TLS is disabled", func() {
+			insecureConfig := *serverConfig
+			insecureConfig.ListenAddress = fmt.Sprintf("127.0.0.1:%d", 5001+GinkgoParallelNode())
+			insecureConfig.InnerListenAddress = fmt.Sprintf("127.0.0.1:%d", 7001+GinkgoParallelNode())
+			insecureConfig.InnerTLS = config.TLSConfig{}
+			insecureConfigPath := WriteConfigFile(&insecureConfig)
+			defer os.Remove(insecureConfigPath)
+
+			insecure, err := gexec.Start(exec.Command(serverBinPath, "-configFile", insecureConfigPath), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(insecure, DEFAULT_TIMEOUT).Should(gexec.Exit(1))
+		})
+	})
+
+	Describe("outer TLS", func() {
+		var (
+			certDir string
+			serial  *big.Int
+			caPool  *x509.CertPool
+		)
+
+		// newClient does not share connections with other clients
+		newClient := func(tlsConfig *tls.Config) *http.Client {
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			tlsConfig.RootCAs = caPool
+			return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		}
+
+		servedSerial := func(httpClient *http.Client) (*big.Int, error) {
+			req, err := http.NewRequest("GET", "https://"+address+"/rules", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Authorization", "Bearer "+signToken(signingKey, adminClaims()))
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			ioutil.ReadAll(resp.Body)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return resp.TLS.PeerCertificates[0].SerialNumber, nil
+		}
+
+		BeforeEach(func() {
+			var err error
+			certDir, err = ioutil.TempDir("", "outer-certs")
+			Expect(err).NotTo(HaveOccurred())
+			serial = certs.writeServerCertificate(certDir, "outer")
+
+			caPEM, err := ioutil.ReadFile(certs.CACert)
+			Expect(err).NotTo(HaveOccurred())
+			caPool = x509.NewCertPool()
+			Expect(caPool.AppendCertsFromPEM(caPEM)).To(BeTrue())
+
+			serverConfig.TLS = config.TLSConfig{
+				CertPath: filepath.Join(certDir, "outer.crt"),
+				KeyPath:  filepath.Join(certDir, "outer.key"),
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(certDir)).To(Succeed())
+		})
+
+		It("should serve the outer API over TLS and pick up rotated certificates", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			existingClient := newClient(nil)
+			Expect(servedSerial(existingClient)).To(Equal(serial))
+
+			By("rotating the certificate and sending SIGHUP")
+			rotatedSerial := certs.writeServerCertificate(certDir, "outer")
+			session.Signal(syscall.SIGHUP)
+			Eventually(session.Out, DEFAULT_TIMEOUT).Should(gbytes.Say("reload-certificate.reloaded"))
+			Expect(servedSerial(newClient(nil))).To(Equal(rotatedSerial))
+
+			By("keeping open connections")
+			Expect(servedSerial(existingClient)).To(Equal(serial))
+
+			By("noticing when the files change")
+			changedSerial := certs.writeServerCertificate(certDir, "outer")
+			Eventually(func() (*big.Int, error) {
+				return servedSerial(newClient(nil))
+			}, DEFAULT_TIMEOUT).Should(Equal(changedSerial))
+		})
+
+		Context("when the minimum version is 1.3", func() {
+			BeforeEach(func() {
+				serverConfig.TLS.MinVersion = "1.3"
+			})
+
+			It("should refuse older clients", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+				_, err := servedSerial(newClient(&tls.Config{MaxVersion: tls.VersionTLS12}))
+				Expect(err).To(HaveOccurred())
+
+				Expect(servedSerial(newClient(nil))).To(Equal(serial))
+			})
+		})
+	})
+
+	Describe("batches", func() {
+		It("should apply all of the changes or none of them", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+
+			By("replacing a rule and adding another")
+			result, err := outerClient.ApplyBatch(models.Batch{
+				Add: []models.Rule{
+					{Source: "group1", Destination: "group3"},
+					{Source: "group3", Destination: "group2"},
+				},
+				Delete: []models.Rule{{ID: "1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(int64(2)))
+			Expect(result.Added).To(Equal([]models.Rule{
+				{ID: "2", Source: "group1", Destination: "group3"},
+				{ID: "3", Source: "group3", Destination: "group2"},
+			}))
+			Expect(result.Deleted).To(Equal([]models.Rule{
+				{ID: "1", Source: "group1", Destination: "group2"},
+			}))
+
+			By("rejecting a batch that deletes a missing rule")
+			_, err = outerClient.ApplyBatch(models.Batch{
+				Add:    []models.Rule{{Source: "group4", Destination: "group5"}},
+				Delete: []models.Rule{{ID: "1"}},
+			})
+			Expect(err).To(MatchError("apply batch: a rule to delete does not exist"))
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+
+			By("rejecting a batch with an invalid rule")
+			_, err = outerClient.ApplyBatch(models.Batch{
+				Add: []models.Rule{
+					{Source: "group4", Destination: "group5"},
+					{Source: "group4"},
+				},
+			})
+			Expect(err).To(MatchError("apply batch: invalid batch: add 1: missing required field(s)"))
+			Expect(err.(client.Error).Invalid()).To(BeTrue())
+
+			By("checking that the rejected batches changed nothing")
+			rules, err := outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal(result.Added))
+			groupRules, err := innerClient.GetWhitelists([]string{"group4", "group5"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groupRules[0].Destination.Tag).To(BeNil())
+			Expect(groupRules[1].Destination.Tag).To(BeNil())
+		})
+	})
+
+	Describe("syncing the rules of an owner", func() {
+		It("should apply only the difference, and preview it on a dry run", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2", Owner: "pipeline"}))).To(Succeed())
+
+			desired := []models.Rule{
+				{Source: "group2", Destination: "group3"},
+				{Source: "group3", Destination: "group4", Protocol: "tcp", StartPort: 443, EndPort: 443},
+			}
+
+			By("previewing the changes")
+			preview, err := outerClient.SyncRules("pipeline", desired, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Added).To(HaveLen(2))
+			Expect(preview.Deleted).To(Equal([]models.Rule{
+				{ID: "2", Source: "group1", Destination: "group2", Owner: "pipeline"},
+			}))
+			rules, err := outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(2))
+
+			By("applying them")
+			result, err := outerClient.SyncRules("pipeline", desired, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(preview.Revision + 1))
+			Expect(result.Deleted).To(Equal(preview.Deleted))
+			Expect(result.Added).To(Equal([]models.Rule{
+				{ID: "3", Source: "group2", Destination: "group3", Owner: "pipeline"},
+				{ID: "4", Source: "group3", Destination: "group4", Protocol: "tcp", StartPort: 443, EndPort: 443, Owner: "pipeline"},
+			}))
+
+			rules, err = outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal(append([]models.Rule{
+				{ID: "1", Source: "group1", Destination: "group2"},
+			}, result.Added...)))
+
+			By("rejecting rules that belong to another owner")
+			_, err = outerClient.SyncRules("pipeline", []models.Rule{
+				{Source: "group2", Destination: "group3", Owner: "someone-else"},
+			}, false)
+			Expect(err).To(MatchError(`sync rules: rule 0 belongs to "someone-else"`))
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+			Expect(err.(client.Error).Details).To(Equal(map[string]interface{}{"index": 0.0, "owner": "someone-else"}))
+		})
+	})
+
+	Describe("groups", func() {
+		It("should give the members of a group its tag and whitelist", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			By("creating a group and adding apps to it")
+			group, err := outerClient.CreateGroup("frontends")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{}}))
+			Expect(added(outerClient.AddRule(models.Rule{Source: "app0", Destination: "frontends"}))).To(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "frontends", Destination: "app3"}))).To(Succeed())
+			group, err = outerClient.AddMembers("frontends", []string{"app2", "app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Members).To(Equal([]string{"app1", "app2"}))
+
+			groups, err := outerClient.ListGroups()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups).To(Equal([]models.Group{group}))
+
+			By("serving the whitelist of the group to its members")
+			whitelists, err := innerClient.GetWhitelists([]string{"frontends", "app1", "app3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[1].Destination.ID).To(Equal("app1"))
+			Expect(whitelists[1].Destination.Group).To(Equal("frontends"))
+			Expect(whitelists[1].Destination.Tag).To(Equal(whitelists[0].Destination.Tag))
+			Expect(whitelists[1].Members).To(Equal([]string{"app1", "app2"}))
+			Expect(whitelists[1].AllowedSources).To(Equal(whitelists[0].AllowedSources))
+			Expect(whitelists[2].AllowedSources).To(ConsistOf(
+				models.AllowedSource{ID: "frontends", Tag: whitelists[0].Destination.Tag},
+			))
+
+			By("keeping an app in one group at a time")
+			_, err = outerClient.CreateGroup("backends")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = outerClient.AddMembers("backends", []string{"app1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(client.Error).Conflict()).To(BeTrue())
+
+			By("recording each change of members as a revision")
+			group, err = outerClient.RemoveMember("frontends", "app1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Members).To(Equal([]string{"app2"}))
+			history, err := outerClient.History()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(4))
+			Expect(history[3].Action).To(Equal(models.ChangeGroup))
+			Expect(history[3].Group).To(Equal("frontends"))
+
+			whitelists, err = innerClient.GetWhitelists([]string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Group).To(BeEmpty())
+			Expect(whitelists[0].AllowedSources).To(BeEmpty())
+
+			_, err = outerClient.RemoveMember("frontends", "app1")
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+		})
+	})
+
+	Describe("protocol and port rules", func() {
+		It("should carry the permitted ports to the whitelists", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			rule := models.Rule{Source: "group1", Destination: "group2", Protocol: "tcp", StartPort: 8080, EndPort: 8090}
+			rule, created, err := outerClient.AddRule(rule)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+
+			rules, err := outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{rule}))
+
+			groupRules, err := innerClient.GetWhitelists([]string{"group2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groupRules[0].AllowedSources).To(HaveLen(1))
+			Expect(groupRules[0].AllowedSources[0].Protocol).To(Equal("tcp"))
+			Expect(groupRules[0].AllowedSources[0].StartPort).To(Equal(8080))
+			Expect(groupRules[0].AllowedSources[0].EndPort).To(Equal(8090))
+
+			By("rejecting rules with invalid ports")
+			_, _, err = outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2", Protocol: "icmp", StartPort: 1, EndPort: 1})
+			Expect(err).To(MatchError("add rule: invalid rule: ports are only allowed with tcp or udp"))
+
+			By("deleting only the rule with matching ports")
+			err = outerClient.DeleteRule(models.Rule{Source: "group1", Destination: "group2"})
+			Expect(err).To(MatchError("delete rule: the rule to delete does not exist"))
+			Expect(err.(client.Error).NotFound()).To(BeTrue())
+
+			By("deleting the rule by its ID")
+			Expect(outerClient.DeleteRule(models.Rule{ID: rule.ID})).To(Succeed())
+			rules, err = outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(BeEmpty())
+		})
+	})
+
+	Describe("watching whitelists", func() {
+		BeforeEach(func() {
+			serverConfig.LongPollSeconds = 1
+		})
+
+		It("should answer a long poll with 304 when nothing changes", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			url := fmt.Sprintf("https://%s/whitelists?groups=group1&wait_for_revision=0", innerAddress)
+			started := time.Now()
+			resp, err := innerHTTPClient.Get(url)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+			Expect(resp.Header.Get("X-Policy-Revision")).To(Equal("0"))
+			Expect(resp.Header.Get("ETag")).NotTo(BeEmpty())
+			Expect(time.Since(started)).To(BeNumerically(">=", 900*time.Millisecond))
+		})
+
+		It("should deliver an update only when the watched whitelists change", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			stop := make(chan struct{})
+			defer close(stop)
+			updates, errs := innerClient.WatchWhitelists([]string{"group1", "group2"}, stop)
+
+			var update client.WhitelistUpdate
+			Eventually(updates, DEFAULT_TIMEOUT).Should(Receive(&update))
+			Expect(update.Revision).To(Equal(int64(0)))
+			Expect(update.Whitelists).To(HaveLen(2))
+			Expect(update.Whitelists[1].AllowedSources).To(BeEmpty())
+
+			By("changing rules for other groups")
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group3", Destination: "group4"}))).To(Succeed())
+			Consistently(updates, "1500ms").ShouldNot(Receive())
+
+			By("changing rules for the watched groups")
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Eventually(updates, DEFAULT_TIMEOUT).Should(Receive(&update))
+			Expect(update.Revision).To(Equal(int64(2)))
+			Expect(update.Whitelists[1].AllowedSources).To(HaveLen(1))
+			Expect(update.Whitelists[1].AllowedSources[0].ID).To(Equal("group1"))
+
+			Expect(errs).NotTo(Receive())
+		})
+	})
+
+	Describe("event stream", func() {
+		type sseEvent struct {
+			ID   string
+			Type string
+			Data models.Event
+		}
+
+		var streams []io.Closer
+
+		openStream := func(lastEventID string) <-chan sseEvent {
+			req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/events", innerAddress), nil)
+			Expect(err).NotTo(HaveOccurred())
+			if lastEventID != "" {
+				req.Header.Set("Last-Event-ID", lastEventID)
+			}
+			resp, err := innerHTTPClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			streams = append(streams, resp.Body)
+
+			received := make(chan sseEvent, 100)
+			go func() {
+				defer GinkgoRecover()
+				scanner := bufio.NewScanner(resp.Body)
+				var event sseEvent
+				for scanner.Scan() {
+					line := scanner.Text()
+					switch {
+					case strings.HasPrefix(line, "id: "):
+						event.ID = strings.TrimPrefix(line, "id: ")
+					case strings.HasPrefix(line, "event: "):
+						event.Type = strings.TrimPrefix(line, "event: ")
+					case strings.HasPrefix(line, "data: "):
+						json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data)
+					case line == "" && event.Type != "":
+						received <- event
+						event = sseEvent{}
+					}
+				}
+			}()
+			return received
+		}
+
+		AfterEach(func() {
+			for _, s := range streams {
+				s.Close()
+			}
+			streams = nil
+		})
+
+		It("should push changes and let clients resume where they left off", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+			stream := openStream("")
+
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+
+			var event sseEvent
+			Eventually(stream, DEFAULT_TIMEOUT).Should(Receive(&event))
+			Expect(event.Type).To(Equal("rule-added"))
+			Expect(event.Data.Revision).To(Equal(int64(1)))
+			Expect(*event.Data.Rule).To(Equal(models.Rule{ID: "1", Source: "group1", Destination: "group2"}))
+			firstID := event.ID
+
+			Eventually(stream, DEFAULT_TIMEOUT).Should(Receive(&event))
+			Expect(event.Type).To(Equal("tag-assigned"))
+			Expect(event.Data.Group).To(Equal("group1"))
+			Eventually(stream, DEFAULT_TIMEOUT).Should(Receive(&event))
+			Expect(event.Data.Group).To(Equal("group2"))
+
+			Expect(outerClient.DeleteRule(models.Rule{Source: "group1", Destination: "group2"})).To(Succeed())
+			Eventually(stream, DEFAULT_TIMEOUT).Should(Receive(&event))
+			Expect(event.Type).To(Equal("rule-deleted"))
+			Expect(event.Data.Revision).To(Equal(int64(2)))
+
+			By("resuming from the first event")
+			resumed := openStream(firstID)
+			types := []string{}
+			for i := 0; i < 3; i++ {
+				Eventually(resumed, DEFAULT_TIMEOUT).Should(Receive(&event))
+				types = append(types, event.Type)
+			}
+			Expect(types).To(Equal([]string{"tag-assigned", "tag-assigned", "rule-deleted"}))
+
+			By("resuming from an unknown event")
+			reset := openStream("some-unknown-id")
+			Eventually(reset, DEFAULT_TIMEOUT).Should(Receive(&event))
+			Expect(event.Type).To(Equal("reset"))
+		})
+	})
+
+	Context("when the packet tag space is exhausted", func() {
+		BeforeEach(func() {
+			serverConfig.TagBits = 2
+		})
+
+		It("should reject rules that need a new tag", func() {
+			Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(added(outerClient.AddRule(models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+
+			_, _, err := outerClient.AddRule(models.Rule{Source: "group3", Destination: "group4"})
+			Expect(err).To(MatchError("add rule: packet tag space exhausted: all 3 tags of 2 bits are in use or in quarantine"))
+			Expect(err.(client.Error).TagSpaceExhausted()).To(BeTrue())
+			Expect(err.(client.Error).StatusCode).To(Equal(http.StatusServiceUnavailable))
+
+			rules, err := outerClient.ListRules(client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(2))
+
+			groupRules, err := innerClient.GetWhitelists([]string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*groupRules[0].Destination.Tag).To(HaveLen(1))
+		})
+	})
+
+	Describe("persistence", func() {
+		var dataDir string
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "policy-server-data")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
+
+		itPreservesStateAcrossRestart := func() {
+			It("should preserve rules and packet tags across a restart", func() {
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+				By("adding some rules")
+				Expect(added(outerClient.AddRule(models.Rule{
+					Source:      "group1",
+					Destination: "group2",
+				}))).To(Succeed())
+				Expect(added(outerClient.AddRule(models.Rule{
+					Source:      "group2",
+					Destination: "group3",
+				}))).To(Succeed())
+
+				whitelistsBefore, err := innerClient.GetWhitelists([]string{"group1", "group2", "group3"})
+				Expect(err).NotTo(HaveOccurred())
+
+				By("restarting the server")
+				session.Interrupt()
+				Eventually(session, DEFAULT_TIMEOUT).Should(gexec.Exit(0))
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).ShouldNot(Succeed())
+				startServer()
+				Eventually(serverIsAvailable, DEFAULT_TIMEOUT).Should(Succeed())
+
+				By("checking that the rules survived")
+				rules, err := outerClient.ListRules(client.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).To(ConsistOf([]models.Rule{
+					{ID: "1", Source: "group1", Destination: "group2"},
+					{ID: "2", Source: "group2", Destination: "group3"},
+				}))
+
+				By("checking that the packet tags survived")
+				whitelistsAfter, err := innerClient.GetWhitelists([]string{"group1", "group2", "group3"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(whitelistsAfter).To(Equal(whitelistsBefore))
+
+				By("allocating a fresh tag and ID for a new rule")
+				rule, _, err := outerClient.AddRule(models.Rule{
+					Source:      "group3",
+					Destination: "group4",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rule.ID).To(Equal("3"))
+				whitelists, err := innerClient.GetWhitelists([]string{"group4"})
+				Expect(err).NotTo(HaveOccurred())
+				for _, w := range whitelistsBefore {
+					Expect(*whitelists[0].Destination.Tag).NotTo(Equal(*w.Destination.Tag))
+				}
+			})
+		}
+
+		Context("when the server is configured with a sql store", func() {
+			BeforeEach(func() {
+				serverConfig.Store = config.StoreConfig{
+					Type:           config.StoreTypeSQL,
+					DriverName:     "sqlite3",
+					DataSourceName: filepath.Join(dataDir, "policy.db"),
+				}
+			})
+
+			itPreservesStateAcrossRestart()
+		})
+
+		Context("when the server is configured with a file store", func() {
+			BeforeEach(func() {
+				serverConfig.Store = config.StoreConfig{
+					Type:      config.StoreTypeFile,
+					Directory: dataDir,
+				}
+			})
+
+			itPreservesStateAcrossRestart()
 		})
 	})
 })
@@ -0,0 +1,182 @@
+package acceptance_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]*openAPISchema   `json:"schemas"`
+		Responses map[string]*openAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Nullable             bool                      `json:"nullable"`
+	Enum                 []interface{}             `json:"enum"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	AdditionalProperties *bool                     `json:"additionalProperties"`
+	Items                *openAPISchema            `json:"items"`
+	Minimum              *float64                  `json:"minimum"`
+	Maximum              *float64                  `json:"maximum"`
+}
+
+func parseOpenAPI(document []byte) (*openAPIDocument, error) {
+	doc := &openAPIDocument{}
+	if err := json.Unmarshal(document, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (d *openAPIDocument) Validate(method, path string, resp *http.Response, body []byte) error {
+	raw, ok := d.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+	var operation openAPIOperation
+	if err := json.Unmarshal(raw, &operation); err != nil {
+		return err
+	}
+
+	response, ok := operation.Responses[strconv.Itoa(resp.StatusCode)]
+	if !ok {
+		return fmt.Errorf("%s %s: status %d is not documented", method, path, resp.StatusCode)
+	}
+	if ref := response.Ref; ref != "" {
+		response, ok = d.Components.Responses[strings.TrimPrefix(ref, "#/components/responses/")]
+		if !ok {
+			return fmt.Errorf("%s %s: unknown response %s", method, path, ref)
+		}
+	}
+
+	if len(response.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s %s: status %d should have no body", method, path, resp.StatusCode)
+		}
+		return nil
+	}
+	content, ok := response.Content[resp.Header.Get("Content-Type")]
+	if !ok {
+		return fmt.Errorf("%s %s: undocumented content type %q", method, path, resp.Header.Get("Content-Type"))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("%s %s: %s", method, path, err)
+	}
+	return d.check(content.Schema, value, "body")
+}
+
+func (d *openAPIDocument) check(schema *openAPISchema, value interface{}, at string) error {
+	if schema.Ref != "" {
+		resolved, ok := d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, schema.Ref)
+		}
+		schema = resolved
+	}
+
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, schema.Enum)
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, value)
+		}
+		return d.checkObject(schema, object, at)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, value)
+		}
+		for i, item := range array {
+			if err := d.check(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: %v is not a string", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, value)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (schema.Type == "integer" && number != math.Trunc(number)) {
+			return fmt.Errorf("%s: %v is not an %s", at, value, schema.Type)
+		}
+		if (schema.Minimum != nil && number < *schema.Minimum) || (schema.Maximum != nil && number > *schema.Maximum) {
+			return fmt.Errorf("%s: %v is out of range", at, value)
+		}
+	}
+	return nil
+}
+
+func (d *openAPIDocument) checkObject(schema *openAPISchema, object map[string]interface{}, at string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing %s", at, name)
+		}
+	}
+
+	names := []string{}
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				return fmt.Errorf("%s: undocumented property %s", at, name)
+			}
+			continue
+		}
+		if err := d.check(property, object[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
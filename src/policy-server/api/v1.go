@@ -0,0 +1,416 @@
+package api
+
+const V1 = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Policy server",
+    "version": "1",
+    "description": "Rules that allow traffic from one group of apps to another."
+  },
+  "security": [{"bearer": []}],
+  "paths": {
+    "/v1/policies": {
+      "get": {
+        "operationId": "listPolicies",
+        "summary": "List the rules that the user can see, in the order of their IDs",
+        "parameters": [
+          {"name": "source", "in": "query", "schema": {"type": "string"}},
+          {"name": "destination", "in": "query", "schema": {"type": "string"}},
+          {"name": "group", "in": "query", "description": "The source or the destination", "schema": {"type": "string"}},
+          {"name": "owner", "in": "query", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "description": "The most rules to return; all of them if not given", "schema": {"type": "integer", "minimum": 1, "maximum": 1000}},
+          {"name": "cursor", "in": "query", "description": "Where to start, from the next link of the previous page", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The rules",
+            "headers": {"Link": {"description": "The next page, as rel=\"next\", if there is one", "schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "createPolicy",
+        "summary": "Add a rule",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rule"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The new rule, whose URL is in the Location header",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rule"}}}
+          },
+          "200": {
+            "description": "An equal rule that already existed",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rule"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/policies/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "operationId": "getPolicy",
+        "summary": "Get a rule that the user can see",
+        "responses": {
+          "200": {
+            "description": "The rule",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rule"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "operationId": "deletePolicy",
+        "summary": "Delete a rule",
+        "responses": {
+          "204": {"description": "The rule was deleted"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/policies/batch": {
+      "post": {
+        "operationId": "batchPolicies",
+        "summary": "Delete and add rules under one revision, or change nothing",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Batch"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The rules that were added and deleted",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchResult"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/policies/history": {
+      "get": {
+        "operationId": "policyHistory",
+        "summary": "List the most recent changes to the rules, oldest first; admins only",
+        "responses": {
+          "200": {
+            "description": "The changes",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Change"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/policies/rollback": {
+      "post": {
+        "operationId": "rollbackPolicies",
+        "summary": "Restore the rules of a revision as a new revision; admins only",
+        "parameters": [{"name": "revision", "in": "query", "required": true, "schema": {"type": "integer"}}],
+        "responses": {
+          "200": {
+            "description": "The rules that were added and deleted",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchResult"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/owners/{owner}/policies": {
+      "put": {
+        "operationId": "syncPolicies",
+        "summary": "Replace every rule of an owner",
+        "parameters": [
+          {"name": "owner", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "dry_run", "in": "query", "schema": {"type": "boolean"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The rules that were, or with dry_run would be, added and deleted",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchResult"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/groups": {
+      "get": {
+        "operationId": "listGroups",
+        "summary": "List the groups that the user can see a member of, and the empty groups, by name",
+        "responses": {
+          "200": {
+            "description": "The groups, with the members that the user can see",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "required": ["groups"],
+              "properties": {"groups": {"type": "array", "items": {"$ref": "#/components/schemas/Group"}}}
+            }}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "operationId": "createGroup",
+        "summary": "Create an empty group, which rules can name in place of an app",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The new group, whose URL is in the Location header",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/groups/{name}": {
+      "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "operationId": "getGroup",
+        "summary": "Get a group that the user can see",
+        "responses": {
+          "200": {
+            "description": "The group, with the members that the user can see",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/groups/{name}/members": {
+      "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "operationId": "addGroupMembers",
+        "summary": "Add apps to a group, which then share its packet tag and whitelist",
+        "description": "The user must manage every member of the group and every app to add.  An app may be in one group at a time.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupMembers"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The group",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/groups/{name}/members/{member}": {
+      "parameters": [
+        {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+        {"name": "member", "in": "path", "required": true, "schema": {"type": "string"}}
+      ],
+      "delete": {
+        "operationId": "removeGroupMember",
+        "summary": "Remove an app from a group; the user must manage the app or the whole group",
+        "responses": {
+          "200": {
+            "description": "The group, with the members that the user can see",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Group"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/audit": {
+      "get": {
+        "operationId": "listAudit",
+        "summary": "List the audit records of rule and group changes, oldest first; admins only",
+        "parameters": [
+          {"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "to", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "actor", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The records",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuditRecord"}}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "501": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/spec": {
+      "get": {
+        "operationId": "getSpec",
+        "summary": "This document",
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "The OpenAPI document of this version of the API",
+            "content": {"application/json": {"schema": {"type": "object"}}}
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+    },
+    "responses": {
+      "Error": {
+        "description": "The request was refused or failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "Rule": {
+        "type": "object",
+        "required": ["group1", "group2"],
+        "properties": {
+          "id": {"type": "string", "readOnly": true},
+          "group1": {"type": "string", "description": "The source app or group"},
+          "group2": {"type": "string", "description": "The destination app or group"},
+          "protocol": {"type": "string", "enum": ["tcp", "udp", "icmp"]},
+          "start_port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "end_port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "owner": {"type": "string"}
+        },
+        "additionalProperties": false
+      },
+      "Batch": {
+        "type": "object",
+        "properties": {
+          "add": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}},
+          "delete": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}}
+        }
+      },
+      "BatchResult": {
+        "type": "object",
+        "required": ["revision", "added", "deleted"],
+        "properties": {
+          "revision": {"type": "integer"},
+          "added": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}},
+          "deleted": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}}
+        },
+        "additionalProperties": false
+      },
+      "Change": {
+        "type": "object",
+        "required": ["revision", "timestamp", "actor", "action", "added", "deleted"],
+        "properties": {
+          "revision": {"type": "integer"},
+          "timestamp": {"type": "string", "format": "date-time"},
+          "actor": {"type": "string"},
+          "action": {"type": "string", "enum": ["add", "delete", "batch", "sync", "rollback", "group"]},
+          "rollback_to": {"type": "integer"},
+          "group": {"type": "string", "description": "The group whose members a group change changed"},
+          "added": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}},
+          "deleted": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}}
+        },
+        "additionalProperties": false
+      },
+      "Group": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string", "pattern": "^[A-Za-z0-9._-]+$", "maxLength": 255},
+          "members": {"type": "array", "readOnly": true, "items": {"type": "string"}}
+        },
+        "additionalProperties": false
+      },
+      "GroupMembers": {
+        "type": "object",
+        "required": ["members"],
+        "properties": {
+          "members": {"type": "array", "minItems": 1, "items": {"type": "string", "minLength": 1}}
+        },
+        "additionalProperties": false
+      },
+      "AuditRecord": {
+        "type": "object",
+        "required": ["timestamp", "action", "actor", "actor_id", "source_ip", "outcome", "status"],
+        "properties": {
+          "timestamp": {"type": "string", "format": "date-time"},
+          "action": {"type": "string"},
+          "actor": {"type": "string"},
+          "actor_id": {"type": "string"},
+          "source_ip": {"type": "string"},
+          "outcome": {
+            "type": "string",
+            "enum": ["success", "invalid", "unauthenticated", "forbidden", "not-found", "conflict", "tags-exhausted", "error"]
+          },
+          "status": {"type": "integer"},
+          "owner": {"type": "string"},
+          "dry_run": {"type": "boolean"},
+          "add": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}},
+          "delete": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}},
+          "group": {"type": "string"},
+          "members": {"type": "array", "items": {"type": "string"}},
+          "revision": {"type": "integer"}
+        },
+        "additionalProperties": false
+      },
+      "Error": {
+        "type": "object",
+        "required": ["code", "message"],
+        "properties": {
+          "code": {
+            "type": "string",
+            "enum": ["invalid_request", "unauthorized", "forbidden", "not_found", "conflict", "tags_exhausted", "not_implemented", "internal_error"]
+          },
+          "message": {"type": "string"},
+          "details": {"type": "object"}
+        },
+        "additionalProperties": false
+      }
+    }
+  }
+}
+`
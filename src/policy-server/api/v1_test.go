@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"encoding/json"
+	"policy-server/api"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func refs(value interface{}) []string {
+	found := []string{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				found = append(found, ref)
+				continue
+			}
+			found = append(found, refs(child)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			found = append(found, refs(child)...)
+		}
+	}
+	return found
+}
+
+var _ = Describe("V1", func() {
+	var document map[string]interface{}
+
+	BeforeEach(func() {
+		document = nil
+		Expect(json.Unmarshal([]byte(api.V1), &document)).To(Succeed())
+	})
+
+	It("should be an OpenAPI 3 document", func() {
+		Expect(document).To(HaveKeyWithValue("openapi", "3.0.3"))
+		Expect(document).To(HaveKey("info"))
+		Expect(document).To(HaveKey("paths"))
+	})
+
+	It("should only document versioned paths", func() {
+		paths := document["paths"].(map[string]interface{})
+		Expect(paths).NotTo(BeEmpty())
+		for path := range paths {
+			Expect(path).To(HavePrefix("/v1/"))
+		}
+	})
+
+	It("should only refer to components that it defines", func() {
+		components := document["components"].(map[string]interface{})
+
+		found := refs(document)
+		Expect(found).NotTo(BeEmpty())
+		for _, ref := range found {
+			parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+			Expect(parts).To(HaveLen(2), ref)
+			Expect(components).To(HaveKey(parts[0]), ref)
+			Expect(components[parts[0]]).To(HaveKey(parts[1]), ref)
+		}
+	})
+})
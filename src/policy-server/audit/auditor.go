@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+type Log interface {
+	Record(record Record) error
+	Query(filter Filter) ([]Record, error)
+}
+
+type contextKey struct{}
+
+func RecordFromRequest(req *http.Request) *Record {
+	record, ok := req.Context().Value(contextKey{}).(*Record)
+	if !ok {
+		return &Record{}
+	}
+	return record
+}
+
+type Auditor struct {
+	Log    Log
+	Logger lager.Logger
+	Clock  clock.Clock
+}
+
+func (a *Auditor) Wrap(action string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		record := &Record{Action: action, SourceIP: sourceIP(req)}
+		recorder := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+
+		ctx := context.WithValue(req.Context(), contextKey{}, record)
+		handler.ServeHTTP(recorder, req.WithContext(ctx))
+
+		record.Time = a.Clock.Now()
+		record.Status = recorder.status
+		record.Outcome = outcome(recorder.status)
+		if err := a.Log.Record(*record); err != nil {
+			a.Logger.Error("audit-record", err, lager.Data{"action": action, "outcome": record.Outcome})
+		}
+	})
+}
+
+func sourceIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,125 @@
+package audit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"policy-server/audit"
+	"policy-server/models"
+	"time"
+
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLog struct {
+	records []audit.Record
+	err     error
+}
+
+func (l *fakeLog) Record(record audit.Record) error {
+	l.records = append(l.records, record)
+	return l.err
+}
+
+func (l *fakeLog) Query(filter audit.Filter) ([]audit.Record, error) {
+	return l.records, nil
+}
+
+var _ = Describe("Auditor", func() {
+	var (
+		log       *fakeLog
+		fakeClock *fakeclock.FakeClock
+		auditor   *audit.Auditor
+		request   *http.Request
+	)
+
+	BeforeEach(func() {
+		log = &fakeLog{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		auditor = &audit.Auditor{
+			Log:    log,
+			Logger: lagertest.NewTestLogger("test"),
+			Clock:  fakeClock,
+		}
+
+		var err error
+		request, err = http.NewRequest("POST", "/rules/add", nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.RemoteAddr = "10.0.0.1:54321"
+	})
+
+	It("records what the handler filled in along with the outcome", func() {
+		rule := models.Rule{ID: "1", Source: "app-a", Destination: "app-b"}
+		handler := auditor.Wrap("add", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			record := audit.RecordFromRequest(req)
+			record.Actor, record.ActorID = "some-user", "some-user-guid"
+			record.Add = []models.Rule{rule}
+			record.Revision = 3
+			resp.WriteHeader(http.StatusCreated)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(log.records).To(Equal([]audit.Record{{
+			Time:     fakeClock.Now(),
+			Action:   "add",
+			Actor:    "some-user",
+			ActorID:  "some-user-guid",
+			SourceIP: "10.0.0.1",
+			Outcome:  audit.OutcomeSuccess,
+			Status:   http.StatusCreated,
+			Add:      []models.Rule{rule},
+			Revision: 3,
+		}}))
+	})
+
+	It("records requests that are rejected", func() {
+		for status, outcome := range map[int]string{
+			http.StatusBadRequest:          audit.OutcomeInvalid,
+			http.StatusUnauthorized:        audit.OutcomeUnauthenticated,
+			http.StatusForbidden:           audit.OutcomeForbidden,
+			http.StatusNotFound:            audit.OutcomeNotFound,
+			http.StatusConflict:            audit.OutcomeConflict,
+			http.StatusServiceUnavailable:  audit.OutcomeTagsExhausted,
+			http.StatusInternalServerError: audit.OutcomeError,
+		} {
+			log.records = nil
+			status := status
+			handler := auditor.Wrap("batch", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(status)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(log.records).To(HaveLen(1))
+			Expect(log.records[0].Outcome).To(Equal(outcome))
+			Expect(log.records[0].Status).To(Equal(status))
+		}
+	})
+
+	It("treats a response without an explicit status as a success", func() {
+		handler := auditor.Wrap("delete", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Write([]byte("ok"))
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		Expect(log.records[0].Outcome).To(Equal(audit.OutcomeSuccess))
+	})
+
+	It("still answers the request if the record cannot be written", func() {
+		log.err = errors.New("disk full")
+		handler := auditor.Wrap("add", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.WriteHeader(http.StatusCreated)
+		}))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, request)
+		Expect(resp.Code).To(Equal(http.StatusCreated))
+	})
+
+	It("gives unaudited requests a record that goes nowhere", func() {
+		record := audit.RecordFromRequest(request)
+		record.Actor = "some-user"
+		Expect(audit.RecordFromRequest(request).Actor).To(BeEmpty())
+	})
+})
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type FileLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileLog(path string, maxSize int64, maxBackups int) (*FileLog, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("max size must be positive")
+	}
+	l := &FileLog{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat audit log: %s", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *FileLog) Record(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %s", err)
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write audit record: %s", err)
+	}
+	return l.file.Sync()
+}
+
+// caller must hold the lock
+func (l *FileLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("close audit log: %s", err)
+	}
+
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil {
+			return fmt.Errorf("rotate audit log: %s", err)
+		}
+		return l.open()
+	}
+
+	for i := l.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(l.backup(i), l.backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate audit log: %s", err)
+		}
+	}
+	if err := os.Rename(l.path, l.backup(1)); err != nil {
+		return fmt.Errorf("rotate audit log: %s", err)
+	}
+	return l.open()
+}
+
+func (l *FileLog) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+func (l *FileLog) Query(filter Filter) ([]Record, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	records := []Record{}
+	paths := []string{}
+	for i := l.maxBackups; i > 0; i-- {
+		paths = append(paths, l.backup(i))
+	}
+	paths = append(paths, l.path)
+
+	for _, path := range paths {
+		var err error
+		records, err = readRecords(path, filter, records)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func readRecords(path string, filter Filter, records []Record) ([]Record, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %s", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("parse audit log %s: %s", path, err)
+		}
+		if filter.Matches(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log %s: %s", path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,116 @@
+package audit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"policy-server/audit"
+	"policy-server/models"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileLog", func() {
+	var (
+		dir     string
+		path    string
+		fileLog *audit.FileLog
+		start   time.Time
+	)
+
+	record := func(minutes int, actor string) audit.Record {
+		return audit.Record{
+			Time:     start.Add(time.Duration(minutes) * time.Minute),
+			Action:   "add",
+			Actor:    actor,
+			ActorID:  actor + "-guid",
+			SourceIP: "10.0.0.1",
+			Outcome:  audit.OutcomeSuccess,
+			Status:   201,
+			Add:      []models.Rule{{ID: "1", Source: "app-a", Destination: "app-b"}},
+			Revision: 1,
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "audit.log")
+		start = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+		fileLog, err = audit.NewFileLog(path, 1024*1024, 2)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reads back the records, oldest first", func() {
+		Expect(fileLog.Record(record(0, "alice"))).To(Succeed())
+		Expect(fileLog.Record(record(1, "bob"))).To(Succeed())
+
+		records, err := fileLog.Query(audit.Filter{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal([]audit.Record{record(0, "alice"), record(1, "bob")}))
+	})
+
+	It("filters by time range and actor", func() {
+		for i, actor := range []string{"alice", "bob", "alice", "alice"} {
+			Expect(fileLog.Record(record(i, actor))).To(Succeed())
+		}
+
+		records, err := fileLog.Query(audit.Filter{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal([]audit.Record{record(1, "bob"), record(2, "alice")}))
+
+		records, err = fileLog.Query(audit.Filter{From: start.Add(time.Minute), Actor: "alice"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal([]audit.Record{record(2, "alice"), record(3, "alice")}))
+
+		By("matching actors by ID too")
+		records, err = fileLog.Query(audit.Filter{Actor: "bob-guid"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(Equal([]audit.Record{record(1, "bob")}))
+	})
+
+	It("appends to an existing log", func() {
+		Expect(fileLog.Record(record(0, "alice"))).To(Succeed())
+
+		reopened, err := audit.NewFileLog(path, 1024*1024, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reopened.Record(record(1, "bob"))).To(Succeed())
+
+		records, err := reopened.Query(audit.Filter{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+	})
+
+	Context("when the log reaches its maximum size", func() {
+		BeforeEach(func() {
+			var err error
+			// room for two records per file
+			fileLog, err = audit.NewFileLog(path, 600, 2)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rotates it and keeps only the newest backups", func() {
+			for i := 0; i < 7; i++ {
+				Expect(fileLog.Record(record(i, "alice"))).To(Succeed())
+			}
+
+			Expect(filepath.Join(dir, "audit.log.1")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "audit.log.2")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "audit.log.3")).NotTo(BeAnExistingFile())
+
+			records, err := fileLog.Query(audit.Filter{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(Equal([]audit.Record{
+				record(2, "alice"), record(3, "alice"), record(4, "alice"), record(5, "alice"), record(6, "alice"),
+			}))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"net/http"
+	"policy-server/models"
+	"strings"
+	"time"
+)
+
+const (
+	OutcomeSuccess         = "success"
+	OutcomeInvalid         = "invalid"
+	OutcomeUnauthenticated = "unauthenticated"
+	OutcomeForbidden       = "forbidden"
+	OutcomeNotFound        = "not-found"
+	OutcomeConflict        = "conflict"
+	OutcomeTagsExhausted   = "tags-exhausted"
+	OutcomeError           = "error"
+)
+
+type Record struct {
+	Time     time.Time     `json:"timestamp"`
+	Action   string        `json:"action"`
+	Actor    string        `json:"actor"`
+	ActorID  string        `json:"actor_id"`
+	SourceIP string        `json:"source_ip"`
+	Outcome  string        `json:"outcome"`
+	Status   int           `json:"status"`
+	Owner    string        `json:"owner,omitempty"`
+	DryRun   bool          `json:"dry_run,omitempty"`
+	Add      []models.Rule `json:"add,omitempty"`
+	Delete   []models.Rule `json:"delete,omitempty"`
+	Group    string        `json:"group,omitempty"`
+	Members  []string      `json:"members,omitempty"`
+	Revision int64         `json:"revision,omitempty"`
+}
+
+func outcome(status int) string {
+	switch status {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return OutcomeSuccess
+	case http.StatusBadRequest:
+		return OutcomeInvalid
+	case http.StatusUnauthorized:
+		return OutcomeUnauthenticated
+	case http.StatusForbidden:
+		return OutcomeForbidden
+	case http.StatusNotFound:
+		return OutcomeNotFound
+	case http.StatusConflict:
+		return OutcomeConflict
+	case http.StatusServiceUnavailable:
+		return OutcomeTagsExhausted
+	default:
+		return OutcomeError
+	}
+}
+
+type Filter struct {
+	From  time.Time
+	To    time.Time
+	Actor string
+}
+
+func (f Filter) Matches(record Record) bool {
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && record.Time.After(f.To) {
+		return false
+	}
+	if f.Actor != "" && !strings.EqualFold(f.Actor, record.Actor) && f.Actor != record.ActorID {
+		return false
+	}
+	return true
+}
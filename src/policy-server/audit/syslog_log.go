@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+)
+
+var ErrQueryUnsupported = errors.New("this audit log cannot be queried")
+
+type SyslogLog struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogLog(network, address, tag string) (*SyslogLog, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_AUTHPRIV|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %s", err)
+	}
+	return &SyslogLog{writer: writer}, nil
+}
+
+func (l *SyslogLog) Record(record Record) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %s", err)
+	}
+	return l.writer.Info(string(payload))
+}
+
+func (l *SyslogLog) Query(filter Filter) ([]Record, error) {
+	return nil, ErrQueryUnsupported
+}
+
+type DiscardLog struct{}
+
+func (DiscardLog) Record(record Record) error {
+	return nil
+}
+
+func (DiscardLog) Query(filter Filter) ([]Record, error) {
+	return nil, ErrQueryUnsupported
+}
@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Auth Suite")
+}
+
+var signingKey *rsa.PrivateKey
+
+var _ = BeforeSuite(func() {
+	var err error
+	signingKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+})
+
+func encodeSegment(value interface{}) string {
+	payload, err := json.Marshal(value)
+	Expect(err).NotTo(HaveOccurred())
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func signTokenWith(key *rsa.PrivateKey, algorithm string, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": algorithm, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signToken(claims map[string]interface{}) string {
+	return signTokenWith(signingKey, "RS256", claims)
+}
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"fmt"
+	"policy-server/models"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type cloudController interface {
+	AppSpaceGUID(token, appGUID string) (string, error)
+	AppExists(token, appGUID string) (bool, error)
+	DeveloperSpaceGUIDs(token, userGUID string) ([]string, error)
+}
+
+type groupLookup interface {
+	GetGroup(logger lager.Logger, name string) (models.Group, error)
+}
+
+type notFoundError interface {
+	NotFound() bool
+}
+
+type ForbiddenError struct {
+	Rule models.Rule
+}
+
+func (e ForbiddenError) Error() string {
+	return fmt.Sprintf("may not manage rule from %s to %s", e.Rule.Source, e.Rule.Destination)
+}
+
+func (e ForbiddenError) Forbidden() bool {
+	return true
+}
+
+type GroupForbiddenError struct {
+	Group string
+	App   string
+}
+
+func (e GroupForbiddenError) Error() string {
+	if e.App == "" {
+		return fmt.Sprintf("may not manage the members of group %s", e.Group)
+	}
+	return fmt.Sprintf("may not manage %s in group %s", e.App, e.Group)
+}
+
+func (e GroupForbiddenError) Forbidden() bool {
+	return true
+}
+
+type GroupNameError struct {
+	Group string
+}
+
+func (e GroupNameError) Error() string {
+	return fmt.Sprintf("%s is an app", e.Group)
+}
+
+func (e GroupNameError) Conflict() bool {
+	return true
+}
+
+type Authorizer struct {
+	CloudController cloudController
+	AdminScope      string
+	Groups          groupLookup
+}
+
+func (a *Authorizer) Authorize(logger lager.Logger, user User, rules []models.Rule) error {
+	if a.IsAdmin(user) {
+		return nil
+	}
+
+	p := a.permissions(logger, user)
+	for _, rule := range rules {
+		for _, app := range []string{rule.Source, rule.Destination} {
+			ok, err := p.canManage(app)
+			if err != nil {
+				logger.Error("can-manage", err, lager.Data{"app": app})
+				return err
+			}
+			if !ok {
+				return ForbiddenError{Rule: rule}
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) Visible(logger lager.Logger, user User, rules []models.Rule) ([]models.Rule, error) {
+	return a.FirstVisible(logger, user, rules, len(rules))
+}
+
+// rules after the nth visible one are not checked
+func (a *Authorizer) FirstVisible(logger lager.Logger, user User, rules []models.Rule, n int) ([]models.Rule, error) {
+	if a.IsAdmin(user) {
+		if len(rules) > n {
+			return rules[:n], nil
+		}
+		return rules, nil
+	}
+
+	p := a.permissions(logger, user)
+	visible := []models.Rule{}
+	for _, rule := range rules {
+		if len(visible) == n {
+			break
+		}
+		for _, app := range []string{rule.Source, rule.Destination} {
+			ok, err := p.canSee(app)
+			if err != nil {
+				logger.Error("can-see", err, lager.Data{"app": app})
+				return nil, err
+			}
+			if ok {
+				visible = append(visible, rule)
+				break
+			}
+		}
+	}
+	return visible, nil
+}
+
+func (a *Authorizer) AuthorizeMembers(logger lager.Logger, user User, group string, apps []string) error {
+	if a.IsAdmin(user) {
+		return nil
+	}
+
+	p := a.permissions(logger, user)
+	members, _, err := p.members(group)
+	if err != nil {
+		logger.Error("group-members", err, lager.Data{"group": group})
+		return err
+	}
+	for _, app := range append(append([]string{}, members...), apps...) {
+		ok, err := p.canManage(app)
+		if err != nil {
+			logger.Error("can-manage", err, lager.Data{"app": app})
+			return err
+		}
+		if !ok {
+			return GroupForbiddenError{Group: group}
+		}
+	}
+	return nil
+}
+
+// a group named after an app would take over its rules, so not even admins may create one
+func (a *Authorizer) AuthorizeCreate(logger lager.Logger, user User, group string) error {
+	exists, err := a.CloudController.AppExists(user.Token, group)
+	if err != nil {
+		logger.Error("app-exists", err, lager.Data{"group": group})
+		return err
+	}
+	if exists {
+		return GroupNameError{Group: group}
+	}
+	return nil
+}
+
+func (a *Authorizer) AuthorizeLeave(logger lager.Logger, user User, group, app string) error {
+	if a.IsAdmin(user) {
+		return nil
+	}
+
+	p := a.permissions(logger, user)
+	for _, name := range []string{app, group} {
+		ok, err := p.canManage(name)
+		if err != nil {
+			logger.Error("can-manage", err, lager.Data{"app": name})
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return GroupForbiddenError{Group: group, App: app}
+}
+
+func (a *Authorizer) VisibleGroups(logger lager.Logger, user User, groups []models.Group) ([]models.Group, error) {
+	if a.IsAdmin(user) {
+		return groups, nil
+	}
+
+	p := a.permissions(logger, user)
+	visible := []models.Group{}
+	for _, group := range groups {
+		members := []string{}
+		for _, app := range group.Members {
+			ok, err := p.canSee(app)
+			if err != nil {
+				logger.Error("can-see", err, lager.Data{"app": app})
+				return nil, err
+			}
+			if ok {
+				members = append(members, app)
+			}
+		}
+		if len(members) > 0 || len(group.Members) == 0 {
+			visible = append(visible, models.Group{Name: group.Name, Members: members})
+		}
+	}
+	return visible, nil
+}
+
+func (a *Authorizer) IsAdmin(user User) bool {
+	for _, scope := range user.Scopes {
+		if scope == a.AdminScope {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authorizer) permissions(logger lager.Logger, user User) *permissions {
+	return &permissions{
+		cloudController: a.CloudController,
+		groups:          a.Groups,
+		logger:          logger,
+		user:            user,
+		appSpaces:       map[string]string{},
+		groupMembers:    map[string][]string{},
+	}
+}
+
+type permissions struct {
+	cloudController cloudController
+	groups          groupLookup
+	logger          lager.Logger
+	user            User
+
+	// "" if the user cannot see the app
+	appSpaces       map[string]string
+	developerSpaces map[string]bool
+
+	// nil if the name is not a group
+	groupMembers map[string][]string
+}
+
+func (p *permissions) members(name string) ([]string, bool, error) {
+	if p.groups == nil {
+		return nil, false, nil
+	}
+	if members, ok := p.groupMembers[name]; ok {
+		return members, members != nil, nil
+	}
+
+	group, err := p.groups.GetGroup(p.logger, name)
+	if e, ok := err.(notFoundError); ok && e.NotFound() {
+		p.groupMembers[name] = nil
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	members := append([]string{}, group.Members...)
+	p.groupMembers[name] = members
+	return members, true, nil
+}
+
+func (p *permissions) appSpace(app string) (string, error) {
+	if space, ok := p.appSpaces[app]; ok {
+		return space, nil
+	}
+
+	space, err := p.cloudController.AppSpaceGUID(p.user.Token, app)
+	if e, ok := err.(notFoundError); ok && e.NotFound() {
+		space, err = "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	p.appSpaces[app] = space
+	return space, nil
+}
+
+func (p *permissions) canSee(app string) (bool, error) {
+	members, isGroup, err := p.members(app)
+	if err != nil {
+		return false, err
+	}
+	if isGroup {
+		for _, member := range members {
+			if ok, err := p.canSee(member); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	space, err := p.appSpace(app)
+	return space != "", err
+}
+
+func (p *permissions) canManage(app string) (bool, error) {
+	members, isGroup, err := p.members(app)
+	if err != nil {
+		return false, err
+	}
+	if isGroup {
+		for _, member := range members {
+			if ok, err := p.canManage(member); !ok || err != nil {
+				return false, err
+			}
+		}
+		return len(members) > 0, nil
+	}
+
+	space, err := p.appSpace(app)
+	if err != nil || space == "" {
+		return false, err
+	}
+
+	if p.developerSpaces == nil {
+		spaces, err := p.cloudController.DeveloperSpaceGUIDs(p.user.Token, p.user.ID)
+		if err != nil {
+			return false, err
+		}
+		p.developerSpaces = map[string]bool{}
+		for _, s := range spaces {
+			p.developerSpaces[s] = true
+		}
+	}
+	return p.developerSpaces[space], nil
+}
@@ -0,0 +1,250 @@
+package auth_test
+
+import (
+	"errors"
+	"policy-server/auth"
+	"policy-server/cc"
+	"policy-server/models"
+	"policy-server/store"
+
+	"github.com/pivotal-golang/lager"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeCloudController struct {
+	appSpaces       map[string]string
+	hiddenApps      []string
+	developerSpaces []string
+	err             error
+
+	appRequests   []string
+	spaceRequests int
+}
+
+func (c *fakeCloudController) AppSpaceGUID(token, appGUID string) (string, error) {
+	Expect(token).To(Equal("some-token"))
+	c.appRequests = append(c.appRequests, appGUID)
+	if c.err != nil {
+		return "", c.err
+	}
+	space, ok := c.appSpaces[appGUID]
+	if !ok {
+		return "", cc.NotFoundError{AppGUID: appGUID}
+	}
+	return space, nil
+}
+
+func (c *fakeCloudController) AppExists(token, appGUID string) (bool, error) {
+	Expect(token).To(Equal("some-token"))
+	c.appRequests = append(c.appRequests, appGUID)
+	if c.err != nil {
+		return false, c.err
+	}
+	for _, hidden := range c.hiddenApps {
+		if hidden == appGUID {
+			return true, nil
+		}
+	}
+	_, ok := c.appSpaces[appGUID]
+	return ok, nil
+}
+
+func (c *fakeCloudController) DeveloperSpaceGUIDs(token, userGUID string) ([]string, error) {
+	Expect(token).To(Equal("some-token"))
+	Expect(userGUID).To(Equal("some-user-guid"))
+	c.spaceRequests++
+	return c.developerSpaces, nil
+}
+
+type fakeGroups map[string][]string
+
+func (g fakeGroups) GetGroup(logger lager.Logger, name string) (models.Group, error) {
+	members, ok := g[name]
+	if !ok {
+		return models.Group{}, store.GroupNotFoundError{Name: name}
+	}
+	return models.Group{Name: name, Members: members}, nil
+}
+
+var _ = Describe("Authorizer", func() {
+	var (
+		cloudController *fakeCloudController
+		authorizer      *auth.Authorizer
+		user            auth.User
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		cloudController = &fakeCloudController{
+			appSpaces: map[string]string{
+				"app-a": "space-1",
+				"app-b": "space-1",
+				"app-c": "space-2",
+			},
+			developerSpaces: []string{"space-1"},
+		}
+		authorizer = &auth.Authorizer{
+			CloudController: cloudController,
+			AdminScope:      "network.admin",
+		}
+		user = auth.User{ID: "some-user-guid", Name: "some-user", Token: "some-token"}
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	Describe("Authorize", func() {
+		It("allows rules between apps in spaces the user develops in", func() {
+			err := authorizer.Authorize(logger, user, []models.Rule{
+				{Source: "app-a", Destination: "app-b"},
+				{Source: "app-b", Destination: "app-a"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("asking about each app and the spaces only once")
+			Expect(cloudController.appRequests).To(Equal([]string{"app-a", "app-b"}))
+			Expect(cloudController.spaceRequests).To(Equal(1))
+		})
+
+		It("forbids rules with apps in other spaces", func() {
+			rule := models.Rule{Source: "app-a", Destination: "app-c"}
+			err := authorizer.Authorize(logger, user, []models.Rule{rule})
+			Expect(err).To(Equal(auth.ForbiddenError{Rule: rule}))
+			Expect(err.(auth.ForbiddenError).Forbidden()).To(BeTrue())
+		})
+
+		It("forbids rules with apps the user cannot see", func() {
+			err := authorizer.Authorize(logger, user, []models.Rule{{Source: "app-x", Destination: "app-a"}})
+			Expect(err).To(BeAssignableToTypeOf(auth.ForbiddenError{}))
+			Expect(cloudController.spaceRequests).To(Equal(0))
+		})
+
+		It("lets admins manage any rule without asking", func() {
+			user.Scopes = []string{"openid", "network.admin"}
+			err := authorizer.Authorize(logger, user, []models.Rule{{Source: "app-x", Destination: "app-c"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cloudController.appRequests).To(BeEmpty())
+		})
+
+		It("fails if the cloud controller does", func() {
+			cloudController.err = errors.New("boom")
+			err := authorizer.Authorize(logger, user, []models.Rule{{Source: "app-a", Destination: "app-b"}})
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+
+	Describe("Visible", func() {
+		It("keeps the rules with an app the user can see", func() {
+			rules := []models.Rule{
+				{Source: "app-a", Destination: "app-b"},
+				{Source: "app-x", Destination: "app-c"},
+				{Source: "app-x", Destination: "app-y"},
+			}
+			visible, err := authorizer.Visible(logger, user, rules)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visible).To(Equal(rules[:2]))
+			Expect(cloudController.spaceRequests).To(Equal(0))
+		})
+
+		It("stops asking the cloud controller once it has found enough rules", func() {
+			rules := []models.Rule{
+				{Source: "app-x", Destination: "app-y"},
+				{Source: "app-a", Destination: "app-b"},
+				{Source: "app-c", Destination: "app-z"},
+			}
+			visible, err := authorizer.FirstVisible(logger, user, rules, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visible).To(Equal(rules[1:2]))
+			Expect(cloudController.appRequests).To(Equal([]string{"app-x", "app-y", "app-a"}))
+		})
+
+		It("shows admins every rule", func() {
+			user.Scopes = []string{"network.admin"}
+			rules := []models.Rule{{Source: "app-x", Destination: "app-y"}}
+			visible, err := authorizer.Visible(logger, user, rules)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visible).To(Equal(rules))
+		})
+
+		It("fails if the cloud controller does", func() {
+			cloudController.err = errors.New("boom")
+			_, err := authorizer.Visible(logger, user, []models.Rule{{Source: "app-a", Destination: "app-b"}})
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+
+	Describe("groups", func() {
+		BeforeEach(func() {
+			authorizer.Groups = fakeGroups{
+				"mine":   {"app-a", "app-b"},
+				"shared": {"app-a", "app-c"},
+				"empty":  {},
+			}
+		})
+
+		It("lets users manage rules naming groups whose members they all manage", func() {
+			Expect(authorizer.Authorize(logger, user, []models.Rule{{Source: "mine", Destination: "app-b"}})).To(Succeed())
+
+			err := authorizer.Authorize(logger, user, []models.Rule{{Source: "shared", Destination: "app-b"}})
+			Expect(err).To(Equal(auth.ForbiddenError{Rule: models.Rule{Source: "shared", Destination: "app-b"}}))
+
+			err = authorizer.Authorize(logger, user, []models.Rule{{Source: "empty", Destination: "app-b"}})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("shows rules naming groups with a member the user can see", func() {
+			rules := []models.Rule{
+				{Source: "app-x", Destination: "shared"},
+				{Source: "app-x", Destination: "empty"},
+			}
+			visible, err := authorizer.Visible(logger, user, rules)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visible).To(Equal(rules[:1]))
+		})
+
+		It("refuses to create a group named after an app, even one the user cannot see", func() {
+			cloudController.hiddenApps = []string{"someone-elses-app"}
+
+			Expect(authorizer.AuthorizeCreate(logger, user, "new-group")).To(Succeed())
+			err := authorizer.AuthorizeCreate(logger, user, "someone-elses-app")
+			Expect(err).To(Equal(auth.GroupNameError{Group: "someone-elses-app"}))
+			Expect(authorizer.AuthorizeCreate(logger, user, "app-a")).To(Equal(auth.GroupNameError{Group: "app-a"}))
+
+			user.Scopes = []string{"network.admin"}
+			Expect(authorizer.AuthorizeCreate(logger, user, "someone-elses-app")).To(HaveOccurred())
+		})
+
+		It("lets users add the apps they manage to groups whose members they manage", func() {
+			Expect(authorizer.AuthorizeMembers(logger, user, "empty", []string{"app-a"})).To(Succeed())
+			Expect(authorizer.AuthorizeMembers(logger, user, "mine", []string{"app-a"})).To(Succeed())
+
+			err := authorizer.AuthorizeMembers(logger, user, "mine", []string{"app-c"})
+			Expect(err).To(Equal(auth.GroupForbiddenError{Group: "mine"}))
+			err = authorizer.AuthorizeMembers(logger, user, "shared", []string{"app-b"})
+			Expect(err).To(Equal(auth.GroupForbiddenError{Group: "shared"}))
+		})
+
+		It("lets users remove the apps they manage, or any app from groups they manage", func() {
+			Expect(authorizer.AuthorizeLeave(logger, user, "shared", "app-a")).To(Succeed())
+			Expect(authorizer.AuthorizeLeave(logger, user, "mine", "app-x")).To(Succeed())
+
+			err := authorizer.AuthorizeLeave(logger, user, "shared", "app-c")
+			Expect(err).To(Equal(auth.GroupForbiddenError{Group: "shared", App: "app-c"}))
+			Expect(err.Error()).To(Equal("may not manage app-c in group shared"))
+		})
+
+		It("shows the members the user can see of the groups they can see", func() {
+			visible, err := authorizer.VisibleGroups(logger, user, []models.Group{
+				{Name: "shared", Members: []string{"app-a", "app-c"}},
+				{Name: "hidden", Members: []string{"app-x"}},
+				{Name: "empty", Members: []string{}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(visible).To(Equal([]models.Group{
+				{Name: "shared", Members: []string{"app-a", "app-c"}},
+				{Name: "empty", Members: []string{}},
+			}))
+		})
+	})
+})
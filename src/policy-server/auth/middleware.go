@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"policy-server/models"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type User struct {
+	ID       string
+	Name     string
+	ClientID string
+	Scopes   []string
+	Token    string
+}
+
+type contextKey struct{}
+
+func UserFromRequest(req *http.Request) (User, bool) {
+	user, ok := req.Context().Value(contextKey{}).(User)
+	return user, ok
+}
+
+type tokenValidator interface {
+	Validate(token string) (Claims, error)
+}
+
+type Authenticator struct {
+	Validator tokenValidator
+	Logger    lager.Logger
+}
+
+func (a *Authenticator) Wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		logger := a.Logger.Session("authenticate")
+
+		token, ok := bearerToken(req)
+		if !ok {
+			logger.Info("missing-token", lager.Data{"path": req.URL.Path})
+			unauthorized(resp, "", "missing bearer token")
+			return
+		}
+
+		claims, err := a.Validator.Validate(token)
+		if err != nil {
+			logger.Error("invalid-token", err, lager.Data{"path": req.URL.Path})
+			unauthorized(resp, "invalid_token", fmt.Sprintf("invalid token: %s", err))
+			return
+		}
+
+		user := User{
+			ID:       claims.UserID,
+			Name:     claims.UserName,
+			ClientID: claims.ClientID,
+			Scopes:   claims.Scopes,
+			Token:    token,
+		}
+		if user.ID == "" {
+			// tokens issued to clients rather than users
+			user.ID = claims.Subject
+		}
+
+		ctx := context.WithValue(req.Context(), contextKey{}, user)
+		handler.ServeHTTP(resp, req.WithContext(ctx))
+	})
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	fields := strings.Fields(req.Header.Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+		return "", false
+	}
+	return fields[1], true
+}
+
+func unauthorized(resp http.ResponseWriter, errorCode, message string) {
+	challenge := "Bearer"
+	if errorCode != "" {
+		challenge += ` error="` + errorCode + `"`
+	}
+	resp.Header().Set("WWW-Authenticate", challenge)
+
+	payload, _ := json.Marshal(models.Error{
+		Code:    models.ErrorUnauthorized,
+		Message: message,
+	})
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusUnauthorized)
+	resp.Write(payload)
+}
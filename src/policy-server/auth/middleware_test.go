@@ -0,0 +1,113 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"policy-server/auth"
+
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeValidator struct {
+	claims auth.Claims
+	err    error
+	tokens []string
+}
+
+func (v *fakeValidator) Validate(token string) (auth.Claims, error) {
+	v.tokens = append(v.tokens, token)
+	return v.claims, v.err
+}
+
+var _ = Describe("Authenticator", func() {
+	var (
+		validator *fakeValidator
+		handler   http.Handler
+		request   *http.Request
+		resp      *httptest.ResponseRecorder
+		user      auth.User
+		called    bool
+	)
+
+	BeforeEach(func() {
+		validator = &fakeValidator{
+			claims: auth.Claims{
+				Subject:  "some-user-guid",
+				UserID:   "some-user-guid",
+				UserName: "some-user",
+				ClientID: "cf",
+				Scopes:   []string{"network.admin"},
+			},
+		}
+		authenticator := &auth.Authenticator{
+			Validator: validator,
+			Logger:    lagertest.NewTestLogger("test"),
+		}
+
+		called = false
+		handler = authenticator.Wrap(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			called = true
+			var ok bool
+			user, ok = auth.UserFromRequest(req)
+			Expect(ok).To(BeTrue())
+		}))
+
+		var err error
+		request, err = http.NewRequest("GET", "/rules", nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Authorization", "Bearer some-token")
+		resp = httptest.NewRecorder()
+	})
+
+	It("passes the user on to the handler", func() {
+		handler.ServeHTTP(resp, request)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(validator.tokens).To(Equal([]string{"some-token"}))
+		Expect(called).To(BeTrue())
+		Expect(user).To(Equal(auth.User{
+			ID:       "some-user-guid",
+			Name:     "some-user",
+			ClientID: "cf",
+			Scopes:   []string{"network.admin"},
+			Token:    "some-token",
+		}))
+	})
+
+	It("uses the subject as the ID of tokens issued to clients", func() {
+		validator.claims = auth.Claims{Subject: "some-client", ClientID: "some-client"}
+		handler.ServeHTTP(resp, request)
+		Expect(called).To(BeTrue())
+		Expect(user.ID).To(Equal("some-client"))
+	})
+
+	It("rejects requests without a bearer token", func() {
+		request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+		handler.ServeHTTP(resp, request)
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+		Expect(validator.tokens).To(BeEmpty())
+		Expect(called).To(BeFalse())
+	})
+
+	It("rejects invalid tokens", func() {
+		validator.err = errors.New("token expired")
+		handler.ServeHTTP(resp, request)
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(resp.Header().Get("WWW-Authenticate")).To(Equal(`Bearer error="invalid_token"`))
+		Expect(resp.Body.String()).To(MatchJSON(`{"code": "unauthorized", "message": "invalid token: token expired"}`))
+		Expect(called).To(BeFalse())
+	})
+})
+
+var _ = Describe("UserFromRequest", func() {
+	It("reports when a request was not authenticated", func() {
+		request, err := http.NewRequest("GET", "/rules", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, ok := auth.UserFromRequest(request)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pivotal-golang/clock"
+)
+
+type Claims struct {
+	Subject   string   `json:"sub"`
+	UserID    string   `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	ClientID  string   `json:"client_id"`
+	Scopes    []string `json:"scope"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = audience(list)
+	return nil
+}
+
+func (a audience) contains(resource string) bool {
+	for _, r := range a {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+type Validator struct {
+	Keys     []*rsa.PublicKey
+	Audience string
+	Clock    clock.Clock
+}
+
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("malformed token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("decode header: %s", err)
+	}
+	if header.Algorithm != "RS256" {
+		return Claims{}, fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("decode signature: %s", err)
+	}
+	if !v.verify(parts[0]+"."+parts[1], signature) {
+		return Claims{}, errors.New("invalid signature")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("decode claims: %s", err)
+	}
+
+	now := v.Clock.Now().Unix()
+	if claims.ExpiresAt == 0 || now >= claims.ExpiresAt {
+		return Claims{}, errors.New("token expired")
+	}
+	if now < claims.NotBefore {
+		return Claims{}, errors.New("token not valid yet")
+	}
+	if !claims.Audience.contains(v.Audience) {
+		return Claims{}, fmt.Errorf("token is not meant for %q", v.Audience)
+	}
+	return claims, nil
+}
+
+func (v *Validator) verify(signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	for _, key := range v.Keys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, output interface{}) error {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, output)
+}
+
+func ReadPublicKeys(paths []string) ([]*rsa.PublicKey, error) {
+	keys := []*rsa.PublicKey{}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key: %s", err)
+		}
+		key, err := ParsePublicKey(contents)
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key %s: %s", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func ParsePublicKey(contents []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an RSA public key", parsed)
+	}
+	return key, nil
+}
@@ -0,0 +1,159 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"policy-server/auth"
+	"strings"
+	"time"
+
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		validator *auth.Validator
+		claims    map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		validator = &auth.Validator{
+			Keys:     []*rsa.PublicKey{&signingKey.PublicKey},
+			Audience: "network-policy",
+			Clock:    fakeClock,
+		}
+		claims = map[string]interface{}{
+			"sub":       "some-user-guid",
+			"user_id":   "some-user-guid",
+			"user_name": "some-user",
+			"client_id": "cf",
+			"scope":     []string{"network.admin"},
+			"aud":       []string{"cf", "network-policy"},
+			"exp":       fakeClock.Now().Add(time.Hour).Unix(),
+		}
+	})
+
+	It("returns the claims of a valid token", func() {
+		validated, err := validator.Validate(signToken(claims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(validated.UserID).To(Equal("some-user-guid"))
+		Expect(validated.UserName).To(Equal("some-user"))
+		Expect(validated.ClientID).To(Equal("cf"))
+		Expect(validated.Scopes).To(Equal([]string{"network.admin"}))
+	})
+
+	It("accepts an audience given as a single string", func() {
+		claims["aud"] = "network-policy"
+		_, err := validator.Validate(signToken(claims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("accepts tokens signed by any of the keys", func() {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		validator.Keys = []*rsa.PublicKey{&otherKey.PublicKey, &signingKey.PublicKey}
+
+		_, err = validator.Validate(signToken(claims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects tokens signed by another key", func() {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = validator.Validate(signTokenWith(otherKey, "RS256", claims))
+		Expect(err).To(MatchError("invalid signature"))
+	})
+
+	It("rejects tokens whose claims were changed after signing", func() {
+		token := strings.Split(signToken(claims), ".")
+		claims["user_name"] = "admin"
+		token[1] = encodeSegment(claims)
+
+		_, err := validator.Validate(strings.Join(token, "."))
+		Expect(err).To(MatchError("invalid signature"))
+	})
+
+	It("rejects other signing algorithms", func() {
+		_, err := validator.Validate(signTokenWith(signingKey, "none", claims))
+		Expect(err).To(MatchError(`unsupported signing algorithm "none"`))
+	})
+
+	It("rejects expired tokens", func() {
+		fakeClock.Increment(time.Hour)
+		_, err := validator.Validate(signToken(claims))
+		Expect(err).To(MatchError("token expired"))
+	})
+
+	It("rejects tokens without an expiry", func() {
+		delete(claims, "exp")
+		_, err := validator.Validate(signToken(claims))
+		Expect(err).To(MatchError("token expired"))
+	})
+
+	It("rejects tokens that are not valid yet", func() {
+		claims["nbf"] = fakeClock.Now().Add(time.Minute).Unix()
+		_, err := validator.Validate(signToken(claims))
+		Expect(err).To(MatchError("token not valid yet"))
+	})
+
+	It("rejects tokens meant for someone else", func() {
+		claims["aud"] = []string{"cf"}
+		_, err := validator.Validate(signToken(claims))
+		Expect(err).To(MatchError(`token is not meant for "network-policy"`))
+	})
+
+	It("rejects malformed tokens", func() {
+		_, err := validator.Validate("not-a-token")
+		Expect(err).To(MatchError("malformed token"))
+
+		_, err = validator.Validate("!!.!!.!!")
+		Expect(err).To(MatchError(ContainSubstring("decode header")))
+	})
+})
+
+var _ = Describe("ReadPublicKeys", func() {
+	var keyPath string
+
+	BeforeEach(func() {
+		der, err := x509.MarshalPKIXPublicKey(&signingKey.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		keyFile, err := ioutil.TempFile("", "signing-key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der})).To(Succeed())
+		Expect(keyFile.Close()).To(Succeed())
+		keyPath = keyFile.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(keyPath)
+	})
+
+	It("reads a PEM encoded key from each file", func() {
+		keys, err := auth.ReadPublicKeys([]string{keyPath})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(HaveLen(1))
+		Expect(keys[0].N).To(Equal(signingKey.PublicKey.N))
+	})
+
+	It("fails if a file is missing", func() {
+		_, err := auth.ReadPublicKeys([]string{keyPath, "/does/not/exist"})
+		Expect(err).To(MatchError(ContainSubstring("read signing key")))
+	})
+
+	It("fails if a file does not contain a key", func() {
+		Expect(ioutil.WriteFile(keyPath, []byte("not a key"), 0600)).To(Succeed())
+		_, err := auth.ReadPublicKeys([]string{keyPath})
+		Expect(err).To(MatchError(ContainSubstring("no PEM data found")))
+	})
+})
@@ -0,0 +1,133 @@
+package cc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/dghubble/sling"
+)
+
+type Client struct {
+	slingClient *sling.Sling
+}
+
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	return &Client{
+		slingClient: sling.New().Client(httpClient).Base(baseURL).Set("Accept", "application/json"),
+	}
+}
+
+type NotFoundError struct {
+	AppGUID string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("app %s not found", e.AppGUID)
+}
+
+func (e NotFoundError) NotFound() bool {
+	return true
+}
+
+type appResource struct {
+	Entity struct {
+		SpaceGUID string `json:"space_guid"`
+	} `json:"entity"`
+}
+
+type spacesPage struct {
+	NextURL   string `json:"next_url"`
+	Resources []struct {
+		Metadata struct {
+			GUID string `json:"guid"`
+		} `json:"metadata"`
+	} `json:"resources"`
+}
+
+// a guid is a single path segment, so it cannot reach other resources
+func guidPath(guid string) (string, bool) {
+	if guid == "" || guid == "." || guid == ".." {
+		return "", false
+	}
+	return url.PathEscape(guid), true
+}
+
+func (c *Client) AppSpaceGUID(token, appGUID string) (string, error) {
+	guid, ok := guidPath(appGUID)
+	if !ok {
+		return "", NotFoundError{AppGUID: appGUID}
+	}
+
+	var app appResource
+	resp, err := c.slingClient.New().
+		Set("Authorization", "bearer "+token).
+		Get("/v2/apps/"+guid).
+		Receive(&app, nil)
+	if err != nil {
+		return "", fmt.Errorf("get app: %s", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return app.Entity.SpaceGUID, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		return "", NotFoundError{AppGUID: appGUID}
+	default:
+		return "", fmt.Errorf("get app: unexpected status code: %s", resp.Status)
+	}
+}
+
+// an app the token may not see still exists
+func (c *Client) AppExists(token, appGUID string) (bool, error) {
+	guid, ok := guidPath(appGUID)
+	if !ok {
+		return false, nil
+	}
+
+	resp, err := c.slingClient.New().
+		Set("Authorization", "bearer "+token).
+		Get("/v2/apps/"+guid).
+		Receive(nil, nil)
+	if err != nil {
+		return false, fmt.Errorf("get app: %s", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusForbidden:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("get app: unexpected status code: %s", resp.Status)
+	}
+}
+
+func (c *Client) DeveloperSpaceGUIDs(token, userGUID string) ([]string, error) {
+	guid, ok := guidPath(userGUID)
+	if !ok {
+		return nil, fmt.Errorf("list spaces: invalid user guid %q", userGUID)
+	}
+
+	spaces := []string{}
+	next := "/v2/users/" + guid + "/spaces"
+	for next != "" {
+		var page spacesPage
+		resp, err := c.slingClient.New().
+			Set("Authorization", "bearer "+token).
+			Get(next).
+			Receive(&page, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list spaces: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("list spaces: unexpected status code: %s", resp.Status)
+		}
+
+		for _, space := range page.Resources {
+			spaces = append(spaces, space.Metadata.GUID)
+		}
+		next = page.NextURL
+	}
+	return spaces, nil
+}
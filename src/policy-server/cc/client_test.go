@@ -0,0 +1,75 @@
+package cc_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"policy-server/cc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server   *httptest.Server
+		client   *cc.Client
+		requests []string
+	)
+
+	BeforeEach(func() {
+		requests = []string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.EscapedPath())
+			switch r.URL.EscapedPath() {
+			case "/v2/apps/some-app-guid":
+				fmt.Fprint(w, `{"entity": {"space_guid": "some-space-guid"}}`)
+			case "/v2/apps/hidden-app-guid":
+				w.WriteHeader(http.StatusForbidden)
+			case "/v2/users/some-user-guid/spaces":
+				fmt.Fprint(w, `{"resources": [{"metadata": {"guid": "some-space-guid"}}]}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		client = cc.NewClient(server.URL, http.DefaultClient)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("looks up the space of an app", func() {
+		space, err := client.AppSpaceGUID("some-token", "some-app-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(space).To(Equal("some-space-guid"))
+
+		_, err = client.AppSpaceGUID("some-token", "hidden-app-guid")
+		Expect(err).To(Equal(cc.NotFoundError{AppGUID: "hidden-app-guid"}))
+	})
+
+	It("tells whether an app exists, even one the token may not see", func() {
+		Expect(client.AppExists("some-token", "some-app-guid")).To(BeTrue())
+		Expect(client.AppExists("some-token", "hidden-app-guid")).To(BeTrue())
+		Expect(client.AppExists("some-token", "missing-app-guid")).To(BeFalse())
+	})
+
+	It("lists the spaces of a developer", func() {
+		Expect(client.DeveloperSpaceGUIDs("some-token", "some-user-guid")).To(Equal([]string{"some-space-guid"}))
+	})
+
+	It("keeps app guids from reaching other resources", func() {
+		_, err := client.AppSpaceGUID("some-token", "../users/some-user-guid/spaces")
+		Expect(err).To(Equal(cc.NotFoundError{AppGUID: "../users/some-user-guid/spaces"}))
+		Expect(client.AppExists("some-token", "../users/some-user-guid/spaces")).To(BeFalse())
+
+		_, err = client.AppSpaceGUID("some-token", "..")
+		Expect(err).To(Equal(cc.NotFoundError{AppGUID: ".."}))
+		Expect(client.AppExists("some-token", "..")).To(BeFalse())
+
+		Expect(requests).To(Equal([]string{
+			"/v2/apps/..%2Fusers%2Fsome-user-guid%2Fspaces",
+			"/v2/apps/..%2Fusers%2Fsome-user-guid%2Fspaces",
+		}))
+	})
+})
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"policy-server/models"
+	"strings"
+)
+
+type Error struct {
+	Action     string
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]interface{}
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Action, e.Message)
+}
+
+func (e Error) Invalid() bool {
+	return e.Code == models.ErrorInvalidRequest
+}
+
+func (e Error) Unauthorized() bool {
+	return e.Code == models.ErrorUnauthorized
+}
+
+func (e Error) Forbidden() bool {
+	return e.Code == models.ErrorForbidden
+}
+
+func (e Error) NotFound() bool {
+	return e.Code == models.ErrorNotFound
+}
+
+func (e Error) Conflict() bool {
+	return e.Code == models.ErrorConflict
+}
+
+func (e Error) TagSpaceExhausted() bool {
+	return e.Code == models.ErrorTagsExhausted
+}
+
+func (e Error) ResyncRequired() bool {
+	return e.Code == models.ErrorResyncRequired
+}
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:     models.ErrorInvalidRequest,
+	http.StatusUnauthorized:   models.ErrorUnauthorized,
+	http.StatusForbidden:      models.ErrorForbidden,
+	http.StatusNotFound:       models.ErrorNotFound,
+	http.StatusConflict:       models.ErrorConflict,
+	http.StatusGone:           models.ErrorResyncRequired,
+	http.StatusNotImplemented: models.ErrorNotImplemented,
+}
+
+func responseError(action string, resp *http.Response, body models.Error) error {
+	e := Error{
+		Action:     action,
+		StatusCode: resp.StatusCode,
+		Code:       body.Code,
+		Message:    body.Message,
+		Details:    body.Details,
+	}
+	if e.Code == "" {
+		e.Code = statusCodes[resp.StatusCode]
+	}
+	if e.Code == "" {
+		e.Code = models.ErrorInternal
+	}
+	if e.Message == "" {
+		e.Message = fmt.Sprintf("unexpected status code: %s", resp.Status)
+	}
+	return e
+}
+
+type jsonDecoder struct{}
+
+func (jsonDecoder) Decode(resp *http.Response, v interface{}) error {
+	failed := resp.StatusCode < 200 || resp.StatusCode > 299
+	if failed && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"policy-server/models"
+)
+
+func groupPath(name string, rest ...string) string {
+	path := "/v1/groups/" + name
+	for _, part := range rest {
+		path += "/" + part
+	}
+	return (&url.URL{Path: path}).String()
+}
+
+func (c *OuterClient) CreateGroup(name string) (models.Group, error) {
+	var group models.Group
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.Group{}, fmt.Errorf("create group: %s", err)
+	}
+
+	resp, err := request.Post("/v1/groups").BodyJSON(models.Group{Name: name}).Receive(&group, &apiErr)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("create group: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return models.Group{}, responseError("create group", resp, apiErr)
+	}
+	return group, nil
+}
+
+func (c *OuterClient) GetGroup(name string) (models.Group, error) {
+	var group models.Group
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.Group{}, fmt.Errorf("get group: %s", err)
+	}
+
+	resp, err := request.Get(groupPath(name)).Receive(&group, &apiErr)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("get group: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Group{}, responseError("get group", resp, apiErr)
+	}
+	return group, nil
+}
+
+func (c *OuterClient) ListGroups() ([]models.Group, error) {
+	var list struct {
+		Groups []models.Group `json:"groups"`
+	}
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %s", err)
+	}
+
+	resp, err := request.Get("/v1/groups").Receive(&list, &apiErr)
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("list groups", resp, apiErr)
+	}
+	return list.Groups, nil
+}
+
+func (c *OuterClient) AddMembers(name string, members []string) (models.Group, error) {
+	var group models.Group
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.Group{}, fmt.Errorf("add members: %s", err)
+	}
+
+	resp, err := request.Post(groupPath(name, "members")).BodyJSON(models.GroupMembers{Members: members}).
+		Receive(&group, &apiErr)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("add members: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Group{}, responseError("add members", resp, apiErr)
+	}
+	return group, nil
+}
+
+func (c *OuterClient) RemoveMember(name, member string) (models.Group, error) {
+	var group models.Group
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.Group{}, fmt.Errorf("remove member: %s", err)
+	}
+
+	resp, err := request.Delete(groupPath(name, "members", member)).Receive(&group, &apiErr)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("remove member: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Group{}, responseError("remove member", resp, apiErr)
+	}
+	return group, nil
+}
@@ -4,41 +4,232 @@ import (
 	"fmt"
 	"net/http"
 	"policy-server/models"
+	"policy-server/mutualtls"
+	"strconv"
+	"time"
 
 	"github.com/dghubble/sling"
 )
 
 func NewInnerClient(baseURL string, httpClient *http.Client) *InnerClient {
-	slingClient := sling.New().Client(httpClient).Base(baseURL).Set("Accept", "application/json")
+	slingClient := sling.New().Client(httpClient).Base(baseURL).Set("Accept", "application/json").
+		ResponseDecoder(jsonDecoder{})
 	return &InnerClient{
-		slingClient: slingClient,
+		RetryInterval: DefaultRetryInterval,
+		slingClient:   slingClient,
 	}
 }
 
+func NewInnerTLSClient(baseURL, certPath, keyPath, caCertPath string) (*InnerClient, error) {
+	tlsConfig, err := mutualtls.NewClientTLSConfig(certPath, keyPath, caCertPath)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return NewInnerClient(baseURL, httpClient), nil
+}
+
+const DefaultRetryInterval = time.Second
+
 type InnerClient struct {
+	// RetryInterval is how long WatchWhitelists waits after an error
+	RetryInterval time.Duration
+
 	slingClient *sling.Sling
 }
 
 type filterQuery struct {
-	Groups []string `url:"groups,comma"`
+	Groups []string `url:"groups,comma,omitempty"`
+	Format string   `url:"format,omitempty"`
+}
+
+type watchQuery struct {
+	Groups          []string `url:"groups,comma,omitempty"`
+	WaitForRevision int64    `url:"wait_for_revision"`
+}
+
+type changesQuery struct {
+	Since  int64    `url:"since"`
+	Groups []string `url:"groups,comma,omitempty"`
+}
+
+type WhitelistUpdate struct {
+	Revision   int64
+	Whitelists []models.IngressWhitelist
 }
 
 func (c *InnerClient) GetWhitelists(groupIDs []string) ([]models.IngressWhitelist, error) {
+	whitelists, _, err := c.getWhitelists(groupIDs)
+	return whitelists, err
+}
+
+func (c *InnerClient) getWhitelists(groupIDs []string) ([]models.IngressWhitelist, int64, error) {
 	var whitelists []models.IngressWhitelist
+	var apiErr models.Error
+
+	resp, err := c.slingClient.New().
+		Get("/whitelists").
+		QueryStruct(filterQuery{
+			Groups: groupIDs,
+		}).
+		Receive(&whitelists, &apiErr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list rules: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, responseError("list rules", resp, apiErr)
+	}
+
+	revision, err := strconv.ParseInt(resp.Header.Get(models.RevisionHeader), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list rules: bad revision: %s", err)
+	}
+	return whitelists, revision, nil
+}
+
+func (c *InnerClient) GetWhitelistChanges(since int64, groupIDs []string) (models.WhitelistChanges, error) {
+	var changes models.WhitelistChanges
+	var apiErr models.Error
+
+	resp, err := c.slingClient.New().
+		Get("/whitelists/changes").
+		QueryStruct(changesQuery{
+			Since:  since,
+			Groups: groupIDs,
+		}).
+		Receive(&changes, &apiErr)
+	if err != nil {
+		return models.WhitelistChanges{}, fmt.Errorf("get whitelist changes: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.WhitelistChanges{}, responseError("get whitelist changes", resp, apiErr)
+	}
+
+	return changes, nil
+}
+
+func (c *InnerClient) GetCompactWhitelists(groupIDs []string) (models.CompactWhitelists, int64, error) {
+	var whitelists models.CompactWhitelists
+	var apiErr models.Error
 
 	resp, err := c.slingClient.New().
 		Get("/whitelists").
 		QueryStruct(filterQuery{
 			Groups: groupIDs,
+			Format: "compact",
 		}).
-		Receive(&whitelists, nil)
+		Receive(&whitelists, &apiErr)
 	if err != nil {
-		return nil, fmt.Errorf("list rules: %s", err)
+		return models.CompactWhitelists{}, 0, fmt.Errorf("get whitelists: %s", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list rules: unexpected status code: %s", resp.Status)
+		return models.CompactWhitelists{}, 0, responseError("get whitelists", resp, apiErr)
+	}
+
+	revision, err := strconv.ParseInt(resp.Header.Get(models.RevisionHeader), 10, 64)
+	if err != nil {
+		return models.CompactWhitelists{}, 0, fmt.Errorf("get whitelists: bad revision: %s", err)
+	}
+	return whitelists, revision, nil
+}
+
+func (c *InnerClient) GetTagStats() (models.TagStats, error) {
+	var stats models.TagStats
+	var apiErr models.Error
+
+	resp, err := c.slingClient.New().Get("/tags/stats").Receive(&stats, &apiErr)
+	if err != nil {
+		return models.TagStats{}, fmt.Errorf("get tag stats: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.TagStats{}, responseError("get tag stats", resp, apiErr)
+	}
+
+	return stats, nil
+}
+
+func (c *InnerClient) WatchWhitelists(groupIDs []string, stop <-chan struct{}) (<-chan WhitelistUpdate, <-chan error) {
+	updates := make(chan WhitelistUpdate)
+	errs := make(chan error)
+
+	go func() {
+		defer close(updates)
+		defer close(errs)
+
+		var current *WhitelistUpdate
+		var etag string
+		for {
+			update, newETag, modified, err := c.pollWhitelists(groupIDs, current, etag, stop)
+			if err != nil {
+				select {
+				case <-stop:
+					return
+				case errs <- err:
+				}
+				select {
+				case <-stop:
+					return
+				case <-time.After(c.RetryInterval):
+				}
+				continue
+			}
+
+			etag = newETag
+			if !modified {
+				current.Revision = update.Revision
+				continue
+			}
+
+			current = &update
+			select {
+			case <-stop:
+				return
+			case updates <- update:
+			}
+		}
+	}()
+
+	return updates, errs
+}
+
+func (c *InnerClient) pollWhitelists(groupIDs []string, current *WhitelistUpdate, etag string,
+	stop <-chan struct{}) (WhitelistUpdate, string, bool, error) {
+	request := c.slingClient.New().Get("/whitelists")
+	if current == nil {
+		request = request.QueryStruct(filterQuery{Groups: groupIDs})
+	} else {
+		request = request.
+			QueryStruct(watchQuery{Groups: groupIDs, WaitForRevision: current.Revision}).
+			Set("If-None-Match", etag)
+	}
+
+	req, err := request.Request()
+	if err != nil {
+		return WhitelistUpdate{}, "", false, fmt.Errorf("watch whitelists: %s", err)
+	}
+	req.Cancel = stop
+
+	var update WhitelistUpdate
+	var apiErr models.Error
+	resp, err := request.Do(req, &update.Whitelists, &apiErr)
+	if err != nil {
+		return WhitelistUpdate{}, "", false, fmt.Errorf("watch whitelists: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return WhitelistUpdate{}, "", false, responseError("watch whitelists", resp, apiErr)
+	}
+
+	update.Revision, err = strconv.ParseInt(resp.Header.Get(models.RevisionHeader), 10, 64)
+	if err != nil {
+		return WhitelistUpdate{}, "", false, fmt.Errorf("watch whitelists: bad revision: %s", err)
 	}
 
-	return whitelists, nil
+	return update, resp.Header.Get("ETag"), resp.StatusCode == http.StatusOK, nil
 }
@@ -3,59 +3,370 @@ package client
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"policy-server/audit"
 	"policy-server/models"
+	"strings"
+	"time"
 
 	"github.com/dghubble/sling"
 )
 
-func NewOuterClient(baseURL string, httpClient *http.Client) *OuterClient {
-	slingClient := sling.New().Client(httpClient).Base(baseURL).Set("Accept", "application/json")
+type TokenSource interface {
+	Token() (string, error)
+}
+
+type TokenSourceFunc func() (string, error)
+
+func (f TokenSourceFunc) Token() (string, error) {
+	return f()
+}
+
+func NewOuterClient(baseURL string, httpClient *http.Client, tokenSource TokenSource) *OuterClient {
+	slingClient := sling.New().Client(httpClient).Base(baseURL).Set("Accept", "application/json").
+		ResponseDecoder(jsonDecoder{})
 	return &OuterClient{
 		slingClient: slingClient,
+		tokenSource: tokenSource,
 	}
 }
 
 type OuterClient struct {
 	slingClient *sling.Sling
+	tokenSource TokenSource
+}
+
+func (c *OuterClient) newRequest() (*sling.Sling, error) {
+	request := c.slingClient.New()
+	if c.tokenSource == nil {
+		return request, nil
+	}
+
+	token, err := c.tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("get token: %s", err)
+	}
+	return request.Set("Authorization", "Bearer "+token), nil
+}
+
+type ListOptions struct {
+	Filter   models.RuleFilter
+	PageSize int
+}
+
+type listQuery struct {
+	Source      string `url:"source,omitempty"`
+	Destination string `url:"destination,omitempty"`
+	Group       string `url:"group,omitempty"`
+	Owner       string `url:"owner,omitempty"`
+	Limit       int    `url:"limit,omitempty"`
+	Cursor      string `url:"cursor,omitempty"`
+}
+
+func (c *OuterClient) ListRules(options ListOptions) ([]models.Rule, error) {
+	rules := []models.Rule{}
+	pages := c.Pages(options)
+	for pages.Next() {
+		rules = append(rules, pages.Rules()...)
+	}
+	if err := pages.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (c *OuterClient) Pages(options ListOptions) *RulePages {
+	return &RulePages{
+		client: c,
+		query: listQuery{
+			Source:      options.Filter.Source,
+			Destination: options.Filter.Destination,
+			Group:       options.Filter.Group,
+			Owner:       options.Filter.Owner,
+			Limit:       options.PageSize,
+		},
+	}
+}
+
+type RulePages struct {
+	client  *OuterClient
+	query   listQuery
+	started bool
+	done    bool
+	rules   []models.Rule
+	err     error
+}
+
+func (p *RulePages) Next() bool {
+	if p.done || (p.started && p.query.Cursor == "") {
+		p.done = true
+		return false
+	}
+	p.started = true
+
+	rules, cursor, err := p.client.listPage(p.query)
+	if err != nil {
+		p.rules, p.err, p.done = nil, err, true
+		return false
+	}
+	p.rules, p.query.Cursor = rules, cursor
+	return true
+}
+
+func (p *RulePages) Rules() []models.Rule {
+	return p.rules
 }
 
-func (c *OuterClient) ListRules() ([]models.Rule, error) {
+func (p *RulePages) Err() error {
+	return p.err
+}
+
+func (c *OuterClient) listPage(query listQuery) ([]models.Rule, string, error) {
 	var rules []models.Rule
+	var apiErr models.Error
+
+	request, err := c.newRequest()
+	if err != nil {
+		return nil, "", fmt.Errorf("list rules: %s", err)
+	}
 
-	resp, err := c.slingClient.New().Get("/rules").Receive(&rules, nil)
+	resp, err := request.Get("/v1/policies").QueryStruct(query).Receive(&rules, &apiErr)
 	if err != nil {
-		return nil, fmt.Errorf("list rules: %s", err)
+		return nil, "", fmt.Errorf("list rules: %s", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list rules: unexpected status code: %s", resp.Status)
+		return nil, "", responseError("list rules", resp, apiErr)
 	}
 
-	return rules, nil
+	return rules, nextCursor(resp.Header), nil
+}
+
+func nextCursor(header http.Header) string {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+				continue
+			}
+			target, err := url.Parse(strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+			if err != nil {
+				continue
+			}
+			return target.Query().Get("cursor")
+		}
+	}
+	return ""
 }
 
-func (c *OuterClient) AddRule(rule models.Rule) error {
-	resp, err := c.slingClient.New().Post("/rules/add").BodyJSON(rule).Receive(nil, nil)
+func (c *OuterClient) AddRule(rule models.Rule) (models.Rule, bool, error) {
+	var stored models.Rule
+	var apiErr models.Error
+	request, err := c.newRequest()
 	if err != nil {
-		return fmt.Errorf("add rule: %s", err)
+		return models.Rule{}, false, fmt.Errorf("add rule: %s", err)
 	}
 
-	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("add rule: unexpected status code: %s", resp.Status)
+	resp, err := request.Post("/v1/policies").BodyJSON(rule).Receive(&stored, &apiErr)
+	if err != nil {
+		return models.Rule{}, false, fmt.Errorf("add rule: %s", err)
 	}
 
-	return nil
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return models.Rule{}, false, responseError("add rule", resp, apiErr)
+	}
+
+	return stored, resp.StatusCode == http.StatusCreated, nil
+}
+
+func (c *OuterClient) GetRule(id string) (models.Rule, error) {
+	var rule models.Rule
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.Rule{}, fmt.Errorf("get rule: %s", err)
+	}
+
+	resp, err := request.Get(rulePath(id)).Receive(&rule, &apiErr)
+	if err != nil {
+		return models.Rule{}, fmt.Errorf("get rule: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Rule{}, responseError("get rule", resp, apiErr)
+	}
+	return rule, nil
+}
+
+func rulePath(id string) string {
+	return (&url.URL{Path: "/v1/policies/" + id}).String()
 }
 
 func (c *OuterClient) DeleteRule(rule models.Rule) error {
-	resp, err := c.slingClient.New().Post("/rules/delete").BodyJSON(rule).Receive(nil, nil)
+	if rule.ID == "" {
+		id, err := c.findRule(rule)
+		if err != nil {
+			return err
+		}
+		rule.ID = id
+	}
+
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return fmt.Errorf("delete rule: %s", err)
+	}
+
+	resp, err := request.Delete(rulePath(rule.ID)).Receive(nil, &apiErr)
 	if err != nil {
 		return fmt.Errorf("delete rule: %s", err)
 	}
 
 	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("delete rule: unexpected status code: %s", resp.Status)
+		return responseError("delete rule", resp, apiErr)
 	}
 
 	return nil
 }
+
+func (c *OuterClient) findRule(rule models.Rule) (string, error) {
+	rules, err := c.ListRules(ListOptions{
+		Filter: models.RuleFilter{Source: rule.Source, Destination: rule.Destination, Owner: rule.Owner},
+	})
+	if e, ok := err.(Error); ok {
+		e.Action = "delete rule"
+		return "", e
+	}
+	if err != nil {
+		return "", fmt.Errorf("delete rule: %s", err)
+	}
+
+	for _, r := range rules {
+		if r.Equals(rule) {
+			return r.ID, nil
+		}
+	}
+	return "", Error{
+		Action:     "delete rule",
+		StatusCode: http.StatusNotFound,
+		Code:       models.ErrorNotFound,
+		Message:    "the rule to delete does not exist",
+	}
+}
+
+func (c *OuterClient) ApplyBatch(batch models.Batch) (models.BatchResult, error) {
+	var result models.BatchResult
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("apply batch: %s", err)
+	}
+
+	resp, err := request.Post("/v1/policies/batch").BodyJSON(batch).Receive(&result, &apiErr)
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("apply batch: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.BatchResult{}, responseError("apply batch", resp, apiErr)
+	}
+	return result, nil
+}
+
+func (c *OuterClient) SyncRules(owner string, rules []models.Rule, dryRun bool) (models.BatchResult, error) {
+	var result models.BatchResult
+	var apiErr models.Error
+	path := &url.URL{Path: "/v1/owners/" + owner + "/policies"}
+	if dryRun {
+		path.RawQuery = "dry_run=true"
+	}
+	request, err := c.newRequest()
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("sync rules: %s", err)
+	}
+
+	resp, err := request.Put(path.String()).BodyJSON(rules).Receive(&result, &apiErr)
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("sync rules: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.BatchResult{}, responseError("sync rules", resp, apiErr)
+	}
+	return result, nil
+}
+
+type auditQuery struct {
+	From  string `url:"from,omitempty"`
+	To    string `url:"to,omitempty"`
+	Actor string `url:"actor,omitempty"`
+}
+
+func (c *OuterClient) ListAudit(filter audit.Filter) ([]audit.Record, error) {
+	var records []audit.Record
+	var apiErr models.Error
+	query := auditQuery{Actor: filter.Actor}
+	if !filter.From.IsZero() {
+		query.From = filter.From.Format(time.RFC3339)
+	}
+	if !filter.To.IsZero() {
+		query.To = filter.To.Format(time.RFC3339)
+	}
+
+	request, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("list audit: %s", err)
+	}
+
+	resp, err := request.Get("/v1/audit").QueryStruct(query).Receive(&records, &apiErr)
+	if err != nil {
+		return nil, fmt.Errorf("list audit: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("list audit", resp, apiErr)
+	}
+	return records, nil
+}
+
+func (c *OuterClient) History() ([]models.Change, error) {
+	var history []models.Change
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return nil, fmt.Errorf("list history: %s", err)
+	}
+
+	resp, err := request.Get("/v1/policies/history").Receive(&history, &apiErr)
+	if err != nil {
+		return nil, fmt.Errorf("list history: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("list history", resp, apiErr)
+	}
+	return history, nil
+}
+
+type rollbackQuery struct {
+	Revision int64 `url:"revision"`
+}
+
+func (c *OuterClient) Rollback(revision int64) (models.BatchResult, error) {
+	var result models.BatchResult
+	var apiErr models.Error
+	request, err := c.newRequest()
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("rollback: %s", err)
+	}
+
+	resp, err := request.Post("/v1/policies/rollback").QueryStruct(rollbackQuery{Revision: revision}).Receive(&result, &apiErr)
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("rollback: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return models.BatchResult{}, responseError("rollback", resp, apiErr)
+	}
+	return result, nil
+}
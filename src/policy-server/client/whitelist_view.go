@@ -0,0 +1,86 @@
+package client
+
+import (
+	"policy-server/models"
+	"sort"
+)
+
+// not safe for concurrent use
+type WhitelistView struct {
+	client     *InnerClient
+	groups     []string
+	loaded     bool
+	revision   int64
+	whitelists map[string]models.IngressWhitelist
+}
+
+func (c *InnerClient) NewWhitelistView(groupIDs []string) *WhitelistView {
+	return &WhitelistView{client: c, groups: groupIDs}
+}
+
+type resyncError interface {
+	ResyncRequired() bool
+}
+
+func (v *WhitelistView) Update() error {
+	if !v.loaded {
+		return v.load()
+	}
+
+	changes, err := v.client.GetWhitelistChanges(v.revision, v.groups)
+	if e, ok := err.(resyncError); ok && e.ResyncRequired() {
+		return v.load()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes.Changes {
+		whitelist := v.whitelists[change.Destination.ID].Apply(change)
+		if len(v.groups) == 0 && len(whitelist.AllowedSources) == 0 {
+			delete(v.whitelists, change.Destination.ID)
+			continue
+		}
+		v.whitelists[change.Destination.ID] = whitelist
+	}
+	v.revision = changes.Revision
+	return nil
+}
+
+func (v *WhitelistView) load() error {
+	all, revision, err := v.client.getWhitelists(v.groups)
+	if err != nil {
+		return err
+	}
+
+	v.whitelists = map[string]models.IngressWhitelist{}
+	for _, whitelist := range all {
+		v.whitelists[whitelist.Destination.ID] = whitelist
+	}
+	v.revision = revision
+	v.loaded = true
+	return nil
+}
+
+func (v *WhitelistView) Revision() int64 {
+	return v.revision
+}
+
+func (v *WhitelistView) Whitelists() []models.IngressWhitelist {
+	groups := v.groups
+	if len(groups) == 0 {
+		groups = []string{}
+		for group := range v.whitelists {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+	}
+
+	whitelists := []models.IngressWhitelist{}
+	for _, group := range groups {
+		if whitelist, ok := v.whitelists[group]; ok {
+			whitelists = append(whitelists, whitelist)
+		}
+	}
+	return whitelists
+}
@@ -7,8 +7,83 @@ import (
 	"os"
 )
 
+const (
+	DefaultTagBits              = 32
+	DefaultTagQuarantineSeconds = 600
+	DefaultLongPollSeconds      = 30
+
+	DefaultAuthAudience = "network-policy"
+
+	// DefaultAdminScope lets a user manage every rule.
+	DefaultAdminScope = "network.admin"
+)
+
+const (
+	AuditTypeFile   = "file"
+	AuditTypeSyslog = "syslog"
+
+	DefaultAuditMaxSizeMB  = 100
+	DefaultAuditMaxBackups = 5
+)
+
+const (
+	StoreTypeMemory = "memory"
+	StoreTypeSQL    = "sql"
+	StoreTypeFile   = "file"
+)
+
 type ServerConfig struct {
-	ListenAddress string `json:"listen_address"`
+	ListenAddress        string      `json:"listen_address"`
+	TLS                  TLSConfig   `json:"tls"`
+	InnerListenAddress   string      `json:"inner_listen_address"`
+	InnerTLS             TLSConfig   `json:"inner_tls"`
+	DebugListenAddress   string      `json:"debug_listen_address"`
+	EnablePprof          bool        `json:"enable_pprof"`
+	DrainSeconds         int         `json:"drain_seconds"`
+	Store                StoreConfig `json:"store"`
+	TagBits              int         `json:"tag_bits"`
+	TagQuarantineSeconds *int        `json:"tag_quarantine_seconds"`
+	LongPollSeconds      int         `json:"long_poll_seconds"`
+	Auth                 AuthConfig  `json:"auth"`
+	Audit                AuditConfig `json:"audit"`
+}
+
+type AuditConfig struct {
+	Disabled      bool   `json:"disabled"`
+	Type          string `json:"type"`
+	Path          string `json:"path"`
+	MaxSizeMB     int    `json:"max_size_mb"`
+	MaxBackups    int    `json:"max_backups"`
+	SyslogNetwork string `json:"syslog_network"`
+	SyslogAddress string `json:"syslog_address"`
+}
+
+type TLSConfig struct {
+	Disabled     bool     `json:"disabled"`
+	CertPath     string   `json:"cert_path"`
+	KeyPath      string   `json:"key_path"`
+	CACertPath   string   `json:"ca_cert_path"`
+	MinVersion   string   `json:"min_version"`
+	CipherSuites []string `json:"cipher_suites"`
+}
+
+type AuthConfig struct {
+	Disabled           bool     `json:"disabled"`
+	SigningKeyPaths    []string `json:"signing_key_paths"`
+	Audience           string   `json:"audience"`
+	CloudControllerURL string   `json:"cloud_controller_url"`
+	AdminScope         string   `json:"admin_scope"`
+}
+
+type StoreConfig struct {
+	Type           string `json:"type"`
+	DriverName     string `json:"driver_name"`
+	DataSourceName string `json:"data_source_name"`
+
+	Directory        string `json:"directory"`
+	SnapshotInterval int    `json:"snapshot_interval"`
+
+	HistorySize int `json:"history_size"`
 }
 
 func Unmarshal(input io.Reader) (*ServerConfig, error) {
@@ -20,6 +95,45 @@ func Unmarshal(input io.Reader) (*ServerConfig, error) {
 		return nil, fmt.Errorf("json decode: %s", err)
 	}
 
+	if c.Store.Type == "" {
+		c.Store.Type = StoreTypeMemory
+	}
+
+	if c.TagBits == 0 {
+		c.TagBits = DefaultTagBits
+	}
+
+	// a pointer so that 0 turns quarantine off
+	if c.TagQuarantineSeconds == nil {
+		quarantine := DefaultTagQuarantineSeconds
+		c.TagQuarantineSeconds = &quarantine
+	}
+
+	if c.LongPollSeconds == 0 {
+		c.LongPollSeconds = DefaultLongPollSeconds
+	}
+
+	if c.Auth.Audience == "" {
+		c.Auth.Audience = DefaultAuthAudience
+	}
+
+	if c.Auth.AdminScope == "" {
+		c.Auth.AdminScope = DefaultAdminScope
+	}
+
+	// with neither a type nor a path, auditing stays off
+	if c.Audit.Type == "" && c.Audit.Path != "" {
+		c.Audit.Type = AuditTypeFile
+	}
+
+	if c.Audit.MaxSizeMB == 0 {
+		c.Audit.MaxSizeMB = DefaultAuditMaxSizeMB
+	}
+
+	if c.Audit.MaxBackups == 0 {
+		c.Audit.MaxBackups = DefaultAuditMaxBackups
+	}
+
 	return c, nil
 }
 
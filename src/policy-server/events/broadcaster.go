@@ -0,0 +1,128 @@
+package events
+
+import (
+	"fmt"
+	"policy-server/models"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHistorySize = 1000
+	DefaultBufferSize  = 64
+)
+
+// Publish never blocks: a subscriber that falls a whole buffer behind is dropped
+type Broadcaster struct {
+	epoch       string
+	historySize int
+	bufferSize  int
+
+	lock        sync.Mutex
+	sequence    uint64
+	history     []models.Event
+	subscribers map[*Subscription]struct{}
+	dropped     int
+}
+
+type Subscription struct {
+	Reset bool
+
+	// Replay holds the events published since the requested ID.
+	Replay []models.Event
+
+	// closed if the subscriber falls behind
+	Events <-chan models.Event
+
+	events      chan models.Event
+	broadcaster *Broadcaster
+}
+
+func NewBroadcaster(historySize, bufferSize int) *Broadcaster {
+	if historySize < 1 {
+		historySize = DefaultHistorySize
+	}
+	if bufferSize < 1 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Broadcaster{
+		epoch:       fmt.Sprintf("%x", time.Now().UnixNano()),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broadcaster) Publish(events ...models.Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, event := range events {
+		b.sequence++
+		event.ID = fmt.Sprintf("%s-%d", b.epoch, b.sequence)
+
+		b.history = append(b.history, event)
+		if len(b.history) > b.historySize {
+			b.history = b.history[len(b.history)-b.historySize:]
+		}
+
+		for sub := range b.subscribers {
+			select {
+			case sub.events <- event:
+			default:
+				b.drop(sub)
+				b.dropped++
+			}
+		}
+	}
+}
+
+func (b *Broadcaster) Subscribe(lastEventID string) *Subscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	events := make(chan models.Event, b.bufferSize)
+	sub := &Subscription{
+		Events:      events,
+		events:      events,
+		broadcaster: b,
+	}
+
+	if lastEventID != "" {
+		sub.Reset = true
+		for i, event := range b.history {
+			if event.ID == lastEventID {
+				sub.Reset = false
+				sub.Replay = make([]models.Event, len(b.history)-i-1)
+				copy(sub.Replay, b.history[i+1:])
+				break
+			}
+		}
+	}
+
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *Broadcaster) Dropped() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.dropped
+}
+
+// caller must hold the lock
+func (b *Broadcaster) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
+
+func (s *Subscription) Close() {
+	s.broadcaster.lock.Lock()
+	defer s.broadcaster.lock.Unlock()
+
+	s.broadcaster.drop(s)
+}
@@ -0,0 +1,115 @@
+package events_test
+
+import (
+	"policy-server/events"
+	"policy-server/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Broadcaster", func() {
+	var broadcaster *events.Broadcaster
+
+	event := func(revision int64) models.Event {
+		return models.Event{Type: models.EventRuleAdded, Revision: revision}
+	}
+
+	revisions := func(events []models.Event) []int64 {
+		revisions := []int64{}
+		for _, e := range events {
+			revisions = append(revisions, e.Revision)
+		}
+		return revisions
+	}
+
+	BeforeEach(func() {
+		broadcaster = events.NewBroadcaster(3, 2)
+	})
+
+	It("delivers every event to every subscriber", func() {
+		sub1 := broadcaster.Subscribe("")
+		sub2 := broadcaster.Subscribe("")
+		Expect(sub1.Reset).To(BeFalse())
+		Expect(sub1.Replay).To(BeEmpty())
+
+		broadcaster.Publish(event(1), event(2))
+
+		for _, sub := range []*events.Subscription{sub1, sub2} {
+			var e models.Event
+			Expect(sub.Events).To(Receive(&e))
+			Expect(e.Revision).To(Equal(int64(1)))
+			Expect(sub.Events).To(Receive(&e))
+			Expect(e.Revision).To(Equal(int64(2)))
+		}
+	})
+
+	It("gives each event a distinct ID", func() {
+		sub := broadcaster.Subscribe("")
+		broadcaster.Publish(event(1), event(1))
+
+		var e1, e2 models.Event
+		Expect(sub.Events).To(Receive(&e1))
+		Expect(sub.Events).To(Receive(&e2))
+		Expect(e1.ID).NotTo(BeEmpty())
+		Expect(e1.ID).NotTo(Equal(e2.ID))
+	})
+
+	It("drops subscribers that fall a whole buffer behind without blocking", func() {
+		slow := broadcaster.Subscribe("")
+		fast := broadcaster.Subscribe("")
+
+		for i := int64(1); i <= 3; i++ {
+			broadcaster.Publish(event(i))
+			Expect(fast.Events).To(Receive())
+		}
+
+		Expect(slow.Events).To(Receive())
+		Expect(slow.Events).To(Receive())
+		Expect(slow.Events).To(BeClosed())
+		Expect(broadcaster.Dropped()).To(Equal(1))
+	})
+
+	It("stops delivering once a subscription is closed", func() {
+		sub := broadcaster.Subscribe("")
+		sub.Close()
+		Expect(sub.Events).To(BeClosed())
+
+		broadcaster.Publish(event(1))
+		sub.Close()
+	})
+
+	Describe("resuming", func() {
+		var firstID string
+
+		BeforeEach(func() {
+			sub := broadcaster.Subscribe("")
+			broadcaster.Publish(event(1))
+			var e models.Event
+			Expect(sub.Events).To(Receive(&e))
+			firstID = e.ID
+			sub.Close()
+		})
+
+		It("replays the events published since the given ID", func() {
+			broadcaster.Publish(event(2), event(3))
+
+			sub := broadcaster.Subscribe(firstID)
+			Expect(sub.Reset).To(BeFalse())
+			Expect(revisions(sub.Replay)).To(Equal([]int64{2, 3}))
+		})
+
+		It("asks for a reset once the ID has left the history", func() {
+			broadcaster.Publish(event(2), event(3), event(4))
+
+			sub := broadcaster.Subscribe(firstID)
+			Expect(sub.Reset).To(BeTrue())
+			Expect(sub.Replay).To(BeEmpty())
+		})
+
+		It("asks for a reset for IDs it never handed out", func() {
+			sub := events.NewBroadcaster(3, 2).Subscribe(firstID)
+			Expect(sub.Reset).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,115 @@
+package fakes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type CloudController struct {
+	// AppSpaces maps app GUIDs to the GUID of their space
+	AppSpaces map[string]string
+	// DeveloperSpaces and AuditorSpaces map user GUIDs to space GUIDs
+	DeveloperSpaces map[string][]string
+	AuditorSpaces   map[string][]string
+
+	lock sync.Mutex
+}
+
+func NewCloudController() *CloudController {
+	return &CloudController{
+		AppSpaces:       map[string]string{},
+		DeveloperSpaces: map[string][]string{},
+		AuditorSpaces:   map[string][]string{},
+	}
+}
+
+func (c *CloudController) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	userID, ok := tokenUserID(req.Header.Get("Authorization"))
+	if !ok {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 3 && path[0] == "v2" && path[1] == "apps":
+		space, ok := c.AppSpaces[path[2]]
+		if !ok {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !(contains(c.DeveloperSpaces[userID], space) || contains(c.AuditorSpaces[userID], space)) {
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		writeJSON(resp, map[string]interface{}{
+			"metadata": map[string]string{"guid": path[2]},
+			"entity":   map[string]string{"space_guid": space},
+		})
+	case len(path) == 4 && path[0] == "v2" && path[1] == "users" && path[3] == "spaces":
+		if path[2] != userID {
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		resources := []interface{}{}
+		for _, space := range c.DeveloperSpaces[userID] {
+			resources = append(resources, map[string]interface{}{
+				"metadata": map[string]string{"guid": space},
+			})
+		}
+		writeJSON(resp, map[string]interface{}{
+			"total_results": len(resources),
+			"next_url":      nil,
+			"resources":     resources,
+		})
+	default:
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func tokenUserID(header string) (string, bool) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+		return "", false
+	}
+	parts := strings.Split(fields[1], ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	var claims struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == "" {
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(resp http.ResponseWriter, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("content-type", "application/json")
+	resp.Write(payload)
+}
@@ -3,9 +3,25 @@ package fakes
 import "policy-server/models"
 
 type Tagger struct {
-	GetTagStub func(groupID string) (*models.PacketTag, error)
+	GetTagStub     func(groupID string) (*models.PacketTag, error)
+	ReleaseTagStub func(groupID string) error
+	StatsStub      func() (models.TagStats, error)
 }
 
 func (t *Tagger) GetTag(groupID string) (*models.PacketTag, error) {
 	return t.GetTagStub(groupID)
 }
+
+func (t *Tagger) ReleaseTag(groupID string) error {
+	if t.ReleaseTagStub == nil {
+		return nil
+	}
+	return t.ReleaseTagStub(groupID)
+}
+
+func (t *Tagger) Stats() (models.TagStats, error) {
+	if t.StatsStub == nil {
+		return models.TagStats{}, nil
+	}
+	return t.StatsStub()
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"lib/marshal"
+	"net/http"
+	"policy-server/audit"
+	"policy-server/auth"
+	"policy-server/models"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type auditLog interface {
+	Query(filter audit.Filter) ([]audit.Record, error)
+}
+
+type adminChecker interface {
+	IsAdmin(user auth.User) bool
+}
+
+func admin(logger lager.Logger, checker adminChecker, resp http.ResponseWriter, req *http.Request, message string) bool {
+	if checker == nil {
+		return true
+	}
+
+	user, ok := auth.UserFromRequest(req)
+	if !ok || !checker.IsAdmin(user) {
+		logger.Info("not-admin", lager.Data{"user": user.Name})
+		writeError(resp, http.StatusForbidden, models.Error{
+			Code:    models.ErrorForbidden,
+			Message: message,
+		})
+		return false
+	}
+	return true
+}
+
+type AuditList struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Log        auditLog
+	Authorizer adminChecker
+}
+
+func (h *AuditList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("list-audit")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	if !admin(logger, h.Authorizer, resp, req, "only admins may read the audit log") {
+		return
+	}
+
+	query := req.URL.Query()
+	filter := audit.Filter{Actor: query.Get("actor")}
+	for param, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			logger.Error("parse-"+param, err)
+			writeInvalid(resp, fmt.Sprintf("invalid %s: %q is not an RFC 3339 time", param, value),
+				map[string]interface{}{"param": param})
+			return
+		}
+		*t = parsed
+	}
+
+	records, err := h.Log.Query(filter)
+	if err == audit.ErrQueryUnsupported {
+		writeError(resp, http.StatusNotImplemented, models.Error{
+			Code:    models.ErrorNotImplemented,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logger.Error("audit-query", err)
+		writeInternalError(resp)
+		return
+	}
+
+	payload, err := h.Marshaler.Marshal(records)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
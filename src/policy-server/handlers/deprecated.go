@@ -0,0 +1,14 @@
+package handlers
+
+import "net/http"
+
+type Deprecated struct {
+	Handler   http.Handler
+	Successor string
+}
+
+func (h *Deprecated) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Deprecation", "true")
+	resp.Header().Set("Link", "<"+h.Successor+`>; rel="successor-version"`)
+	h.Handler.ServeHTTP(resp, req)
+}
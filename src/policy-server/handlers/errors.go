@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"policy-server/models"
+)
+
+func writeError(resp http.ResponseWriter, status int, apiErr models.Error) {
+	payload, err := json.Marshal(apiErr)
+	if err != nil {
+		resp.WriteHeader(status) // not tested
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(payload)
+}
+
+func writeInvalid(resp http.ResponseWriter, message string, details map[string]interface{}) {
+	writeError(resp, http.StatusBadRequest, models.Error{
+		Code:    models.ErrorInvalidRequest,
+		Message: message,
+		Details: details,
+	})
+}
+
+func writeMissingUser(resp http.ResponseWriter) {
+	writeError(resp, http.StatusUnauthorized, models.Error{
+		Code:    models.ErrorUnauthorized,
+		Message: "not authenticated",
+	})
+}
+
+func writeInternalError(resp http.ResponseWriter) {
+	writeError(resp, http.StatusInternalServerError, models.Error{
+		Code:    models.ErrorInternal,
+		Message: "internal server error",
+	})
+}
+
+func writeStoreError(resp http.ResponseWriter, err error, notFound string, details map[string]interface{}) {
+	if e, ok := err.(tagSpaceError); ok && e.TagSpaceExhausted() {
+		writeError(resp, http.StatusServiceUnavailable, models.Error{
+			Code:    models.ErrorTagsExhausted,
+			Message: err.Error(),
+		})
+		return
+	}
+	if e, ok := err.(conflictError); ok && e.Conflict() {
+		writeError(resp, http.StatusConflict, models.Error{
+			Code:    models.ErrorConflict,
+			Message: err.Error(),
+			Details: details,
+		})
+		return
+	}
+	if e, ok := err.(notFoundError); ok && e.NotFound() {
+		if notFound == "" {
+			notFound = err.Error()
+		}
+		writeError(resp, http.StatusNotFound, models.Error{
+			Code:    models.ErrorNotFound,
+			Message: notFound,
+			Details: details,
+		})
+		return
+	}
+	writeInternalError(resp)
+}
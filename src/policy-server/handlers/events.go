@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"lib/marshal"
+	"net/http"
+	"policy-server/events"
+	"policy-server/models"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+const DefaultHeartbeatInterval = 15 * time.Second
+
+type eventSource interface {
+	Subscribe(lastEventID string) *events.Subscription
+}
+
+type Events struct {
+	Marshaler         marshal.Marshaler
+	Logger            lager.Logger
+	Source            eventSource
+	HeartbeatInterval time.Duration
+}
+
+func (h *Events) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("events")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		logger.Error("streaming-unsupported", fmt.Errorf("%T is not a flusher", resp))
+		writeInternalError(resp)
+		return
+	}
+
+	sub := h.Source.Subscribe(req.Header.Get("Last-Event-ID"))
+	defer sub.Close()
+
+	resp.Header().Set("content-type", "text/event-stream")
+	resp.Header().Set("cache-control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+
+	if sub.Reset {
+		logger.Info("reset", lager.Data{"last-event-id": req.Header.Get("Last-Event-ID")})
+		fmt.Fprint(resp, "event: reset\ndata: {}\n\n")
+	}
+	for _, event := range sub.Replay {
+		if err := h.write(resp, event); err != nil {
+			logger.Error("write-event", err)
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeatInterval := h.HeartbeatInterval
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = DefaultHeartbeatInterval
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				logger.Info("dropped-slow-client")
+				return
+			}
+			if err := h.write(resp, event); err != nil {
+				logger.Error("write-event", err)
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(resp, ": heartbeat\n\n")
+		case <-req.Context().Done():
+			logger.Info("client-gone")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *Events) write(resp http.ResponseWriter, event models.Event) error {
+	payload, err := h.Marshaler.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal: %s", err)
+	}
+	_, err = fmt.Fprintf(resp, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+	return err
+}
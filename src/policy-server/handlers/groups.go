@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"lib/marshal"
+	"net/http"
+	"policy-server/auth"
+	"policy-server/models"
+
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/rata"
+)
+
+type groupStore interface {
+	CreateGroup(logger lager.Logger, name string) (models.Group, error)
+	GetGroup(logger lager.Logger, name string) (models.Group, error)
+	ListGroups(logger lager.Logger) ([]models.Group, error)
+	AddMembers(logger lager.Logger, actor, name string, members []string) (models.Group, error)
+	RemoveMember(logger lager.Logger, actor, name, member string) (models.Group, error)
+}
+
+type groupAuthorizer interface {
+	AuthorizeCreate(logger lager.Logger, user auth.User, group string) error
+	AuthorizeMembers(logger lager.Logger, user auth.User, group string, apps []string) error
+	AuthorizeLeave(logger lager.Logger, user auth.User, group, app string) error
+	VisibleGroups(logger lager.Logger, user auth.User, groups []models.Group) ([]models.Group, error)
+}
+
+func authorizeGroup(logger lager.Logger, authorizer groupAuthorizer, resp http.ResponseWriter, req *http.Request,
+	check func(user auth.User) error) bool {
+	if authorizer == nil {
+		return true
+	}
+
+	user, ok := auth.UserFromRequest(req)
+	if !ok {
+		logger.Info("missing-user")
+		writeMissingUser(resp)
+		return false
+	}
+
+	err := check(user)
+	if e, ok := err.(forbiddenError); ok && e.Forbidden() {
+		logger.Error("authorize", err, lager.Data{"user": user.Name})
+		writeError(resp, http.StatusForbidden, models.Error{
+			Code:    models.ErrorForbidden,
+			Message: err.Error(),
+		})
+		return false
+	}
+	if e, ok := err.(conflictError); ok && e.Conflict() {
+		logger.Error("authorize", err, lager.Data{"user": user.Name})
+		writeError(resp, http.StatusConflict, models.Error{
+			Code:    models.ErrorConflict,
+			Message: err.Error(),
+		})
+		return false
+	}
+	if err != nil {
+		logger.Error("authorize", err, lager.Data{"user": user.Name})
+		writeInternalError(resp)
+		return false
+	}
+	return true
+}
+
+func visibleGroups(logger lager.Logger, authorizer groupAuthorizer, req *http.Request, groups []models.Group) ([]models.Group, error) {
+	if authorizer == nil {
+		return groups, nil
+	}
+
+	user, ok := auth.UserFromRequest(req)
+	if !ok {
+		return nil, errors.New("missing user")
+	}
+	visible, err := authorizer.VisibleGroups(logger, user, groups)
+	if err != nil {
+		logger.Error("visible-groups", err, lager.Data{"user": user.Name})
+		return nil, err
+	}
+	return visible, nil
+}
+
+func writeGroup(logger lager.Logger, marshaler marshal.Marshaler, resp http.ResponseWriter, status int, group models.Group) {
+	payload, err := marshaler.Marshal(group)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(payload)
+}
+
+type GroupsCreate struct {
+	Marshaler   marshal.Marshaler
+	Unmarshaler marshal.Unmarshaler
+	Logger      lager.Logger
+	Store       groupStore
+	Authorizer  groupAuthorizer
+}
+
+func (h *GroupsCreate) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("create-group")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logger.Error("read-body", err)
+		writeInvalid(resp, fmt.Sprintf("invalid group: %s", err), nil)
+		return
+	}
+
+	var group models.Group
+	if err := h.Unmarshaler.Unmarshal(payload, &group); err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid group: %s", err), nil)
+		return
+	}
+	audited.Group = group.Name
+	if err := group.Validate(); err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid group: %s", err), nil)
+		return
+	}
+	if len(group.Members) > 0 {
+		writeInvalid(resp, "invalid group: add members to the group once it exists",
+			map[string]interface{}{"field": "members"})
+		return
+	}
+
+	if !authorizeGroup(logger, h.Authorizer, resp, req, func(user auth.User) error {
+		return h.Authorizer.AuthorizeCreate(logger, user, group.Name)
+	}) {
+		return
+	}
+
+	created, err := h.Store.CreateGroup(logger, group.Name)
+	if err != nil {
+		logger.Error("store-create-group", err)
+		writeStoreError(resp, err, "", map[string]interface{}{"name": group.Name})
+		return
+	}
+
+	resp.Header().Set("location", "/v1/groups/"+created.Name)
+	writeGroup(logger, h.Marshaler, resp, http.StatusCreated, created)
+}
+
+type GroupsList struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      groupStore
+	Authorizer groupAuthorizer
+}
+
+func (h *GroupsList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("list-groups")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	groups, err := h.Store.ListGroups(logger)
+	if err != nil {
+		logger.Error("store-list-groups", err)
+		writeInternalError(resp)
+		return
+	}
+
+	groups, err = visibleGroups(logger, h.Authorizer, req, groups)
+	if err != nil {
+		writeInternalError(resp)
+		return
+	}
+
+	payload, err := h.Marshaler.Marshal(struct {
+		Groups []models.Group `json:"groups"`
+	}{groups})
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
+
+type GroupsGet struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      groupStore
+	Authorizer groupAuthorizer
+}
+
+func (h *GroupsGet) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	name := rata.Param(req, "name")
+	logger := h.Logger.Session("get-group", lager.Data{"group": name})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	group, err := h.Store.GetGroup(logger, name)
+	if err != nil {
+		logger.Error("store-get-group", err)
+		writeStoreError(resp, err, "", map[string]interface{}{"name": name})
+		return
+	}
+
+	visible, err := visibleGroups(logger, h.Authorizer, req, []models.Group{group})
+	if err != nil {
+		writeInternalError(resp)
+		return
+	}
+	if len(visible) == 0 {
+		writeError(resp, http.StatusNotFound, models.Error{
+			Code:    models.ErrorNotFound,
+			Message: fmt.Sprintf("group %s does not exist", name),
+			Details: map[string]interface{}{"name": name},
+		})
+		return
+	}
+
+	writeGroup(logger, h.Marshaler, resp, http.StatusOK, visible[0])
+}
+
+type GroupMembersAdd struct {
+	Marshaler   marshal.Marshaler
+	Unmarshaler marshal.Unmarshaler
+	Logger      lager.Logger
+	Store       groupStore
+	Authorizer  groupAuthorizer
+}
+
+func (h *GroupMembersAdd) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	name := rata.Param(req, "name")
+	logger := h.Logger.Session("add-group-members", lager.Data{"group": name})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	audited.Group = name
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logger.Error("read-body", err)
+		writeInvalid(resp, fmt.Sprintf("invalid members: %s", err), nil)
+		return
+	}
+
+	var members models.GroupMembers
+	if err := h.Unmarshaler.Unmarshal(payload, &members); err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid members: %s", err), nil)
+		return
+	}
+	audited.Members = members.Members
+	if len(members.Members) == 0 {
+		writeInvalid(resp, "invalid members: missing required field(s)", map[string]interface{}{"field": "members"})
+		return
+	}
+	for i, member := range members.Members {
+		if member == "" {
+			writeInvalid(resp, fmt.Sprintf("invalid member %d: missing app", i), map[string]interface{}{"index": i})
+			return
+		}
+	}
+
+	ok := authorizeGroup(logger, h.Authorizer, resp, req, func(user auth.User) error {
+		return h.Authorizer.AuthorizeMembers(logger, user, name, members.Members)
+	})
+	if !ok {
+		return
+	}
+
+	group, err := h.Store.AddMembers(logger, actor(req), name, members.Members)
+	if err != nil {
+		logger.Error("store-add-members", err)
+		writeStoreError(resp, err, "", map[string]interface{}{"name": name})
+		return
+	}
+
+	writeGroup(logger, h.Marshaler, resp, http.StatusOK, group)
+}
+
+type GroupMembersRemove struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      groupStore
+	Authorizer groupAuthorizer
+}
+
+func (h *GroupMembersRemove) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	name, member := rata.Param(req, "name"), rata.Param(req, "member")
+	logger := h.Logger.Session("remove-group-member", lager.Data{"group": name, "member": member})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	audited.Group = name
+	audited.Members = []string{member}
+
+	ok := authorizeGroup(logger, h.Authorizer, resp, req, func(user auth.User) error {
+		return h.Authorizer.AuthorizeLeave(logger, user, name, member)
+	})
+	if !ok {
+		return
+	}
+
+	group, err := h.Store.RemoveMember(logger, actor(req), name, member)
+	if err != nil {
+		logger.Error("store-remove-member", err)
+		writeStoreError(resp, err, "", map[string]interface{}{"name": name, "member": member})
+		return
+	}
+
+	visible, err := visibleGroups(logger, h.Authorizer, req, []models.Group{group})
+	if err != nil {
+		writeInternalError(resp)
+		return
+	}
+	if len(visible) == 0 {
+		// the user could only see the app that left
+		visible = []models.Group{{Name: name, Members: []string{}}}
+	}
+	writeGroup(logger, h.Marshaler, resp, http.StatusOK, visible[0])
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"lib/marshal"
+	"net/http"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type Health struct{}
+
+func (h *Health) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.WriteHeader(http.StatusOK)
+}
+
+type readiness interface {
+	Ready() bool
+}
+
+type revisioner interface {
+	Revision(logger lager.Logger) (int64, error)
+}
+
+const checkOK = "ok"
+
+type readyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+type Ready struct {
+	Logger    lager.Logger
+	Marshaler marshal.Marshaler
+	Readiness readiness
+	Store     revisioner
+	Tagger    tagger
+}
+
+func (h *Ready) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("ready")
+
+	response := readyResponse{
+		Ready: true,
+		Checks: map[string]string{
+			"serving": checkOK,
+			"store":   checkOK,
+			"tags":    checkOK,
+		},
+	}
+	fail := func(check, reason string) {
+		response.Ready = false
+		response.Checks[check] = reason
+	}
+
+	if !h.Readiness.Ready() {
+		fail("serving", "starting or draining")
+	}
+
+	if _, err := h.Store.Revision(logger); err != nil {
+		logger.Error("store-revision", err)
+		fail("store", err.Error())
+	}
+
+	stats, err := h.Tagger.Stats()
+	switch {
+	case err != nil:
+		logger.Error("tagger-stats", err)
+		fail("tags", err.Error())
+	case int64(stats.InUse+stats.Quarantined) >= stats.Capacity:
+		fail("tags", "no packet tags left to allocate")
+	}
+
+	payload, err := h.Marshaler.Marshal(response)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(payload)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"lib/marshal"
+	"net/http"
+	"strconv"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type RulesHistory struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      store
+	Authorizer adminChecker
+}
+
+func (h *RulesHistory) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("rules-history")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	if !admin(logger, h.Authorizer, resp, req, "only admins may read the history") {
+		return
+	}
+
+	history, err := h.Store.History(logger)
+	if err != nil {
+		logger.Error("store-history", err)
+		writeInternalError(resp)
+		return
+	}
+
+	payload, err := h.Marshaler.Marshal(history)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
+
+type RulesRollback struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      store
+	Authorizer adminChecker
+}
+
+func (h *RulesRollback) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("rollback-rules")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	value := req.URL.Query().Get("revision")
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.Error("parse-revision", err)
+		writeInvalid(resp, fmt.Sprintf("invalid revision: %q", value), nil)
+		return
+	}
+
+	if !admin(logger, h.Authorizer, resp, req, "only admins may roll back the rules") {
+		return
+	}
+
+	logger.Info("rolling-back", lager.Data{"revision": revision, "user": actor(req)})
+	result, err := h.Store.Rollback(logger, actor(req), revision)
+	if err != nil {
+		logger.Error("store-rollback", err)
+		writeStoreError(resp, err, "", map[string]interface{}{"revision": revision})
+		return
+	}
+	audited.Add, audited.Delete, audited.Revision = result.Added, result.Deleted, result.Revision
+
+	logger.Info("rolled-back", lager.Data{"added": len(result.Added), "deleted": len(result.Deleted), "revision": result.Revision})
+	writeBatchResult(logger, h.Marshaler, resp, result)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type gatherer interface {
+	Gather() ([]byte, error)
+}
+
+type Metrics struct {
+	Logger   lager.Logger
+	Registry gatherer
+}
+
+func (h *Metrics) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	payload, err := h.Registry.Gather()
+	if err != nil {
+		h.Logger.Error("gather-metrics", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
@@ -1,25 +1,184 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"lib/marshal"
 	"net/http"
+	"policy-server/audit"
+	"policy-server/auth"
 	"policy-server/models"
+	"sort"
+	"strconv"
 
 	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/rata"
 )
 
 type store interface {
-	Add(logger lager.Logger, rule models.Rule) error
-	Delete(logger lager.Logger, rule models.Rule) error
+	Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error)
+	Delete(logger lager.Logger, actor string, rule models.Rule) error
+	ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error)
+	Sync(logger lager.Logger, actor, owner string, rules []models.Rule, revision int64, dryRun bool) (models.BatchResult, error)
+	Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error)
+	History(logger lager.Logger) ([]models.Change, error)
 	List(logger lager.Logger) ([]models.Rule, error)
 	GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error)
+	Revision(logger lager.Logger) (int64, error)
+}
+
+type tagSpaceError interface {
+	TagSpaceExhausted() bool
+}
+
+type notFoundError interface {
+	NotFound() bool
+}
+
+type conflictError interface {
+	Conflict() bool
+}
+
+type authorizer interface {
+	Authorize(logger lager.Logger, user auth.User, rules []models.Rule) error
+	Visible(logger lager.Logger, user auth.User, rules []models.Rule) ([]models.Rule, error)
+	FirstVisible(logger lager.Logger, user auth.User, rules []models.Rule, n int) ([]models.Rule, error)
+}
+
+type forbiddenError interface {
+	Forbidden() bool
+}
+
+func auditRecord(req *http.Request) *audit.Record {
+	record := audit.RecordFromRequest(req)
+	if user, ok := auth.UserFromRequest(req); ok {
+		record.Actor, record.ActorID = user.Name, user.ID
+	}
+	return record
+}
+
+func actor(req *http.Request) string {
+	user, _ := auth.UserFromRequest(req)
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.ID
+}
+
+func revision(logger lager.Logger, store store) int64 {
+	revision, err := store.Revision(logger)
+	if err != nil {
+		logger.Error("store-revision", err)
+	}
+	return revision
+}
+
+func authorize(logger lager.Logger, authorizer authorizer, store store, resp http.ResponseWriter, req *http.Request, rules []models.Rule) bool {
+	if authorizer == nil {
+		return true
+	}
+
+	user, ok := auth.UserFromRequest(req)
+	if !ok {
+		logger.Info("missing-user")
+		writeMissingUser(resp)
+		return false
+	}
+
+	rules, err := lookupRules(logger, store, rules)
+	if err != nil {
+		writeInternalError(resp)
+		return false
+	}
+
+	err = authorizer.Authorize(logger, user, rules)
+	if e, ok := err.(forbiddenError); ok && e.Forbidden() {
+		logger.Error("authorize", err, lager.Data{"user": user.Name})
+		writeError(resp, http.StatusForbidden, models.Error{
+			Code:    models.ErrorForbidden,
+			Message: err.Error(),
+		})
+		return false
+	}
+	if err != nil {
+		logger.Error("authorize", err, lager.Data{"user": user.Name})
+		writeInternalError(resp)
+		return false
+	}
+	return true
+}
+
+func lookupRules(logger lager.Logger, store store, rules []models.Rule) ([]models.Rule, error) {
+	byID := false
+	for _, rule := range rules {
+		byID = byID || rule.ID != ""
+	}
+	if !byID {
+		return rules, nil
+	}
+
+	stored, err := store.List(logger)
+	if err != nil {
+		logger.Error("store-list", err)
+		return nil, err
+	}
+	found := map[string]models.Rule{}
+	for _, rule := range stored {
+		found[rule.ID] = rule
+	}
+
+	complete := []models.Rule{}
+	for _, rule := range rules {
+		if rule.ID == "" {
+			complete = append(complete, rule)
+		} else if r, ok := found[rule.ID]; ok {
+			complete = append(complete, r)
+		}
+	}
+	return complete, nil
+}
+
+const maxPageSize = 1000
+
+type byID []models.Rule
+
+func (r byID) Len() int      { return len(r) }
+func (r byID) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byID) Less(i, j int) bool {
+	return idLess(r[i].ID, r[j].ID)
+}
+
+func idLess(a, b string) bool {
+	x, errX := strconv.ParseInt(a, 10, 64)
+	y, errY := strconv.ParseInt(b, 10, 64)
+	if errX == nil && errY == nil {
+		return x < y
+	}
+	if (errX == nil) != (errY == nil) {
+		return errX == nil
+	}
+	return a < b
+}
+
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", errors.New("invalid cursor")
+	}
+	return string(id), nil
 }
 
 type RulesList struct {
-	Marshaler marshal.Marshaler
-	Logger    lager.Logger
-	Store     store
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      store
+	Authorizer authorizer
 }
 
 func (h *RulesList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
@@ -27,17 +186,139 @@ func (h *RulesList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	logger.Info("start")
 	defer logger.Info("done")
 
+	query := req.URL.Query()
+	filter := models.RuleFilter{
+		Source:      query.Get("source"),
+		Destination: query.Get("destination"),
+		Group:       query.Get("group"),
+		Owner:       query.Get("owner"),
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			logger.Info("invalid-limit", lager.Data{"limit": value})
+			writeInvalid(resp, fmt.Sprintf("invalid limit: %q is not a number from 1 to %d", value, maxPageSize),
+				map[string]interface{}{"param": "limit"})
+			return
+		}
+	}
+
+	after := ""
+	if value := query.Get("cursor"); value != "" {
+		var err error
+		after, err = decodeCursor(value)
+		if err != nil {
+			logger.Error("decode-cursor", err)
+			writeInvalid(resp, err.Error(), map[string]interface{}{"param": "cursor"})
+			return
+		}
+	}
+
 	all, err := h.Store.List(logger)
 	if err != nil {
 		logger.Error("store-list", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(resp)
 		return
 	}
 
-	payload, err := h.Marshaler.Marshal(all)
+	rules := []models.Rule{}
+	for _, rule := range all {
+		if filter.Matches(rule) && (after == "" || idLess(after, rule.ID)) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Sort(byID(rules))
+
+	if h.Authorizer != nil {
+		user, ok := auth.UserFromRequest(req)
+		if !ok {
+			logger.Info("missing-user")
+			writeMissingUser(resp)
+			return
+		}
+		// one more than a page tells whether there is a next page
+		n := len(rules)
+		if limit > 0 && limit < n {
+			n = limit + 1
+		}
+		rules, err = h.Authorizer.FirstVisible(logger, user, rules, n)
+		if err != nil {
+			logger.Error("visible", err, lager.Data{"user": user.Name})
+			writeInternalError(resp)
+			return
+		}
+	}
+
+	if limit > 0 && len(rules) > limit {
+		rules = rules[:limit]
+		next := *req.URL
+		query.Set("cursor", encodeCursor(rules[limit-1].ID))
+		next.RawQuery = query.Encode()
+		resp.Header().Add("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+
+	payload, err := h.Marshaler.Marshal(rules)
 	if err != nil {
 		logger.Error("marshal-failed", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
+
+type RulesGet struct {
+	Marshaler  marshal.Marshaler
+	Logger     lager.Logger
+	Store      store
+	Authorizer authorizer
+}
+
+func (h *RulesGet) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	id := rata.Param(req, "id")
+	logger := h.Logger.Session("get-rule", lager.Data{"id": id})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	found, err := lookupRules(logger, h.Store, []models.Rule{{ID: id}})
+	if err != nil {
+		writeInternalError(resp)
+		return
+	}
+
+	if h.Authorizer != nil && len(found) > 0 {
+		user, ok := auth.UserFromRequest(req)
+		if !ok {
+			logger.Info("missing-user")
+			writeMissingUser(resp)
+			return
+		}
+		found, err = h.Authorizer.Visible(logger, user, found)
+		if err != nil {
+			logger.Error("visible", err, lager.Data{"user": user.Name})
+			writeInternalError(resp)
+			return
+		}
+	}
+
+	if len(found) == 0 {
+		writeError(resp, http.StatusNotFound, models.Error{
+			Code:    models.ErrorNotFound,
+			Message: fmt.Sprintf("rule %s does not exist", id),
+			Details: map[string]interface{}{"id": id},
+		})
+		return
+	}
+
+	payload, err := h.Marshaler.Marshal(found[0])
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
 		return
 	}
 
@@ -47,12 +328,14 @@ func (h *RulesList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 }
 
 type RulesAdd struct {
+	Marshaler   marshal.Marshaler
 	Unmarshaler marshal.Unmarshaler
 	Logger      lager.Logger
 	Store       store
+	Authorizer  authorizer
 }
 
-func readRule(unmarshaler marshal.Unmarshaler, req *http.Request) (models.Rule, error) {
+func readRule(unmarshaler marshal.Unmarshaler, req *http.Request, byID bool) (models.Rule, error) {
 	payload, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return models.Rule{}, err
@@ -64,9 +347,14 @@ func readRule(unmarshaler marshal.Unmarshaler, req *http.Request) (models.Rule,
 		return models.Rule{}, err
 	}
 
+	if byID && rule.ID != "" {
+		return models.Rule{ID: rule.ID}, nil
+	}
 	if err := rule.Validate(); err != nil {
 		return models.Rule{}, err
 	}
+	// IDs are assigned by the server
+	rule.ID = ""
 	return rule, nil
 }
 
@@ -75,28 +363,52 @@ func (h *RulesAdd) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	logger.Info("start")
 	defer logger.Info("done")
 
-	rule, err := readRule(h.Unmarshaler, req)
+	audited := auditRecord(req)
+	rule, err := readRule(h.Unmarshaler, req, false)
 	if err != nil {
-		resp.WriteHeader(http.StatusBadRequest)
+		writeInvalid(resp, fmt.Sprintf("invalid rule: %s", err), nil)
 		return
 	}
+	audited.Add = []models.Rule{rule}
 
-	logger.Info("adding", lager.Data{"rule": rule})
+	user, _ := auth.UserFromRequest(req)
+	logger.Info("adding", lager.Data{"rule": rule, "user": user.Name})
+	if !authorize(logger, h.Authorizer, h.Store, resp, req, []models.Rule{rule}) {
+		return
+	}
 
-	err = h.Store.Add(logger, rule)
+	stored, created, err := h.Store.Add(logger, actor(req), rule)
 	if err != nil {
 		logger.Error("store-add", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(resp, err, "", nil)
+		return
+	}
+
+	audited.Add = []models.Rule{stored}
+	audited.Revision = revision(logger, h.Store)
+
+	payload, err := h.Marshaler.Marshal(stored)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
 		return
 	}
 
-	resp.WriteHeader(http.StatusCreated)
+	resp.Header().Set("content-type", "application/json")
+	resp.Header().Set("location", "/v1/policies/"+stored.ID)
+	if created {
+		resp.WriteHeader(http.StatusCreated)
+	} else {
+		resp.WriteHeader(http.StatusOK)
+	}
+	resp.Write(payload)
 }
 
 type RulesDelete struct {
 	Unmarshaler marshal.Unmarshaler
 	Logger      lager.Logger
 	Store       store
+	Authorizer  authorizer
 }
 
 func (h *RulesDelete) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
@@ -104,19 +416,208 @@ func (h *RulesDelete) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	logger.Info("start")
 	defer logger.Info("done")
 
-	rule, err := readRule(h.Unmarshaler, req)
-	if err != nil {
-		resp.WriteHeader(http.StatusBadRequest)
-		return
+	audited := auditRecord(req)
+	rule := models.Rule{ID: rata.Param(req, "id")}
+	if rule.ID == "" {
+		var err error
+		rule, err = readRule(h.Unmarshaler, req, true)
+		if err != nil {
+			writeInvalid(resp, fmt.Sprintf("invalid rule: %s", err), nil)
+			return
+		}
+	}
+	audited.Delete = []models.Rule{rule}
+	if rule.ID != "" {
+		// record which path the ID stood for
+		if found, err := lookupRules(logger, h.Store, audited.Delete); err == nil && len(found) == 1 {
+			audited.Delete = found
+		}
 	}
 
-	logger.Info("deleting", lager.Data{"rule": rule})
+	user, _ := auth.UserFromRequest(req)
+	logger.Info("deleting", lager.Data{"rule": rule, "user": user.Name})
+	if !authorize(logger, h.Authorizer, h.Store, resp, req, []models.Rule{rule}) {
+		return
+	}
 
-	err = h.Store.Delete(logger, rule)
+	err := h.Store.Delete(logger, actor(req), rule)
 	if err != nil {
 		logger.Error("store-delete", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(resp, err, "the rule to delete does not exist", map[string]interface{}{"rule": rule})
 		return
 	}
+	audited.Revision = revision(logger, h.Store)
 	resp.WriteHeader(http.StatusNoContent)
 }
+
+type RulesBatch struct {
+	Marshaler   marshal.Marshaler
+	Unmarshaler marshal.Unmarshaler
+	Logger      lager.Logger
+	Store       store
+	Authorizer  authorizer
+}
+
+func (h *RulesBatch) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("apply-batch")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid batch: %s", err), nil)
+		return
+	}
+
+	var batch models.Batch
+	if err := h.Unmarshaler.Unmarshal(payload, &batch); err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid batch: %s", err), nil)
+		return
+	}
+	audited.Add, audited.Delete = batch.Add, batch.Delete
+	if err := batch.Validate(); err != nil {
+		logger.Error("validate", err)
+		writeInvalid(resp, fmt.Sprintf("invalid batch: %s", err), nil)
+		return
+	}
+
+	user, _ := auth.UserFromRequest(req)
+	logger.Info("applying", lager.Data{"add": len(batch.Add), "delete": len(batch.Delete), "user": user.Name})
+	if !authorize(logger, h.Authorizer, h.Store, resp, req, append(batch.Add, batch.Delete...)) {
+		return
+	}
+
+	result, err := h.Store.ApplyBatch(logger, actor(req), batch)
+	if err != nil {
+		logger.Error("store-apply-batch", err)
+		writeStoreError(resp, err, "a rule to delete does not exist", nil)
+		return
+	}
+	audited.Add, audited.Delete, audited.Revision = result.Added, result.Deleted, result.Revision
+
+	writeBatchResult(logger, h.Marshaler, resp, result)
+}
+
+func writeBatchResult(logger lager.Logger, marshaler marshal.Marshaler, resp http.ResponseWriter, result models.BatchResult) {
+	payload, err := marshaler.Marshal(result)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
+
+func ownedRules(logger lager.Logger, store store, owner string) ([]models.Rule, error) {
+	all, err := store.List(logger)
+	if err != nil {
+		logger.Error("store-list", err)
+		return nil, err
+	}
+	owned := []models.Rule{}
+	for _, rule := range all {
+		if rule.Owner == owner {
+			owned = append(owned, rule)
+		}
+	}
+	return owned, nil
+}
+
+type RulesSync struct {
+	Marshaler   marshal.Marshaler
+	Unmarshaler marshal.Unmarshaler
+	Logger      lager.Logger
+	Store       store
+	Authorizer  authorizer
+}
+
+func (h *RulesSync) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	owner := rata.Param(req, "owner")
+	logger := h.Logger.Session("sync-rules", lager.Data{"owner": owner})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	audited := auditRecord(req)
+	audited.Owner = owner
+
+	dryRun := false
+	if value := req.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			logger.Error("parse-dry-run", err)
+			writeInvalid(resp, fmt.Sprintf("invalid dry_run: %q", value), nil)
+			return
+		}
+	}
+	audited.DryRun = dryRun
+
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid rules: %s", err), nil)
+		return
+	}
+
+	var rules []models.Rule
+	if err := h.Unmarshaler.Unmarshal(payload, &rules); err != nil {
+		writeInvalid(resp, fmt.Sprintf("invalid rules: %s", err), nil)
+		return
+	}
+	audited.Add = rules
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			logger.Error("validate", err, lager.Data{"index": i})
+			writeInvalid(resp, fmt.Sprintf("invalid rule %d: %s", i, err), map[string]interface{}{"index": i})
+			return
+		}
+		if rule.Owner != "" && rule.Owner != owner {
+			err := fmt.Errorf("rule %d belongs to %q", i, rule.Owner)
+			logger.Error("validate", err)
+			writeError(resp, http.StatusConflict, models.Error{
+				Code:    models.ErrorConflict,
+				Message: err.Error(),
+				Details: map[string]interface{}{"index": i, "owner": rule.Owner},
+			})
+			return
+		}
+	}
+
+	user, _ := auth.UserFromRequest(req)
+	logger.Info("syncing", lager.Data{"rules": len(rules), "user": user.Name})
+	revision := models.AnyRevision
+	if h.Authorizer != nil {
+		// read first, so that a change after this makes the sync conflict
+		var err error
+		revision, err = h.Store.Revision(logger)
+		if err != nil {
+			logger.Error("store-revision", err)
+			writeInternalError(resp)
+			return
+		}
+
+		// the rules that the owner has now may be deleted
+		owned, err := ownedRules(logger, h.Store, owner)
+		if err != nil {
+			writeInternalError(resp)
+			return
+		}
+		if !authorize(logger, h.Authorizer, h.Store, resp, req, append(owned, rules...)) {
+			return
+		}
+	}
+
+	result, err := h.Store.Sync(logger, actor(req), owner, rules, revision, dryRun)
+	if err != nil {
+		logger.Error("store-sync", err)
+		writeStoreError(resp, err, "", nil)
+		return
+	}
+	audited.Add, audited.Delete, audited.Revision = result.Added, result.Deleted, result.Revision
+
+	logger.Info("synced", lager.Data{"added": len(result.Added), "deleted": len(result.Deleted), "dry-run": dryRun})
+	writeBatchResult(logger, h.Marshaler, resp, result)
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import "net/http"
+
+type Spec struct {
+	Document []byte
+}
+
+func (h *Spec) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(h.Document)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"lib/marshal"
+	"net/http"
+	"policy-server/models"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type tagger interface {
+	Stats() (models.TagStats, error)
+}
+
+type TagStats struct {
+	Marshaler marshal.Marshaler
+	Logger    lager.Logger
+	Tagger    tagger
+}
+
+func (h *TagStats) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("tag-stats")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	stats, err := h.Tagger.Stats()
+	if err != nil {
+		logger.Error("tagger-stats", err)
+		writeInternalError(resp)
+		return
+	}
+
+	payload, err := h.Marshaler.Marshal(stats)
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		writeInternalError(resp)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(payload)
+}
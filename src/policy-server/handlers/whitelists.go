@@ -1,17 +1,39 @@
 package handlers
 
 import (
+	"crypto/sha1"
+	"fmt"
 	"lib/marshal"
 	"net/http"
+	"net/url"
+	"policy-server/models"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pivotal-golang/lager"
 )
 
+const DefaultLongPollTimeout = 30 * time.Second
+
+type whitelistStore interface {
+	GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error)
+	Revision(logger lager.Logger) (int64, error)
+	Changes() <-chan struct{}
+}
+
 type Whitelists struct {
-	Marshaler marshal.Marshaler
-	Logger    lager.Logger
-	Store     store
+	Marshaler       marshal.Marshaler
+	Logger          lager.Logger
+	Store           whitelistStore
+	LongPollTimeout time.Duration
+	PollInterval    time.Duration
+}
+
+type whitelistView struct {
+	revision int64
+	payload  []byte
+	etag     string
 }
 
 func (h *Whitelists) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
@@ -19,22 +41,207 @@ func (h *Whitelists) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	logger.Info("start")
 	defer logger.Info("done")
 
-	groups := strings.Split(req.URL.Query().Get("groups"), ",")
-	all, err := h.Store.GetWhitelists(logger, groups)
+	query := req.URL.Query()
+	groups := groupsParam(query)
+
+	var compact bool
+	switch format := query.Get("format"); format {
+	case "":
+	case "compact":
+		compact = true
+	default:
+		logger.Info("invalid-format", lager.Data{"format": format})
+		writeInvalid(resp, fmt.Sprintf("invalid format: %q", format), map[string]interface{}{"param": "format"})
+		return
+	}
+
+	waiting := query.Get("wait_for_revision") != ""
+	var waitForRevision int64
+	if waiting {
+		var err error
+		waitForRevision, err = strconv.ParseInt(query.Get("wait_for_revision"), 10, 64)
+		if err != nil {
+			logger.Error("parse-wait-for-revision", err)
+			writeInvalid(resp, fmt.Sprintf("invalid wait_for_revision: %q", query.Get("wait_for_revision")), nil)
+			return
+		}
+	}
+
+	// subscribe before reading so that no change can slip in between
+	changes := h.Store.Changes()
+	view, err := h.view(logger, groups, compact)
+	if err != nil {
+		writeInternalError(resp)
+		return
+	}
+
+	clientETag := req.Header.Get("If-None-Match")
+	baseline := clientETag
+	if waiting && baseline == "" && view.revision == waitForRevision {
+		baseline = view.etag
+	}
+
+	if waiting && view.etag == baseline {
+		timeout := h.LongPollTimeout
+		if timeout <= 0 {
+			timeout = DefaultLongPollTimeout
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		var poll <-chan time.Time
+		if h.PollInterval > 0 {
+			ticker := time.NewTicker(h.PollInterval)
+			defer ticker.Stop()
+			poll = ticker.C
+		}
+
+	wait:
+		for view.etag == baseline {
+			select {
+			case <-changes:
+			case <-poll:
+			case <-timer.C:
+				break wait
+			case <-req.Context().Done():
+				logger.Info("client-gone")
+				return
+			}
+
+			changes = h.Store.Changes()
+			view, err = h.view(logger, groups, compact)
+			if err != nil {
+				writeInternalError(resp)
+				return
+			}
+		}
+	}
+
+	resp.Header().Set("ETag", view.etag)
+	resp.Header().Set(models.RevisionHeader, strconv.FormatInt(view.revision, 10))
+
+	if view.etag == clientETag || (waiting && view.etag == baseline) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp.Header().Set("content-type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(view.payload)
+}
+
+const viewAttempts = 3
+
+func (h *Whitelists) view(logger lager.Logger, groups []string, compact bool) (whitelistView, error) {
+	var revision int64
+	var all []models.IngressWhitelist
+	for attempt := 0; attempt < viewAttempts; attempt++ {
+		before, err := h.Store.Revision(logger)
+		if err != nil {
+			logger.Error("store-revision", err)
+			return whitelistView{}, err
+		}
+
+		all, err = h.Store.GetWhitelists(logger, groups)
+		if err != nil {
+			logger.Error("store-get-whitelists", err)
+			return whitelistView{}, err
+		}
+
+		after, err := h.Store.Revision(logger)
+		if err != nil {
+			logger.Error("store-revision", err)
+			return whitelistView{}, err
+		}
+		if attempt == 0 {
+			revision = before
+		}
+		if after == before {
+			revision = before
+			break
+		}
+	}
+
+	var payload []byte
+	var err error
+	if compact {
+		payload, err = h.Marshaler.Marshal(models.Compact(all))
+	} else {
+		payload, err = h.Marshaler.Marshal(all)
+	}
+	if err != nil {
+		logger.Error("marshal-failed", err)
+		return whitelistView{}, err
+	}
+
+	return whitelistView{
+		revision: revision,
+		payload:  payload,
+		etag:     fmt.Sprintf(`"%x"`, sha1.Sum(payload)),
+	}, nil
+}
+
+type whitelistChangesStore interface {
+	WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error)
+}
+
+type resyncError interface {
+	ResyncRequired() bool
+}
+
+type WhitelistChanges struct {
+	Marshaler marshal.Marshaler
+	Logger    lager.Logger
+	Store     whitelistChangesStore
+}
+
+func (h *WhitelistChanges) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.Logger.Session("whitelist-changes")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	query := req.URL.Query()
+	since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+	if err != nil {
+		logger.Info("invalid-since", lager.Data{"since": query.Get("since")})
+		writeInvalid(resp, fmt.Sprintf("invalid since: %q", query.Get("since")), map[string]interface{}{"param": "since"})
+		return
+	}
+
+	changes, err := h.Store.WhitelistChanges(logger, since, groupsParam(query))
+	if e, ok := err.(resyncError); ok && e.ResyncRequired() {
+		logger.Info("resync-required", lager.Data{"since": since})
+		writeError(resp, http.StatusGone, models.Error{
+			Code:    models.ErrorResyncRequired,
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		logger.Error("store-get-whitelists", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		logger.Error("store-whitelist-changes", err)
+		writeInternalError(resp)
 		return
 	}
 
-	payload, err := h.Marshaler.Marshal(all)
+	payload, err := h.Marshaler.Marshal(changes)
 	if err != nil {
 		logger.Error("marshal-failed", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(resp)
 		return
 	}
 
+	resp.Header().Set(models.RevisionHeader, strconv.FormatInt(changes.Revision, 10))
 	resp.Header().Set("content-type", "application/json")
 	resp.WriteHeader(http.StatusOK)
 	resp.Write(payload)
 }
+
+func groupsParam(query url.Values) []string {
+	var groups []string
+	for _, group := range strings.Split(query.Get("groups"), ",") {
+		if group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,58 @@
+package health
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+type Readiness struct {
+	Logger lager.Logger
+	Clock  clock.Clock
+	Drain  time.Duration
+
+	lock  sync.Mutex
+	ready bool
+}
+
+func (r *Readiness) Ready() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.ready
+}
+
+func (r *Readiness) setReady(ready bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.ready = ready
+}
+
+func (r *Readiness) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := r.Logger.Session("readiness")
+
+	r.setReady(true)
+	logger.Info("ready")
+	close(ready)
+
+	signal := <-signals
+	r.setReady(false)
+	logger.Info("draining", lager.Data{"signal": signal.String(), "drain": r.Drain.String()})
+
+	if r.Drain > 0 {
+		timer := r.Clock.NewTimer(r.Drain)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+		case <-signals:
+			// only a second, different signal cuts the drain short
+		}
+	}
+	logger.Info("drained")
+	return nil
+}
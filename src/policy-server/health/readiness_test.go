@@ -0,0 +1,68 @@
+package health_test
+
+import (
+	"os"
+	"policy-server/health"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Readiness", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		readiness *health.Readiness
+		process   ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		readiness = &health.Readiness{
+			Logger: lagertest.NewTestLogger("test"),
+			Clock:  fakeClock,
+			Drain:  10 * time.Second,
+		}
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Kill)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("is only ready while running", func() {
+		readiness.Drain = 0
+		Expect(readiness.Ready()).To(BeFalse())
+
+		process = ifrit.Invoke(readiness)
+		Expect(readiness.Ready()).To(BeTrue())
+
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(readiness.Ready()).To(BeFalse())
+	})
+
+	It("reports not ready for the drain period before exiting", func() {
+		process = ifrit.Invoke(readiness)
+		process.Signal(os.Interrupt)
+
+		Eventually(readiness.Ready).Should(BeFalse())
+		Consistently(process.Wait()).ShouldNot(Receive())
+
+		fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("stops draining on a second signal", func() {
+		process = ifrit.Invoke(readiness)
+		process.Signal(os.Interrupt)
+		Eventually(readiness.Ready).Should(BeFalse())
+
+		process.Signal(syscall.SIGTERM)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+})
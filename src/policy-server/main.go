@@ -1,14 +1,32 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"lib/marshal"
+	"net/http"
+	"net/http/pprof"
 	"os"
+	"policy-server/api"
+	"policy-server/audit"
+	"policy-server/auth"
+	"policy-server/cc"
 	"policy-server/config"
+	"policy-server/events"
 	"policy-server/handlers"
+	"policy-server/health"
+	"policy-server/metrics"
+	"policy-server/models"
+	"policy-server/mutualtls"
 	"policy-server/store"
+	"policy-server/tlsconfig"
+	"time"
 
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
@@ -39,41 +57,237 @@ func main() {
 	marshaler := marshal.MarshalFunc(json.Marshal)
 	unmarshaler := marshal.UnmarshalFunc(json.Unmarshal)
 
-	packetTagger, err := store.NewMemoryTagger(4)
+	tagQuarantine := time.Duration(*conf.TagQuarantineSeconds) * time.Second
+	broadcaster := events.NewBroadcaster(events.DefaultHistorySize, events.DefaultBufferSize)
+	rulesStore, groupStore, packetTagger, err := newStore(logger, conf.Store, conf.TagBits, tagQuarantine, broadcaster)
 	if err != nil {
-		logger.Error("packet tag", err)
+		logger.Error("store", err)
 		os.Exit(1)
 	}
 
-	rulesStore := store.NewMemoryStore(packetTagger)
+	registry := metrics.NewRegistry()
+	metrics.RegisterRules(registry, logger.Session("metrics"), rulesStore)
+	metrics.RegisterTags(registry, packetTagger)
+	rulesStore = metrics.NewTimedStore(rulesStore, registry, clock.NewClock())
+
+	authenticate, authorizer, err := newAuth(logger, conf.Auth, groupStore)
+	if err != nil {
+		logger.Error("auth", err)
+		os.Exit(1)
+	}
+
+	auditLog, err := newAuditLog(logger, conf.Audit)
+	if err != nil {
+		logger.Error("audit", err)
+		os.Exit(1)
+	}
+	auditor := &audit.Auditor{
+		Log:    auditLog,
+		Logger: logger,
+		Clock:  clock.NewClock(),
+	}
+
+	readiness := &health.Readiness{
+		Logger: logger,
+		Clock:  clock.NewClock(),
+		Drain:  time.Duration(conf.DrainSeconds) * time.Second,
+	}
 
 	rataHandlers := rata.Handlers{}
-	rataHandlers["rules_list"] = &handlers.RulesList{
+	rataHandlers["health"] = &handlers.Health{}
+	rataHandlers["ready"] = &handlers.Ready{
 		Logger:    logger,
 		Marshaler: marshaler,
+		Readiness: readiness,
 		Store:     rulesStore,
+		Tagger:    packetTagger,
 	}
-	rataHandlers["rules_add"] = &handlers.RulesAdd{
+	rataHandlers["rules_list"] = authenticate(&handlers.RulesList{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      rulesStore,
+		Authorizer: authorizer,
+	})
+	rataHandlers["rules_get"] = authenticate(&handlers.RulesGet{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      rulesStore,
+		Authorizer: authorizer,
+	})
+	rataHandlers["rules_add"] = auditor.Wrap("add", authenticate(&handlers.RulesAdd{
 		Logger:      logger,
+		Marshaler:   marshaler,
 		Unmarshaler: unmarshaler,
 		Store:       rulesStore,
-	}
-	rataHandlers["rules_delete"] = &handlers.RulesDelete{
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["rules_delete"] = auditor.Wrap("delete", authenticate(&handlers.RulesDelete{
+		Logger:      logger,
+		Unmarshaler: unmarshaler,
+		Store:       rulesStore,
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["rules_batch"] = auditor.Wrap("batch", authenticate(&handlers.RulesBatch{
 		Logger:      logger,
+		Marshaler:   marshaler,
 		Unmarshaler: unmarshaler,
 		Store:       rulesStore,
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["rules_sync"] = auditor.Wrap("sync", authenticate(&handlers.RulesSync{
+		Logger:      logger,
+		Marshaler:   marshaler,
+		Unmarshaler: unmarshaler,
+		Store:       rulesStore,
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["rules_history"] = authenticate(&handlers.RulesHistory{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      rulesStore,
+		Authorizer: authorizer,
+	})
+	rataHandlers["rules_rollback"] = auditor.Wrap("rollback", authenticate(&handlers.RulesRollback{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      rulesStore,
+		Authorizer: authorizer,
+	}))
+	rataHandlers["audit"] = authenticate(&handlers.AuditList{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Log:        auditLog,
+		Authorizer: authorizer,
+	})
+	rataHandlers["groups_list"] = authenticate(&handlers.GroupsList{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      groupStore,
+		Authorizer: authorizer,
+	})
+	rataHandlers["groups_create"] = auditor.Wrap("create-group", authenticate(&handlers.GroupsCreate{
+		Logger:      logger,
+		Marshaler:   marshaler,
+		Unmarshaler: unmarshaler,
+		Store:       groupStore,
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["groups_get"] = authenticate(&handlers.GroupsGet{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      groupStore,
+		Authorizer: authorizer,
+	})
+	rataHandlers["group_members_add"] = auditor.Wrap("add-members", authenticate(&handlers.GroupMembersAdd{
+		Logger:      logger,
+		Marshaler:   marshaler,
+		Unmarshaler: unmarshaler,
+		Store:       groupStore,
+		Authorizer:  authorizer,
+	}))
+	rataHandlers["group_members_remove"] = auditor.Wrap("remove-member", authenticate(&handlers.GroupMembersRemove{
+		Logger:     logger,
+		Marshaler:  marshaler,
+		Store:      groupStore,
+		Authorizer: authorizer,
+	}))
+	rataHandlers["spec_v1"] = &handlers.Spec{Document: []byte(api.V1)}
+
+	routes := rata.Routes{
+		{Name: "health", Method: "GET", Path: "/health"},
+		{Name: "ready", Method: "GET", Path: "/ready"},
+		{Name: "spec_v1", Method: "GET", Path: "/v1/spec"},
+		{Name: "rules_list", Method: "GET", Path: "/v1/policies"},
+		{Name: "rules_add", Method: "POST", Path: "/v1/policies"},
+		{Name: "rules_batch", Method: "POST", Path: "/v1/policies/batch"},
+		{Name: "rules_history", Method: "GET", Path: "/v1/policies/history"},
+		{Name: "rules_rollback", Method: "POST", Path: "/v1/policies/rollback"},
+		{Name: "rules_get", Method: "GET", Path: "/v1/policies/:id"},
+		{Name: "rules_delete", Method: "DELETE", Path: "/v1/policies/:id"},
+		{Name: "rules_sync", Method: "PUT", Path: "/v1/owners/:owner/policies"},
+		{Name: "groups_list", Method: "GET", Path: "/v1/groups"},
+		{Name: "groups_create", Method: "POST", Path: "/v1/groups"},
+		{Name: "groups_get", Method: "GET", Path: "/v1/groups/:name"},
+		{Name: "group_members_add", Method: "POST", Path: "/v1/groups/:name/members"},
+		{Name: "group_members_remove", Method: "DELETE", Path: "/v1/groups/:name/members/:member"},
+		{Name: "audit", Method: "GET", Path: "/v1/audit"},
+	}
+
+	legacyRoutes := []struct {
+		route     rata.Route
+		successor string
+	}{
+		{rata.Route{Name: "rules_list", Method: "GET", Path: "/rules"}, "/v1/policies"},
+		{rata.Route{Name: "rules_add", Method: "POST", Path: "/rules/add"}, "/v1/policies"},
+		{rata.Route{Name: "rules_delete", Method: "POST", Path: "/rules/delete"}, "/v1/policies/{id}"},
+		{rata.Route{Name: "rules_batch", Method: "POST", Path: "/rules/batch"}, "/v1/policies/batch"},
+		{rata.Route{Name: "rules_sync", Method: "PUT", Path: "/rules/owners/:owner"}, "/v1/owners/{owner}/policies"},
+		{rata.Route{Name: "rules_history", Method: "GET", Path: "/rules/history"}, "/v1/policies/history"},
+		{rata.Route{Name: "rules_rollback", Method: "POST", Path: "/rules/rollback"}, "/v1/policies/rollback"},
+		{rata.Route{Name: "audit", Method: "GET", Path: "/audit"}, "/v1/audit"},
 	}
-	rataHandlers["whitelists"] = &handlers.Whitelists{
+	for _, legacy := range legacyRoutes {
+		name := "legacy_" + legacy.route.Name
+		rataHandlers[name] = &handlers.Deprecated{
+			Handler:   rataHandlers[legacy.route.Name],
+			Successor: legacy.successor,
+		}
+		routes = append(routes, rata.Route{Name: name, Method: legacy.route.Method, Path: legacy.route.Path})
+	}
+
+	var whitelistPollInterval time.Duration
+	if conf.Store.Type == config.StoreTypeSQL {
+		// other servers may share the database
+		whitelistPollInterval = time.Second
+	}
+	// apps in groups have the tags and whitelists of their groups
+	groupedWhitelists := &store.GroupedWhitelists{Rules: rulesStore, Groups: groupStore}
+	innerHandlers := rata.Handlers{}
+	innerHandlers["whitelists"] = &handlers.Whitelists{
+		Logger:          logger,
+		Marshaler:       marshaler,
+		Store:           groupedWhitelists,
+		LongPollTimeout: time.Duration(conf.LongPollSeconds) * time.Second,
+		PollInterval:    whitelistPollInterval,
+	}
+	innerHandlers["whitelist_changes"] = &handlers.WhitelistChanges{
 		Logger:    logger,
 		Marshaler: marshaler,
-		Store:     rulesStore,
+		Store:     groupedWhitelists,
+	}
+	innerHandlers["events"] = &handlers.Events{
+		Logger:    logger,
+		Marshaler: marshaler,
+		Source:    broadcaster,
+	}
+	innerHandlers["tag_stats"] = &handlers.TagStats{
+		Logger:    logger,
+		Marshaler: marshaler,
+		Tagger:    packetTagger,
 	}
 
-	routes := rata.Routes{
-		{Name: "rules_list", Method: "GET", Path: "/rules"},
-		{Name: "rules_add", Method: "POST", Path: "/rules/add"},
-		{Name: "rules_delete", Method: "POST", Path: "/rules/delete"},
+	httpMetrics := metrics.NewHTTPMetrics(registry, clock.NewClock())
+	instrument(httpMetrics, rataHandlers)
+	instrument(httpMetrics, innerHandlers)
+
+	innerRoutes := rata.Routes{
 		{Name: "whitelists", Method: "GET", Path: "/whitelists"},
+		{Name: "whitelist_changes", Method: "GET", Path: "/whitelists/changes"},
+		{Name: "events", Method: "GET", Path: "/events"},
+		{Name: "tag_stats", Method: "GET", Path: "/tags/stats"},
+	}
+
+	// configs from before the inner listener keep serving the inner API beside the outer one
+	if conf.InnerListenAddress == "" {
+		if conf.InnerTLS.CertPath != "" || conf.InnerTLS.KeyPath != "" || conf.InnerTLS.CACertPath != "" {
+			logger.Error("inner-server", fmt.Errorf("inner_tls needs an inner_listen_address"))
+			os.Exit(1)
+		}
+		logger.Error("inner-server-not-configured", fmt.Errorf("serving the inner API on listen_address without client certificates: set inner_listen_address and inner_tls cert_path, key_path and ca_cert_path"))
+		routes = append(routes, innerRoutes...)
+		for name, handler := range innerHandlers {
+			rataHandlers[name] = handler
+		}
 	}
 
 	rataRouter, err := rata.NewRouter(routes, rataHandlers)
@@ -81,12 +295,44 @@ func main() {
 		logger.Fatal("create-rata-route", err)
 	}
 
-	httpServer := http_server.New(conf.ListenAddress, rataRouter)
+	outerMembers, err := newOuterServer(logger, conf.ListenAddress, conf.TLS, rataRouter)
+	if err != nil {
+		logger.Error("outer-server", err)
+		os.Exit(1)
+	}
+	members := outerMembers
+
+	if conf.InnerListenAddress != "" {
+		innerRouter, err := rata.NewRouter(innerRoutes, innerHandlers)
+		if err != nil {
+			logger.Fatal("create-inner-rata-route", err)
+		}
+
+		innerServer, err := newInnerServer(logger, conf.InnerListenAddress, conf.InnerTLS, innerRouter)
+		if err != nil {
+			logger.Error("inner-server", err)
+			os.Exit(1)
+		}
 
-	members := grouper.Members{
-		{"http_server", httpServer},
+		// start the inner server first
+		members = append(grouper.Members{{"inner_server", innerServer}}, members...)
 	}
 
+	if conf.EnablePprof && conf.DebugListenAddress == "" {
+		logger.Error("debug-server", fmt.Errorf("enable_pprof needs a debug_listen_address"))
+		os.Exit(1)
+	}
+	if conf.DebugListenAddress != "" {
+		debugServer, err := newDebugServer(logger, conf.DebugListenAddress, registry, conf.EnablePprof)
+		if err != nil {
+			logger.Fatal("create-debug-rata-route", err)
+		}
+		members = append(grouper.Members{{"debug_server", debugServer}}, members...)
+	}
+
+	// stopped first, so the server reports not ready while draining
+	members = append(members, grouper.Member{"readiness", readiness})
+
 	group := grouper.NewOrdered(os.Interrupt, members)
 
 	logger.Info("ifrit-invoke")
@@ -98,3 +344,203 @@ func main() {
 		logger.Fatal("ifrit-wait", err)
 	}
 }
+
+func instrument(httpMetrics *metrics.HTTPMetrics, routeHandlers rata.Handlers) {
+	for route, handler := range routeHandlers {
+		routeHandlers[route] = httpMetrics.Wrap(route, handler)
+	}
+}
+
+func newDebugServer(logger lager.Logger, address string, registry *metrics.Registry, enablePprof bool) (ifrit.Runner, error) {
+	debugRoutes := rata.Routes{
+		{Name: "metrics", Method: "GET", Path: "/metrics"},
+	}
+	debugHandlers := rata.Handlers{
+		"metrics": &handlers.Metrics{
+			Logger:   logger,
+			Registry: registry,
+		},
+	}
+
+	if enablePprof {
+		logger.Info("pprof-enabled")
+		// last, since this route matches the others
+		debugRoutes = append(debugRoutes,
+			rata.Route{Name: "pprof_cmdline", Method: "GET", Path: "/debug/pprof/cmdline"},
+			rata.Route{Name: "pprof_profile", Method: "GET", Path: "/debug/pprof/profile"},
+			rata.Route{Name: "pprof_symbol", Method: "GET", Path: "/debug/pprof/symbol"},
+			rata.Route{Name: "pprof_symbol_lookup", Method: "POST", Path: "/debug/pprof/symbol"},
+			rata.Route{Name: "pprof_trace", Method: "GET", Path: "/debug/pprof/trace"},
+			rata.Route{Name: "pprof_index", Method: "GET", Path: "/debug/pprof/"},
+			rata.Route{Name: "pprof_named", Method: "GET", Path: "/debug/pprof/:profile"},
+		)
+		debugHandlers["pprof_cmdline"] = http.HandlerFunc(pprof.Cmdline)
+		debugHandlers["pprof_profile"] = http.HandlerFunc(pprof.Profile)
+		debugHandlers["pprof_symbol"] = http.HandlerFunc(pprof.Symbol)
+		debugHandlers["pprof_symbol_lookup"] = http.HandlerFunc(pprof.Symbol)
+		debugHandlers["pprof_trace"] = http.HandlerFunc(pprof.Trace)
+		debugHandlers["pprof_index"] = http.HandlerFunc(pprof.Index)
+		debugHandlers["pprof_named"] = http.HandlerFunc(pprof.Index)
+	}
+
+	debugRouter, err := rata.NewRouter(debugRoutes, debugHandlers)
+	if err != nil {
+		return nil, err
+	}
+	return http_server.New(address, debugRouter), nil
+}
+
+func newAuditLog(logger lager.Logger, auditConfig config.AuditConfig) (audit.Log, error) {
+	if auditConfig.Disabled {
+		logger.Info("audit-disabled")
+		return audit.DiscardLog{}, nil
+	}
+
+	// configs from before auditing keep starting, but record nothing
+	if auditConfig.Type == "" {
+		logger.Error("audit-not-configured", fmt.Errorf("recording no audit log: set audit.path or audit.type, or audit.disabled to silence this"))
+		return audit.DiscardLog{}, nil
+	}
+
+	switch auditConfig.Type {
+	case config.AuditTypeFile:
+		if auditConfig.Path == "" {
+			return nil, fmt.Errorf("audit path is required unless auditing is disabled")
+		}
+		return audit.NewFileLog(auditConfig.Path, int64(auditConfig.MaxSizeMB)*1024*1024, auditConfig.MaxBackups)
+	case config.AuditTypeSyslog:
+		return audit.NewSyslogLog(auditConfig.SyslogNetwork, auditConfig.SyslogAddress, "policy-server-audit")
+	default:
+		return nil, fmt.Errorf("unknown audit type: %q", auditConfig.Type)
+	}
+}
+
+func newInnerServer(logger lager.Logger, address string, tlsConfig config.TLSConfig, handler http.Handler) (ifrit.Runner, error) {
+	if tlsConfig.Disabled {
+		logger.Info("inner-tls-disabled")
+		return http_server.New(address, handler), nil
+	}
+
+	if tlsConfig.CertPath == "" || tlsConfig.KeyPath == "" || tlsConfig.CACertPath == "" {
+		return nil, fmt.Errorf("inner_tls needs cert_path, key_path and ca_cert_path unless it is disabled")
+	}
+	serverTLSConfig, err := mutualtls.NewServerTLSConfig(tlsConfig.CertPath, tlsConfig.KeyPath, tlsConfig.CACertPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := tlsconfig.Restrict(serverTLSConfig, tlsConfig.MinVersion, tlsConfig.CipherSuites); err != nil {
+		return nil, fmt.Errorf("inner_tls: %s", err)
+	}
+	return http_server.NewTLSServer(address, handler, serverTLSConfig), nil
+}
+
+func newOuterServer(logger lager.Logger, address string, tlsConfig config.TLSConfig, handler http.Handler) (grouper.Members, error) {
+	if tlsConfig.CertPath == "" && tlsConfig.KeyPath == "" {
+		return grouper.Members{
+			{"http_server", http_server.New(address, handler)},
+		}, nil
+	}
+
+	reloader, err := tlsconfig.NewCertificateReloader(logger, tlsConfig.CertPath, tlsConfig.KeyPath, clock.NewClock())
+	if err != nil {
+		return nil, fmt.Errorf("tls: %s", err)
+	}
+	serverTLSConfig := &tls.Config{GetCertificate: reloader.GetCertificate}
+	if err := tlsconfig.Restrict(serverTLSConfig, tlsConfig.MinVersion, tlsConfig.CipherSuites); err != nil {
+		return nil, fmt.Errorf("tls: %s", err)
+	}
+	return grouper.Members{
+		{"certificate_reloader", reloader},
+		{"http_server", http_server.NewTLSServer(address, handler, serverTLSConfig)},
+	}, nil
+}
+
+type rulesAuthorizer interface {
+	Authorize(logger lager.Logger, user auth.User, rules []models.Rule) error
+	Visible(logger lager.Logger, user auth.User, rules []models.Rule) ([]models.Rule, error)
+	FirstVisible(logger lager.Logger, user auth.User, rules []models.Rule, n int) ([]models.Rule, error)
+	IsAdmin(user auth.User) bool
+	AuthorizeCreate(logger lager.Logger, user auth.User, group string) error
+	AuthorizeMembers(logger lager.Logger, user auth.User, group string, apps []string) error
+	AuthorizeLeave(logger lager.Logger, user auth.User, group, app string) error
+	VisibleGroups(logger lager.Logger, user auth.User, groups []models.Group) ([]models.Group, error)
+}
+
+func newAuth(logger lager.Logger, authConfig config.AuthConfig, groups store.GroupStore) (func(http.Handler) http.Handler, rulesAuthorizer, error) {
+	if authConfig.Disabled {
+		logger.Info("auth-disabled")
+		return func(handler http.Handler) http.Handler { return handler }, nil, nil
+	}
+
+	// configs from before auth keep starting, but anyone may change the rules
+	if len(authConfig.SigningKeyPaths) == 0 && authConfig.CloudControllerURL == "" {
+		logger.Error("auth-not-configured", fmt.Errorf("accepting requests without a token: set auth.signing_key_paths and auth.cloud_controller_url, or auth.disabled to silence this"))
+		return func(handler http.Handler) http.Handler { return handler }, nil, nil
+	}
+
+	if len(authConfig.SigningKeyPaths) == 0 {
+		return nil, nil, fmt.Errorf("signing_key_paths are required unless auth is disabled")
+	}
+	if authConfig.CloudControllerURL == "" {
+		return nil, nil, fmt.Errorf("cloud_controller_url is required unless auth is disabled")
+	}
+	keys, err := auth.ReadPublicKeys(authConfig.SigningKeyPaths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authenticator := &auth.Authenticator{
+		Logger: logger,
+		Validator: &auth.Validator{
+			Keys:     keys,
+			Audience: authConfig.Audience,
+			Clock:    clock.NewClock(),
+		},
+	}
+	authorizer := &auth.Authorizer{
+		CloudController: cc.NewClient(authConfig.CloudControllerURL, http.DefaultClient),
+		AdminScope:      authConfig.AdminScope,
+		Groups:          groups,
+	}
+	return authenticator.Wrap, authorizer, nil
+}
+
+func newStore(logger lager.Logger, storeConfig config.StoreConfig, tagBits int, tagQuarantine time.Duration,
+	publisher store.Publisher) (store.Store, store.GroupStore, store.Tagger, error) {
+	clock := clock.NewClock()
+
+	switch storeConfig.Type {
+	case config.StoreTypeMemory:
+		packetTagger, err := store.NewMemoryTagger(tagBits, tagQuarantine, clock)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("packet tag: %s", err)
+		}
+		memoryStore := store.NewMemoryStore(packetTagger)
+		memoryStore.Publisher = publisher
+		memoryStore.HistorySize = storeConfig.HistorySize
+		return memoryStore, store.NewMemoryGroupStore(memoryStore), packetTagger, nil
+	case config.StoreTypeSQL:
+		db, err := store.NewDatabase(storeConfig.DriverName, storeConfig.DataSourceName)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("database: %s", err)
+		}
+		sqlStore, err := store.NewSQLStore(db, tagBits, tagQuarantine, clock)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("packet tag: %s", err)
+		}
+		sqlStore.Publisher = publisher
+		sqlStore.HistorySize = storeConfig.HistorySize
+		return sqlStore, store.NewSQLGroupStore(sqlStore), sqlStore.Tagger, nil
+	case config.StoreTypeFile:
+		fileStore, err := store.NewFileStore(logger, storeConfig.Directory, storeConfig.SnapshotInterval,
+			tagBits, tagQuarantine, clock)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		fileStore.Publisher = publisher
+		fileStore.HistorySize = storeConfig.HistorySize
+		return fileStore, store.NewFileGroupStore(fileStore), fileStore.Tagger, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown store type: %q", storeConfig.Type)
+	}
+}
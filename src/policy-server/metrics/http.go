@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pivotal-golang/clock"
+)
+
+type HTTPMetrics struct {
+	Clock clock.Clock
+
+	requests  *Counter
+	durations *Histogram
+	sizes     *Histogram
+}
+
+func NewHTTPMetrics(registry *Registry, clock clock.Clock) *HTTPMetrics {
+	return &HTTPMetrics{
+		Clock: clock,
+		requests: registry.NewCounter("policy_server_http_requests_total",
+			"Requests served, by route and status code.", "route", "code"),
+		durations: registry.NewHistogram("policy_server_http_request_duration_seconds",
+			"Time taken to serve requests, by route.", DefaultDurationBuckets, "route"),
+		sizes: registry.NewHistogram("policy_server_http_response_size_bytes",
+			"Size of response bodies, by route.", DefaultSizeBuckets, "route"),
+	}
+}
+
+func (m *HTTPMetrics) Wrap(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		started := m.Clock.Now()
+		recorder := &responseRecorder{ResponseWriter: resp, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, req)
+
+		m.requests.Inc(route, strconv.Itoa(recorder.status))
+		m.durations.Observe(m.Clock.Since(started).Seconds(), route)
+		m.sizes.Observe(float64(recorder.size), route)
+	})
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.size += n
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
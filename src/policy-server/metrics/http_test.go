@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"policy-server/metrics"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+)
+
+var _ = Describe("HTTPMetrics", func() {
+	var (
+		registry    *metrics.Registry
+		fakeClock   *fakeclock.FakeClock
+		httpMetrics *metrics.HTTPMetrics
+	)
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		httpMetrics = metrics.NewHTTPMetrics(registry, fakeClock)
+	})
+
+	It("counts, times and sizes the requests to the route", func() {
+		handler := httpMetrics.Wrap("rules_add", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			fakeClock.Increment(300 * time.Millisecond)
+			resp.WriteHeader(http.StatusCreated)
+			resp.Write([]byte("some-rule"))
+		}))
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("POST", "/rules/add", nil))
+		Expect(resp.Code).To(Equal(http.StatusCreated))
+		Expect(resp.Body.String()).To(Equal("some-rule"))
+
+		payload, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(payload)).To(ContainSubstring(`policy_server_http_requests_total{route="rules_add",code="201"} 1`))
+		Expect(string(payload)).To(ContainSubstring(`policy_server_http_request_duration_seconds_bucket{route="rules_add",le="0.25"} 0`))
+		Expect(string(payload)).To(ContainSubstring(`policy_server_http_request_duration_seconds_bucket{route="rules_add",le="0.5"} 1`))
+		Expect(string(payload)).To(ContainSubstring(`policy_server_http_response_size_bytes_sum{route="rules_add"} 9`))
+	})
+
+	It("lets streaming handlers flush", func() {
+		handler := httpMetrics.Wrap("events", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			flusher, ok := resp.(http.Flusher)
+			Expect(ok).To(BeTrue())
+			resp.Write([]byte("event"))
+			flusher.Flush()
+		}))
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", "/events", nil))
+		Expect(resp.Flushed).To(BeTrue())
+
+		payload, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(payload)).To(ContainSubstring(`policy_server_http_requests_total{route="events",code="200"} 1`))
+	})
+})
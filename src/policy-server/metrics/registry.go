@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var DefaultSizeBuckets = ExponentialBuckets(256, 4, 9)
+
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+type Registry struct {
+	lock     sync.Mutex
+	families []family
+}
+
+type family interface {
+	write(buffer *bytes.Buffer) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.families = append(r.families, f)
+}
+
+func (r *Registry) Gather() ([]byte, error) {
+	r.lock.Lock()
+	families := make([]family, len(r.families))
+	copy(families, r.families)
+	r.lock.Unlock()
+
+	buffer := &bytes.Buffer{}
+	for _, f := range families {
+		if err := f.write(buffer); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+type Counter struct {
+	vector
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{newVector(name, help, "counter", labelNames)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.update(labelValues, func(s *series) {
+		s.sum += value
+	})
+}
+
+func (c *Counter) write(buffer *bytes.Buffer) error {
+	c.writeHeader(buffer)
+	for _, s := range c.sorted() {
+		writeSample(buffer, c.name, c.labelNames, s.labelValues, "", s.sum)
+	}
+	return nil
+}
+
+type Histogram struct {
+	vector
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{vector: newVector(name, help, "histogram", labelNames), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.update(labelValues, func(s *series) {
+		if s.buckets == nil {
+			s.buckets = make([]uint64, len(h.buckets))
+		}
+		for i, bound := range h.buckets {
+			if value <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.count++
+		s.sum += value
+	})
+}
+
+func (h *Histogram) write(buffer *bytes.Buffer) error {
+	h.writeHeader(buffer)
+	for _, s := range h.sorted() {
+		for i, bound := range h.buckets {
+			writeSample(buffer, h.name+"_bucket", h.labelNames, s.labelValues, formatValue(bound), float64(s.buckets[i]))
+		}
+		writeSample(buffer, h.name+"_bucket", h.labelNames, s.labelValues, "+Inf", float64(s.count))
+		writeSample(buffer, h.name+"_sum", h.labelNames, s.labelValues, "", s.sum)
+		writeSample(buffer, h.name+"_count", h.labelNames, s.labelValues, "", float64(s.count))
+	}
+	return nil
+}
+
+type funcMetric struct {
+	name, help, kind string
+	value            func() (float64, error)
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, value func() (float64, error)) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", value: value})
+}
+
+func (r *Registry) NewCounterFunc(name, help string, value func() (float64, error)) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", value: value})
+}
+
+func (m *funcMetric) write(buffer *bytes.Buffer) error {
+	value, err := m.value()
+	if err != nil {
+		return fmt.Errorf("%s: %s", m.name, err)
+	}
+	writeHeader(buffer, m.name, m.help, m.kind)
+	writeSample(buffer, m.name, nil, nil, "", value)
+	return nil
+}
+
+type vector struct {
+	name, help, kind string
+	labelNames       []string
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	sum         float64
+	count       uint64
+	buckets     []uint64
+}
+
+func newVector(name, help, kind string, labelNames []string) vector {
+	return vector{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+	}
+}
+
+func (v *vector) update(labelValues []string, change func(s *series)) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("%s: got %d label values for %d labels", v.name, len(labelValues), len(v.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	change(s)
+}
+
+func (v *vector) sorted() []series {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]series, len(keys))
+	for i, key := range keys {
+		s := *v.series[key]
+		s.buckets = append([]uint64{}, s.buckets...)
+		sorted[i] = s
+	}
+	return sorted
+}
+
+func (v *vector) writeHeader(buffer *bytes.Buffer) {
+	writeHeader(buffer, v.name, v.help, v.kind)
+}
+
+func writeHeader(buffer *bytes.Buffer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(buffer *bytes.Buffer, name string, labelNames, labelValues []string, le string, value float64) {
+	buffer.WriteString(name)
+
+	labels := []string{}
+	for i, labelName := range labelNames {
+		labels = append(labels, labelName+`="`+escapeLabelValue(labelValues[i])+`"`)
+	}
+	if le != "" {
+		labels = append(labels, `le="`+le+`"`)
+	}
+	if len(labels) > 0 {
+		buffer.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+
+	buffer.WriteString(" " + formatValue(value) + "\n")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
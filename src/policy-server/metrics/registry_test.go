@@ -0,0 +1,97 @@
+package metrics_test
+
+import (
+	"errors"
+	"policy-server/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *metrics.Registry
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+	})
+
+	gather := func() string {
+		payload, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		return string(payload)
+	}
+
+	It("writes counters for each combination of label values, in order", func() {
+		counter := registry.NewCounter("requests_total", "Requests served.", "route", "code")
+		counter.Inc("rules_list", "200")
+		counter.Add(2, "rules_add", "201")
+		counter.Inc("rules_list", "200")
+
+		Expect(gather()).To(Equal(`# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="rules_add",code="201"} 2
+requests_total{route="rules_list",code="200"} 2
+`))
+	})
+
+	It("writes histograms with cumulative buckets", func() {
+		histogram := registry.NewHistogram("duration_seconds", "Time taken.", []float64{0.1, 1}, "route")
+		histogram.Observe(0.05, "rules_list")
+		histogram.Observe(0.5, "rules_list")
+		histogram.Observe(5, "rules_list")
+
+		Expect(gather()).To(Equal(`# HELP duration_seconds Time taken.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="rules_list",le="0.1"} 1
+duration_seconds_bucket{route="rules_list",le="1"} 2
+duration_seconds_bucket{route="rules_list",le="+Inf"} 3
+duration_seconds_sum{route="rules_list"} 5.55
+duration_seconds_count{route="rules_list"} 3
+`))
+	})
+
+	It("reads function metrics when gathering", func() {
+		rules := 3
+		registry.NewGaugeFunc("rules", "Rules in the policy.", func() (float64, error) {
+			return float64(rules), nil
+		})
+		registry.NewCounterFunc("allocated_total", "Tags handed out.", func() (float64, error) {
+			return 7, nil
+		})
+
+		Expect(gather()).To(ContainSubstring("# TYPE rules gauge\nrules 3\n"))
+		rules = 4
+		Expect(gather()).To(ContainSubstring("rules 4\n"))
+		Expect(gather()).To(ContainSubstring("# TYPE allocated_total counter\nallocated_total 7\n"))
+	})
+
+	It("fails if a function metric cannot be read", func() {
+		registry.NewGaugeFunc("rules", "Rules in the policy.", func() (float64, error) {
+			return 0, errors.New("banana")
+		})
+
+		_, err := registry.Gather()
+		Expect(err).To(MatchError("rules: banana"))
+	})
+
+	It("escapes label values and help text", func() {
+		counter := registry.NewCounter("owners_total", "Owners\nby \\ name.", "owner")
+		counter.Inc("team \"a\"\n")
+
+		Expect(gather()).To(Equal(`# HELP owners_total Owners\nby \\ name.
+# TYPE owners_total counter
+owners_total{owner="team \"a\"\n"} 1
+`))
+	})
+
+	It("panics when given the wrong number of label values", func() {
+		counter := registry.NewCounter("requests_total", "Requests served.", "route")
+		Expect(func() { counter.Inc() }).To(Panic())
+	})
+
+	Describe("ExponentialBuckets", func() {
+		It("multiplies each bound by the factor", func() {
+			Expect(metrics.ExponentialBuckets(1, 4, 3)).To(Equal([]float64{1, 4, 16}))
+		})
+	})
+})
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"policy-server/models"
+	"policy-server/store"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+type TimedStore struct {
+	store.Store
+	Clock clock.Clock
+
+	durations *Histogram
+}
+
+func NewTimedStore(s store.Store, registry *Registry, clock clock.Clock) *TimedStore {
+	return &TimedStore{
+		Store: s,
+		Clock: clock,
+		durations: registry.NewHistogram("policy_server_store_operation_duration_seconds",
+			"Time taken by store operations, by operation.", DefaultDurationBuckets, "operation"),
+	}
+}
+
+func (s *TimedStore) observe(operation string, started time.Time) {
+	s.durations.Observe(s.Clock.Since(started).Seconds(), operation)
+}
+
+func (s *TimedStore) Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error) {
+	defer s.observe("add", s.Clock.Now())
+	return s.Store.Add(logger, actor, rule)
+}
+
+func (s *TimedStore) Delete(logger lager.Logger, actor string, rule models.Rule) error {
+	defer s.observe("delete", s.Clock.Now())
+	return s.Store.Delete(logger, actor, rule)
+}
+
+func (s *TimedStore) ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error) {
+	defer s.observe("apply-batch", s.Clock.Now())
+	return s.Store.ApplyBatch(logger, actor, batch)
+}
+
+func (s *TimedStore) Sync(logger lager.Logger, actor, owner string, rules []models.Rule, revision int64, dryRun bool) (models.BatchResult, error) {
+	defer s.observe("sync", s.Clock.Now())
+	return s.Store.Sync(logger, actor, owner, rules, revision, dryRun)
+}
+
+func (s *TimedStore) Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error) {
+	defer s.observe("rollback", s.Clock.Now())
+	return s.Store.Rollback(logger, actor, revision)
+}
+
+func (s *TimedStore) History(logger lager.Logger) ([]models.Change, error) {
+	defer s.observe("history", s.Clock.Now())
+	return s.Store.History(logger)
+}
+
+func (s *TimedStore) List(logger lager.Logger) ([]models.Rule, error) {
+	defer s.observe("list", s.Clock.Now())
+	return s.Store.List(logger)
+}
+
+func (s *TimedStore) GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error) {
+	defer s.observe("get-whitelists", s.Clock.Now())
+	return s.Store.GetWhitelists(logger, groups)
+}
+
+func (s *TimedStore) WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error) {
+	defer s.observe("whitelist-changes", s.Clock.Now())
+	return s.Store.WhitelistChanges(logger, since, groups)
+}
+
+func (s *TimedStore) Revision(logger lager.Logger) (int64, error) {
+	defer s.observe("revision", s.Clock.Now())
+	return s.Store.Revision(logger)
+}
+
+type lister interface {
+	List(logger lager.Logger) ([]models.Rule, error)
+}
+
+func RegisterRules(registry *Registry, logger lager.Logger, lister lister) {
+	registry.NewGaugeFunc("policy_server_rules", "Rules in the policy.", func() (float64, error) {
+		rules, err := lister.List(logger)
+		return float64(len(rules)), err
+	})
+	registry.NewGaugeFunc("policy_server_groups", "Groups that some rule mentions.", func() (float64, error) {
+		rules, err := lister.List(logger)
+		groups := map[string]bool{}
+		for _, rule := range rules {
+			groups[rule.Source] = true
+			groups[rule.Destination] = true
+		}
+		return float64(len(groups)), err
+	})
+}
+
+type tagger interface {
+	Stats() (models.TagStats, error)
+}
+
+func RegisterTags(registry *Registry, tagger tagger) {
+	stat := func(field func(models.TagStats) int64) func() (float64, error) {
+		return func() (float64, error) {
+			stats, err := tagger.Stats()
+			return float64(field(stats)), err
+		}
+	}
+
+	registry.NewGaugeFunc("policy_server_tags_in_use", "Packet tags assigned to groups.",
+		stat(func(s models.TagStats) int64 { return int64(s.InUse) }))
+	registry.NewGaugeFunc("policy_server_tags_quarantined", "Released packet tags that may not be reissued yet.",
+		stat(func(s models.TagStats) int64 { return int64(s.Quarantined) }))
+	registry.NewGaugeFunc("policy_server_tags_capacity", "Packet tags that fit in the configured tag bits.",
+		stat(func(s models.TagStats) int64 { return s.Capacity }))
+	registry.NewCounterFunc("policy_server_tags_allocated_total", "Packet tags handed out since the server started.",
+		stat(func(s models.TagStats) int64 { return int64(s.Allocated) }))
+	registry.NewCounterFunc("policy_server_tags_freed_total", "Packet tags released since the server started.",
+		stat(func(s models.TagStats) int64 { return int64(s.Freed) }))
+}
@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"policy-server/fakes"
+	"policy-server/metrics"
+	"policy-server/models"
+	"policy-server/store"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("store metrics", func() {
+	var (
+		registry    *metrics.Registry
+		fakeClock   *fakeclock.FakeClock
+		memoryStore *store.MemoryStore
+		tagger      *fakes.Tagger
+		logger      *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		tagger = &fakes.Tagger{}
+		tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+			return models.PT(groupID + "-tag"), nil
+		}
+		memoryStore = store.NewMemoryStore(tagger)
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	gather := func() string {
+		payload, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		return string(payload)
+	}
+
+	It("times each store operation", func() {
+		timedStore := metrics.NewTimedStore(memoryStore, registry, fakeClock)
+		Expect(added(timedStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		_, err := timedStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(gather()).To(ContainSubstring(`policy_server_store_operation_duration_seconds_count{operation="add"} 1`))
+		Expect(gather()).To(ContainSubstring(`policy_server_store_operation_duration_seconds_count{operation="list"} 1`))
+	})
+
+	It("counts the rules and the groups they mention", func() {
+		metrics.RegisterRules(registry, logger, memoryStore)
+		Expect(added(memoryStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(memoryStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+
+		Expect(gather()).To(ContainSubstring("policy_server_rules 2\n"))
+		Expect(gather()).To(ContainSubstring("policy_server_groups 3\n"))
+	})
+
+	It("reports the tag counts of the tagger", func() {
+		tagger.StatsStub = func() (models.TagStats, error) {
+			return models.TagStats{Allocated: 5, Freed: 2, InUse: 3, Quarantined: 1, Capacity: 255}, nil
+		}
+		metrics.RegisterTags(registry, tagger)
+
+		Expect(gather()).To(ContainSubstring("policy_server_tags_in_use 3\n"))
+		Expect(gather()).To(ContainSubstring("policy_server_tags_quarantined 1\n"))
+		Expect(gather()).To(ContainSubstring("policy_server_tags_capacity 255\n"))
+		Expect(gather()).To(ContainSubstring("policy_server_tags_allocated_total 5\n"))
+		Expect(gather()).To(ContainSubstring("policy_server_tags_freed_total 2\n"))
+	})
+})
+
+func added(_ models.Rule, _ bool, err error) error {
+	return err
+}
@@ -0,0 +1,34 @@
+package models
+
+import "fmt"
+
+// AnyRevision syncs whatever the revision is
+const AnyRevision int64 = -1
+
+type Batch struct {
+	Add    []Rule `json:"add"`
+	Delete []Rule `json:"delete"`
+}
+
+type BatchResult struct {
+	Revision int64  `json:"revision"`
+	Added    []Rule `json:"added"`
+	Deleted  []Rule `json:"deleted"`
+}
+
+func (b Batch) Validate() error {
+	for i, rule := range b.Add {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("add %d: %s", i, err)
+		}
+	}
+	for i, rule := range b.Delete {
+		if rule.ID != "" {
+			continue
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("delete %d: %s", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package models_test
+
+import (
+	"policy-server/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	Describe("Validate", func() {
+		It("accepts valid rules, and rules to delete given by ID alone", func() {
+			batch := models.Batch{
+				Add:    []models.Rule{{Source: "group0", Destination: "group1", Protocol: "tcp"}},
+				Delete: []models.Rule{{ID: "3"}, {Source: "group1", Destination: "group2"}},
+			}
+			Expect(batch.Validate()).To(Succeed())
+		})
+
+		It("says which rule is invalid", func() {
+			batch := models.Batch{
+				Add: []models.Rule{
+					{Source: "group0", Destination: "group1"},
+					{Source: "group0"},
+				},
+			}
+			Expect(batch.Validate()).To(MatchError("add 1: missing required field(s)"))
+
+			batch = models.Batch{
+				Delete: []models.Rule{{Source: "group0", Destination: "group1", Protocol: "sctp"}},
+			}
+			Expect(batch.Validate()).To(MatchError(`delete 0: invalid protocol "sctp"`))
+		})
+	})
+})
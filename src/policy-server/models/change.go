@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	ChangeAdd      = "add"
+	ChangeDelete   = "delete"
+	ChangeBatch    = "batch"
+	ChangeSync     = "sync"
+	ChangeRollback = "rollback"
+	ChangeGroup    = "group"
+)
+
+type Change struct {
+	Revision   int64     `json:"revision"`
+	Time       time.Time `json:"timestamp"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	RollbackTo *int64    `json:"rollback_to,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Added      []Rule    `json:"added"`
+	Deleted    []Rule    `json:"deleted"`
+}
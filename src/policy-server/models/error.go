@@ -0,0 +1,19 @@
+package models
+
+const (
+	ErrorInvalidRequest = "invalid_request"
+	ErrorUnauthorized   = "unauthorized"
+	ErrorForbidden      = "forbidden"
+	ErrorNotFound       = "not_found"
+	ErrorConflict       = "conflict"
+	ErrorTagsExhausted  = "tags_exhausted"
+	ErrorNotImplemented = "not_implemented"
+	ErrorResyncRequired = "resync_required"
+	ErrorInternal       = "internal_error"
+)
+
+type Error struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
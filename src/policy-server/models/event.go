@@ -0,0 +1,16 @@
+package models
+
+const (
+	EventRuleAdded   = "rule-added"
+	EventRuleDeleted = "rule-deleted"
+	EventTagAssigned = "tag-assigned"
+)
+
+type Event struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	Revision int64      `json:"revision"`
+	Rule     *Rule      `json:"rule,omitempty"`
+	Group    string     `json:"group,omitempty"`
+	Tag      *PacketTag `json:"tag,omitempty"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MaxGroupNameLength = 255
+
+type Group struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+func (g Group) Validate() error {
+	if g.Name == "" {
+		return errors.New("missing required field(s)")
+	}
+	if len(g.Name) > MaxGroupNameLength {
+		return fmt.Errorf("group name longer than %d characters", MaxGroupNameLength)
+	}
+	for _, c := range g.Name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return fmt.Errorf("invalid group name %q", g.Name)
+		}
+	}
+	return nil
+}
+
+type GroupMembers struct {
+	Members []string `json:"members"`
+}
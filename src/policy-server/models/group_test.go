@@ -0,0 +1,31 @@
+package models_test
+
+import (
+	"policy-server/models"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Group", func() {
+	Describe("Validate", func() {
+		It("accepts names made of letters, digits, dots, dashes and underscores", func() {
+			Expect(models.Group{Name: "frontend"}.Validate()).To(Succeed())
+			Expect(models.Group{Name: "team-a.web_1"}.Validate()).To(Succeed())
+		})
+
+		It("requires a name", func() {
+			Expect(models.Group{}.Validate()).To(MatchError("missing required field(s)"))
+		})
+
+		It("rejects other characters", func() {
+			Expect(models.Group{Name: "front end"}.Validate()).To(MatchError(`invalid group name "front end"`))
+			Expect(models.Group{Name: "a/b"}.Validate()).To(MatchError(`invalid group name "a/b"`))
+		})
+
+		It("rejects names longer than a group of a rule", func() {
+			Expect(models.Group{Name: strings.Repeat("a", 256)}.Validate()).To(MatchError("group name longer than 255 characters"))
+		})
+	})
+})
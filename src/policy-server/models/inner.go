@@ -41,12 +41,109 @@ func PT(s string) *PacketTag {
 	return &b
 }
 
+const RevisionHeader = "X-Policy-Revision"
+
 type TaggedGroup struct {
-	ID  string     `json:"id"`
-	Tag *PacketTag `json:"tag"`
+	ID    string     `json:"id"`
+	Tag   *PacketTag `json:"tag"`
+	Group string     `json:"group,omitempty"`
+}
+
+type AllowedSource struct {
+	ID        string     `json:"id"`
+	Tag       *PacketTag `json:"tag"`
+	Protocol  string     `json:"protocol,omitempty"`
+	StartPort int        `json:"start_port,omitempty"`
+	EndPort   int        `json:"end_port,omitempty"`
 }
 
 type IngressWhitelist struct {
-	Destination    TaggedGroup   `json:"destination"`
-	AllowedSources []TaggedGroup `json:"allowed_sources"`
+	Destination    TaggedGroup     `json:"destination"`
+	Members        []string        `json:"members,omitempty"`
+	AllowedSources []AllowedSource `json:"allowed_sources"`
+}
+
+type WhitelistChanges struct {
+	Since    int64             `json:"since"`
+	Revision int64             `json:"revision"`
+	Changes  []WhitelistChange `json:"changes"`
+}
+
+type WhitelistChange struct {
+	Destination TaggedGroup     `json:"destination"`
+	Added       []AllowedSource `json:"added"`
+	Removed     []AllowedSource `json:"removed"`
+}
+
+func (w IngressWhitelist) Apply(change WhitelistChange) IngressWhitelist {
+	dropped := map[AllowedSource]bool{}
+	for _, sources := range [][]AllowedSource{change.Removed, change.Added} {
+		for _, source := range sources {
+			dropped[source.untagged()] = true
+		}
+	}
+
+	changed := IngressWhitelist{Destination: change.Destination, Members: w.Members, AllowedSources: []AllowedSource{}}
+	for _, source := range w.AllowedSources {
+		if !dropped[source.untagged()] {
+			changed.AllowedSources = append(changed.AllowedSources, source)
+		}
+	}
+	changed.AllowedSources = append(changed.AllowedSources, change.Added...)
+	return changed
+}
+
+func (s AllowedSource) untagged() AllowedSource {
+	s.Tag = nil
+	return s
+}
+
+type CompactWhitelists struct {
+	Groups     map[string]string          `json:"groups"`
+	Whitelists map[string][]CompactSource `json:"whitelists"`
+}
+
+type CompactSource struct {
+	Tag       string `json:"tag"`
+	Protocol  string `json:"protocol,omitempty"`
+	StartPort int    `json:"start_port,omitempty"`
+	EndPort   int    `json:"end_port,omitempty"`
+}
+
+func Compact(whitelists []IngressWhitelist) CompactWhitelists {
+	compact := CompactWhitelists{
+		Groups:     map[string]string{},
+		Whitelists: map[string][]CompactSource{},
+	}
+	for _, whitelist := range whitelists {
+		if whitelist.Destination.Tag == nil {
+			continue
+		}
+		destination := whitelist.Destination.Tag.String()
+		compact.Groups[destination] = whitelist.Destination.ID
+
+		sources := []CompactSource{}
+		for _, source := range whitelist.AllowedSources {
+			if source.Tag == nil {
+				continue
+			}
+			compact.Groups[source.Tag.String()] = source.ID
+			sources = append(sources, CompactSource{
+				Tag:       source.Tag.String(),
+				Protocol:  source.Protocol,
+				StartPort: source.StartPort,
+				EndPort:   source.EndPort,
+			})
+		}
+		compact.Whitelists[destination] = sources
+	}
+	return compact
+}
+
+type TagStats struct {
+	Allocated   int   `json:"allocated"`
+	Freed       int   `json:"freed"`
+	InUse       int   `json:"in_use"`
+	Quarantined int   `json:"quarantined"`
+	Capacity    int64 `json:"capacity"`
 }
@@ -0,0 +1,92 @@
+package models_test
+
+import (
+	"policy-server/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compact", func() {
+	It("names each group once and gives whitelists by tag", func() {
+		compact := models.Compact([]models.IngressWhitelist{
+			{
+				Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("\x01")},
+				AllowedSources: []models.AllowedSource{
+					{ID: "group0", Tag: models.PT("\x00")},
+					{ID: "group2", Tag: models.PT("\x02"), Protocol: "tcp", StartPort: 80, EndPort: 80},
+				},
+			},
+			{
+				Destination:    models.TaggedGroup{ID: "group0", Tag: models.PT("\x00")},
+				AllowedSources: []models.AllowedSource{{ID: "group1", Tag: models.PT("\x01")}},
+			},
+		})
+
+		Expect(compact).To(Equal(models.CompactWhitelists{
+			Groups: map[string]string{"00": "group0", "01": "group1", "02": "group2"},
+			Whitelists: map[string][]models.CompactSource{
+				"01": {{Tag: "00"}, {Tag: "02", Protocol: "tcp", StartPort: 80, EndPort: 80}},
+				"00": {{Tag: "01"}},
+			},
+		}))
+	})
+
+	It("leaves out groups without a tag", func() {
+		compact := models.Compact([]models.IngressWhitelist{
+			{Destination: models.TaggedGroup{ID: "unknown"}},
+			{Destination: models.TaggedGroup{ID: "group0", Tag: models.PT("\x00")}},
+		})
+
+		Expect(compact).To(Equal(models.CompactWhitelists{
+			Groups:     map[string]string{"00": "group0"},
+			Whitelists: map[string][]models.CompactSource{"00": {}},
+		}))
+	})
+})
+
+var _ = Describe("IngressWhitelist", func() {
+	Describe("Apply", func() {
+		whitelist := models.IngressWhitelist{
+			Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("\x01")},
+			AllowedSources: []models.AllowedSource{
+				{ID: "group0", Tag: models.PT("\x00")},
+				{ID: "group2", Tag: models.PT("\x02"), Protocol: "tcp", StartPort: 80, EndPort: 80},
+			},
+		}
+
+		It("takes out the removed sources, whatever their tag, and puts in the added ones", func() {
+			changed := whitelist.Apply(models.WhitelistChange{
+				Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("\x01")},
+				Added:       []models.AllowedSource{{ID: "group3", Tag: models.PT("\x03")}},
+				Removed:     []models.AllowedSource{{ID: "group2", Protocol: "tcp", StartPort: 80, EndPort: 80}},
+			})
+
+			Expect(changed.AllowedSources).To(Equal([]models.AllowedSource{
+				{ID: "group0", Tag: models.PT("\x00")},
+				{ID: "group3", Tag: models.PT("\x03")},
+			}))
+		})
+
+		It("replaces sources that are added again with a new tag", func() {
+			changed := whitelist.Apply(models.WhitelistChange{
+				Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("\x04")},
+				Added:       []models.AllowedSource{{ID: "group0", Tag: models.PT("\x05")}},
+				Removed:     []models.AllowedSource{{ID: "group0"}},
+			})
+
+			Expect(changed).To(Equal(models.IngressWhitelist{
+				Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("\x04")},
+				AllowedSources: []models.AllowedSource{
+					{ID: "group2", Tag: models.PT("\x02"), Protocol: "tcp", StartPort: 80, EndPort: 80},
+					{ID: "group0", Tag: models.PT("\x05")},
+				},
+			}))
+		})
+
+		It("leaves the whitelist it was called on alone", func() {
+			whitelist.Apply(models.WhitelistChange{Removed: whitelist.AllowedSources})
+			Expect(whitelist.AllowedSources).To(HaveLen(2))
+		})
+	})
+})
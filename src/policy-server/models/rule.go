@@ -1,15 +1,35 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolICMP = "icmp"
+
+	MaxPort = 65535
+)
 
 type Rule struct {
+	ID          string `json:"id,omitempty"`
 	Source      string `json:"group1"`
 	Destination string `json:"group2"`
+	Protocol    string `json:"protocol,omitempty"`
+	StartPort   int    `json:"start_port,omitempty"`
+	EndPort     int    `json:"end_port,omitempty"`
+	Owner       string `json:"owner,omitempty"`
 }
 
 func (r Rule) Equals(otherRule Rule) bool {
-	return r.Source == otherRule.Source &&
-		r.Destination == otherRule.Destination
+	return r.Owner == otherRule.Owner &&
+		r.Source == otherRule.Source &&
+		r.Destination == otherRule.Destination &&
+		r.Protocol == otherRule.Protocol &&
+		r.StartPort == otherRule.StartPort &&
+		r.EndPort == otherRule.EndPort
 }
 
 func (r Rule) Validate() error {
@@ -17,5 +37,45 @@ func (r Rule) Validate() error {
 	if !ok {
 		return errors.New("missing required field(s)")
 	}
+
+	hasPorts := r.StartPort != 0 || r.EndPort != 0
+	switch r.Protocol {
+	case "", ProtocolICMP:
+		if hasPorts {
+			return errors.New("ports are only allowed with tcp or udp")
+		}
+	case ProtocolTCP, ProtocolUDP:
+		if !hasPorts {
+			break
+		}
+		if r.StartPort < 1 || r.EndPort > MaxPort || r.StartPort > r.EndPort {
+			return fmt.Errorf("invalid port range %d-%d", r.StartPort, r.EndPort)
+		}
+	default:
+		return fmt.Errorf("invalid protocol %q", r.Protocol)
+	}
 	return nil
 }
+
+type RuleFilter struct {
+	Source      string
+	Destination string
+	Group       string
+	Owner       string
+}
+
+func (f RuleFilter) Matches(rule Rule) bool {
+	if f.Source != "" && f.Source != rule.Source {
+		return false
+	}
+	if f.Destination != "" && f.Destination != rule.Destination {
+		return false
+	}
+	if f.Group != "" && f.Group != rule.Source && f.Group != rule.Destination {
+		return false
+	}
+	if f.Owner != "" && f.Owner != rule.Owner {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package models_test
+
+import (
+	"policy-server/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rule", func() {
+	Describe("Validate", func() {
+		valid := func(rule models.Rule) {
+			rule.Source = "group0"
+			rule.Destination = "group1"
+			Expect(rule.Validate()).To(Succeed())
+		}
+
+		invalid := func(rule models.Rule, message string) {
+			rule.Source = "group0"
+			rule.Destination = "group1"
+			Expect(rule.Validate()).To(MatchError(message))
+		}
+
+		It("requires both groups", func() {
+			Expect(models.Rule{Source: "group0"}.Validate()).To(MatchError("missing required field(s)"))
+			Expect(models.Rule{Destination: "group1"}.Validate()).To(MatchError("missing required field(s)"))
+		})
+
+		It("allows all traffic when there is no protocol", func() {
+			valid(models.Rule{})
+		})
+
+		It("accepts tcp and udp with or without ports", func() {
+			valid(models.Rule{Protocol: "tcp"})
+			valid(models.Rule{Protocol: "udp", StartPort: 53, EndPort: 53})
+			valid(models.Rule{Protocol: "tcp", StartPort: 1, EndPort: 65535})
+		})
+
+		It("accepts icmp without ports", func() {
+			valid(models.Rule{Protocol: "icmp"})
+			invalid(models.Rule{Protocol: "icmp", StartPort: 1, EndPort: 1}, "ports are only allowed with tcp or udp")
+		})
+
+		It("rejects ports without a protocol", func() {
+			invalid(models.Rule{StartPort: 80, EndPort: 80}, "ports are only allowed with tcp or udp")
+		})
+
+		It("rejects unknown protocols", func() {
+			invalid(models.Rule{Protocol: "sctp"}, `invalid protocol "sctp"`)
+		})
+
+		It("rejects ports out of range or out of order", func() {
+			invalid(models.Rule{Protocol: "tcp", StartPort: 0, EndPort: 80}, "invalid port range 0-80")
+			invalid(models.Rule{Protocol: "tcp", StartPort: 80, EndPort: 65536}, "invalid port range 80-65536")
+			invalid(models.Rule{Protocol: "tcp", StartPort: 90, EndPort: 80}, "invalid port range 90-80")
+			invalid(models.Rule{Protocol: "tcp", StartPort: 80}, "invalid port range 80-0")
+		})
+	})
+
+	Describe("Equals", func() {
+		It("compares the whole tuple", func() {
+			rule := models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp", StartPort: 80, EndPort: 80}
+			Expect(rule.Equals(rule)).To(BeTrue())
+
+			other := rule
+			other.EndPort = 81
+			Expect(rule.Equals(other)).To(BeFalse())
+
+			other = rule
+			other.Protocol = "udp"
+			Expect(rule.Equals(other)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("RuleFilter", func() {
+	rule := models.Rule{Source: "group0", Destination: "group1", Owner: "pipeline"}
+
+	It("matches everything when it is empty", func() {
+		Expect(models.RuleFilter{}.Matches(rule)).To(BeTrue())
+	})
+
+	It("matches every field that is set", func() {
+		Expect(models.RuleFilter{Source: "group0", Destination: "group1", Owner: "pipeline"}.Matches(rule)).To(BeTrue())
+		Expect(models.RuleFilter{Source: "group1"}.Matches(rule)).To(BeFalse())
+		Expect(models.RuleFilter{Destination: "group0"}.Matches(rule)).To(BeFalse())
+		Expect(models.RuleFilter{Source: "group0", Owner: "someone-else"}.Matches(rule)).To(BeFalse())
+	})
+
+	It("matches a group on either side", func() {
+		Expect(models.RuleFilter{Group: "group0"}.Matches(rule)).To(BeTrue())
+		Expect(models.RuleFilter{Group: "group1"}.Matches(rule)).To(BeTrue())
+		Expect(models.RuleFilter{Group: "group2"}.Matches(rule)).To(BeFalse())
+	})
+})
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"policy-server/models"
+	"strconv"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type batchPlan struct {
+	rules   []models.Rule
+	added   []models.Rule
+	deleted []models.Rule
+}
+
+func (p batchPlan) empty() bool {
+	return len(p.added) == 0 && len(p.deleted) == 0
+}
+
+func planBatch(rules []models.Rule, batch models.Batch) (batchPlan, error) {
+	plan := batchPlan{
+		rules:   make([]models.Rule, len(rules)),
+		added:   []models.Rule{},
+		deleted: []models.Rule{},
+	}
+	copy(plan.rules, rules)
+
+	for _, request := range batch.Delete {
+		var removed []models.Rule
+		plan.rules, removed = removeMatching(plan.rules, request)
+		if len(removed) == 0 {
+			return batchPlan{}, NotFoundError{Rule: request}
+		}
+		plan.deleted = append(plan.deleted, removed...)
+	}
+
+	// rules are equal if they are the same but for their IDs
+	existing := make(map[models.Rule]bool, len(plan.rules))
+	for _, rule := range plan.rules {
+		rule.ID = ""
+		existing[rule] = true
+	}
+	for _, rule := range batch.Add {
+		rule.ID = ""
+		if existing[rule] {
+			continue
+		}
+		existing[rule] = true
+		plan.rules = append(plan.rules, rule)
+		plan.added = append(plan.added, rule)
+	}
+	return plan, nil
+}
+
+func (p batchPlan) preview(revision int64) models.BatchResult {
+	added := make([]models.Rule, len(p.added))
+	for i, rule := range p.added {
+		rule.ID = ""
+		added[i] = rule
+	}
+	return models.BatchResult{Revision: revision, Added: added, Deleted: p.deleted}
+}
+
+func syncBatch(rules []models.Rule, owner string, desired []models.Rule) models.Batch {
+	owned := make([]models.Rule, len(desired))
+	for i, rule := range desired {
+		rule.ID = ""
+		rule.Owner = owner
+		owned[i] = rule
+	}
+
+	current := []models.Rule{}
+	for _, rule := range rules {
+		if rule.Owner == owner {
+			current = append(current, rule)
+		}
+	}
+	return diffBatch(current, owned)
+}
+
+func diffBatch(current, desired []models.Rule) models.Batch {
+	batch := models.Batch{Add: []models.Rule{}, Delete: []models.Rule{}}
+	for _, rule := range current {
+		if _, ok := findRule(desired, rule); !ok {
+			batch.Delete = append(batch.Delete, models.Rule{ID: rule.ID})
+		}
+	}
+	for _, rule := range desired {
+		if _, ok := findRule(current, rule); !ok {
+			rule.ID = ""
+			batch.Add = append(batch.Add, rule)
+		}
+	}
+	return batch
+}
+
+func findRule(rules []models.Rule, rule models.Rule) (models.Rule, bool) {
+	for _, r := range rules {
+		if rule.Equals(r) {
+			return r, true
+		}
+	}
+	return models.Rule{}, false
+}
+
+func (p batchPlan) deletedGroups() []string {
+	groups := []string{}
+	for _, rule := range p.deleted {
+		groups = append(groups, rule.Source, rule.Destination)
+	}
+	return groups
+}
+
+func tagGroups(logger lager.Logger, tagger Tagger, rules []models.Rule) (map[string]*models.PacketTag, []string, error) {
+	tags := map[string]*models.PacketTag{}
+	tagged := []string{}
+	for _, rule := range rules {
+		for _, group := range []string{rule.Source, rule.Destination} {
+			if _, ok := tags[group]; ok {
+				continue
+			}
+			tag, err := tagger.GetTag(group)
+			if err != nil {
+				logger.Error("get-tag", err, lager.Data{"group": group})
+				return nil, tagged, err
+			}
+			tags[group] = tag
+			tagged = append(tagged, group)
+		}
+	}
+	return tags, tagged, nil
+}
+
+func batchEvents(revision int64, plan batchPlan, newTags map[string]*models.PacketTag) []models.Event {
+	events := []models.Event{}
+	for _, rule := range plan.deleted {
+		events = append(events, ruleDeletedEvent(revision, rule))
+	}
+	for _, rule := range plan.added {
+		events = append(events, ruleAddedEvents(revision, rule, newTags)...)
+	}
+	return events
+}
+
+func (s *MemoryStore) ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error) {
+	logger = logger.Session("memory-store-apply-batch")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.applyBatch(logger, s.newChange(actor, models.ChangeBatch), batch, false)
+}
+
+func (s *MemoryStore) Sync(logger lager.Logger, actor, owner string, rules []models.Rule, revision int64, dryRun bool) (models.BatchResult, error) {
+	logger = logger.Session("memory-store-sync", lager.Data{"owner": owner, "dry-run": dryRun})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if revision != models.AnyRevision && revision != s.revision {
+		return models.BatchResult{}, RevisionChangedError{Expected: revision, Current: s.revision}
+	}
+
+	return s.applyBatch(logger, s.newChange(actor, models.ChangeSync), syncBatch(s.rules, owner, rules), dryRun)
+}
+
+func (s *MemoryStore) Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error) {
+	logger = logger.Session("memory-store-rollback", lager.Data{"revision": revision})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	target, err := rulesAt(s.rules, s.revision, s.history, revision)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+
+	change := s.newChange(actor, models.ChangeRollback)
+	change.RollbackTo = &revision
+	return s.applyBatch(logger, change, diffBatch(s.rules, target), false)
+}
+
+// caller must hold the lock
+func (s *MemoryStore) applyBatch(logger lager.Logger, change models.Change, batch models.Batch, dryRun bool) (models.BatchResult, error) {
+	plan, err := s.planBatch(batch)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	if dryRun || plan.empty() {
+		return plan.preview(s.revision), nil
+	}
+
+	tags, tagged, err := tagGroups(logger, s.Tagger, plan.added)
+	if err != nil {
+		s.releaseUnreferenced(logger, s.index(), tagged...)
+		return models.BatchResult{}, err
+	}
+
+	return s.commitBatch(logger, change, plan, tags), nil
+}
+
+// caller must hold the lock
+func (s *MemoryStore) planBatch(batch models.Batch) (batchPlan, error) {
+	plan, err := planBatch(s.rules, batch)
+	if err != nil {
+		return batchPlan{}, err
+	}
+
+	// the new rules are at the end of the plan, in order
+	lastID := s.lastID
+	offset := len(plan.rules) - len(plan.added)
+	for i := range plan.added {
+		lastID++
+		plan.added[i].ID = strconv.Itoa(lastID)
+		plan.rules[offset+i].ID = plan.added[i].ID
+	}
+	return plan, nil
+}
+
+// caller must hold the lock
+func (s *MemoryStore) commitBatch(logger lager.Logger, change models.Change, plan batchPlan, tags map[string]*models.PacketTag) models.BatchResult {
+	s.rules = plan.rules
+	next := s.index().update(s.rules, plan.added, plan.deleted)
+	for _, rule := range plan.added {
+		s.trackID(rule.ID)
+	}
+	s.releaseUnreferenced(logger, next, plan.deletedGroups()...)
+
+	newTags := map[string]*models.PacketTag{}
+	for group, tag := range tags {
+		if _, ok := s.tags[group]; !ok {
+			newTags[group] = tag
+		}
+		s.tags[group] = tag
+	}
+
+	s.revision++
+	change.Added, change.Deleted = plan.added, plan.deleted
+	s.record(change)
+	s.publish(next)
+	s.notify()
+	publish(s.Publisher, batchEvents(s.revision, plan, newTags)...)
+	logger.Info("applied", lager.Data{"added": plan.added, "deleted": plan.deleted, "revision": s.revision})
+
+	return models.BatchResult{Revision: s.revision, Added: plan.added, Deleted: plan.deleted}
+}
@@ -0,0 +1,28 @@
+package store
+
+import "sync"
+
+type changeNotifier struct {
+	changed chan struct{}
+	lock    sync.Mutex
+}
+
+func (n *changeNotifier) Changes() <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.changed == nil {
+		n.changed = make(chan struct{})
+	}
+	return n.changed
+}
+
+func (n *changeNotifier) notify() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.changed != nil {
+		close(n.changed)
+		n.changed = nil
+	}
+}
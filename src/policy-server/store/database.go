@@ -0,0 +1,247 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type migration struct {
+	Version    int
+	Statements map[string][]string
+}
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Statements: map[string][]string{
+			"sqlite3": {
+				`CREATE TABLE rules (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					source VARCHAR(255) NOT NULL,
+					destination VARCHAR(255) NOT NULL,
+					protocol VARCHAR(16) NOT NULL DEFAULT '',
+					start_port INTEGER NOT NULL DEFAULT 0,
+					end_port INTEGER NOT NULL DEFAULT 0,
+					owner VARCHAR(255) NOT NULL DEFAULT ''
+				)`,
+				`CREATE UNIQUE INDEX rules_unique ON rules (source, destination, protocol, start_port, end_port, owner)`,
+				`CREATE TABLE packet_tags (
+					group_id VARCHAR(255) PRIMARY KEY,
+					tag_value INTEGER NOT NULL UNIQUE,
+					released_at BIGINT
+				)`,
+				`CREATE TABLE policy_revision (revision BIGINT NOT NULL)`,
+				`INSERT INTO policy_revision (revision) VALUES (0)`,
+				`CREATE TABLE policy_history (
+					revision BIGINT PRIMARY KEY,
+					created_at BIGINT NOT NULL,
+					actor VARCHAR(255) NOT NULL,
+					action VARCHAR(16) NOT NULL,
+					rollback_to BIGINT,
+					added TEXT NOT NULL,
+					deleted TEXT NOT NULL,
+					group_name VARCHAR(255) NOT NULL DEFAULT ''
+				)`,
+				`CREATE TABLE policy_groups (
+					name VARCHAR(255) PRIMARY KEY,
+					revision BIGINT NOT NULL
+				)`,
+				`CREATE TABLE group_members (
+					member VARCHAR(255) PRIMARY KEY,
+					group_name VARCHAR(255) NOT NULL,
+					revision BIGINT NOT NULL
+				)`,
+			},
+			"postgres": {
+				`CREATE TABLE rules (
+					id SERIAL PRIMARY KEY,
+					source VARCHAR(255) NOT NULL,
+					destination VARCHAR(255) NOT NULL,
+					protocol VARCHAR(16) NOT NULL DEFAULT '',
+					start_port INTEGER NOT NULL DEFAULT 0,
+					end_port INTEGER NOT NULL DEFAULT 0,
+					owner VARCHAR(255) NOT NULL DEFAULT ''
+				)`,
+				`CREATE UNIQUE INDEX rules_unique ON rules (source, destination, protocol, start_port, end_port, owner)`,
+				`CREATE TABLE packet_tags (
+					group_id VARCHAR(255) PRIMARY KEY,
+					tag_value BIGINT NOT NULL UNIQUE,
+					released_at BIGINT
+				)`,
+				`CREATE TABLE policy_revision (revision BIGINT NOT NULL)`,
+				`INSERT INTO policy_revision (revision) VALUES (0)`,
+				`CREATE TABLE policy_history (
+					revision BIGINT PRIMARY KEY,
+					created_at BIGINT NOT NULL,
+					actor VARCHAR(255) NOT NULL,
+					action VARCHAR(16) NOT NULL,
+					rollback_to BIGINT,
+					added TEXT NOT NULL,
+					deleted TEXT NOT NULL,
+					group_name VARCHAR(255) NOT NULL DEFAULT ''
+				)`,
+				`CREATE TABLE policy_groups (
+					name VARCHAR(255) PRIMARY KEY,
+					revision BIGINT NOT NULL
+				)`,
+				`CREATE TABLE group_members (
+					member VARCHAR(255) PRIMARY KEY,
+					group_name VARCHAR(255) NOT NULL,
+					revision BIGINT NOT NULL
+				)`,
+			},
+		},
+	},
+}
+
+type Database struct {
+	conn       *sql.DB
+	driverName string
+}
+
+func NewDatabase(driverName, dataSourceName string) (*Database, error) {
+	if _, ok := migrations[0].Statements[driverName]; !ok {
+		return nil, fmt.Errorf("unsupported database driver: %q", driverName)
+	}
+
+	conn, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("open: %s", err)
+	}
+
+	if driverName == "sqlite3" {
+		// sqlite only supports a single writer
+		conn.SetMaxOpenConns(1)
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ping: %s", err)
+	}
+
+	db := &Database{
+		conn:       conn,
+		driverName: driverName,
+	}
+
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("migrate: %s", err)
+	}
+
+	return db, nil
+}
+
+func (d *Database) insertReturningID(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	if d.driverName == "postgres" {
+		var id int64
+		err := tx.QueryRow(d.rebind(query+` RETURNING id`), args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := tx.Exec(d.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (d *Database) Close() error {
+	return d.conn.Close()
+}
+
+func (d *Database) migrate() error {
+	_, err := d.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %s", err)
+	}
+
+	var current int
+	err = d.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("read schema version: %s", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := d.conn.Begin()
+		if err != nil {
+			return fmt.Errorf("begin: %s", err)
+		}
+
+		for _, statement := range m.Statements[d.driverName] {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %s", m.Version, err)
+			}
+		}
+
+		_, err = tx.Exec(d.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.Version)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: record version: %s", m.Version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: commit: %s", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// SQLite has no row locks, but only allows a single connection
+func (d *Database) forUpdate(query string) string {
+	if d.driverName == "postgres" {
+		return query + ` FOR UPDATE`
+	}
+	return query
+}
+
+func (d *Database) beginSnapshot() (*sql.Tx, error) {
+	if d.driverName == "postgres" {
+		return d.conn.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	}
+	// the single connection to SQLite is held until the transaction ends
+	return d.conn.Begin()
+}
+
+func inList(values []string) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		placeholders[i] = "?"
+		args[i] = value
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args
+}
+
+func (d *Database) rebind(query string) string {
+	if d.driverName != "postgres" {
+		return query
+	}
+
+	var rebound []byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			rebound = append(rebound, query[i])
+			continue
+		}
+		n++
+		rebound = append(rebound, '$')
+		rebound = strconv.AppendInt(rebound, int64(n), 10)
+	}
+	return string(rebound)
+}
+
+func isUniqueViolation(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unique") || strings.Contains(msg, "duplicate key")
+}
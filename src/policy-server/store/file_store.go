@@ -0,0 +1,677 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"policy-server/models"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	DefaultSnapshotInterval = 1000
+
+	walFileName      = "rules.wal"
+	snapshotFileName = "rules.snapshot"
+
+	opAdd    = "add"
+	opDelete = "delete"
+	opBatch  = "batch"
+
+	// creating a group makes no revision, so its record keeps the sequence
+	opCreateGroup = "create-group"
+)
+
+type fileStoreRecord struct {
+	Sequence uint64                       `json:"seq"`
+	Op       string                       `json:"op"`
+	Rule     models.Rule                  `json:"rule"`
+	Tags     map[string]*models.PacketTag `json:"tags,omitempty"`
+	Released []releasedTag                `json:"released,omitempty"`
+
+	// a batch lists every rule it added and deleted, with their IDs
+	Added   []models.Rule `json:"added,omitempty"`
+	Deleted []models.Rule `json:"deleted,omitempty"`
+
+	Actor      string    `json:"actor,omitempty"`
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action,omitempty"`
+	RollbackTo *int64    `json:"rollback_to,omitempty"`
+	Group      string    `json:"group,omitempty"`
+
+	Memberships map[string]Membership `json:"memberships,omitempty"`
+}
+
+type fileStoreSnapshot struct {
+	Sequence uint64                       `json:"seq"`
+	Rules    []models.Rule                `json:"rules"`
+	Tags     map[string]*models.PacketTag `json:"tags"`
+	Released []releasedTag                `json:"released"`
+
+	// LastRuleID keeps the IDs of deleted rules from being reissued.
+	LastRuleID int `json:"last_rule_id,omitempty"`
+
+	History     []models.Change       `json:"history,omitempty"`
+	Memberships map[string]Membership `json:"memberships,omitempty"`
+}
+
+func (snap *fileStoreSnapshot) apply(record fileStoreRecord) error {
+	change := models.Change{
+		Revision:   int64(record.Sequence),
+		Time:       record.Time,
+		Actor:      record.Actor,
+		RollbackTo: record.RollbackTo,
+		Group:      record.Group,
+	}
+
+	mergeMemberships(snap.Memberships, record.Memberships)
+
+	switch record.Op {
+	case opCreateGroup:
+		return nil
+	case opAdd:
+		change.Action = models.ChangeAdd
+		change.Added = []models.Rule{record.Rule}
+		snap.Rules = append(snap.Rules, record.Rule)
+		snap.LastRuleID = laterID(snap.LastRuleID, record.Rule.ID)
+		for groupID, tag := range record.Tags {
+			snap.Tags[groupID] = tag
+			snap.unrelease(groupID, tag)
+		}
+	case opDelete:
+		change.Action = models.ChangeDelete
+		snap.Rules, change.Deleted = removeMatching(snap.Rules, record.Rule)
+		snap.release(record.Released)
+	case opBatch:
+		change.Action = models.ChangeBatch
+		if record.Action != "" {
+			change.Action = record.Action
+		}
+		change.Added, change.Deleted = record.Added, record.Deleted
+		for _, rule := range record.Deleted {
+			snap.Rules, _ = removeMatching(snap.Rules, models.Rule{ID: rule.ID})
+		}
+		snap.release(record.Released)
+		for _, rule := range record.Added {
+			snap.Rules = append(snap.Rules, rule)
+			snap.LastRuleID = laterID(snap.LastRuleID, rule.ID)
+		}
+		for groupID, tag := range record.Tags {
+			snap.Tags[groupID] = tag
+			snap.unrelease(groupID, tag)
+		}
+	default:
+		return fmt.Errorf("unknown op %q in record %d", record.Op, record.Sequence)
+	}
+	snap.History = append(snap.History, change)
+	snap.Sequence = record.Sequence
+	return nil
+}
+
+func (snap *fileStoreSnapshot) release(released []releasedTag) {
+	for _, r := range released {
+		delete(snap.Tags, r.GroupID)
+		snap.Released = append(snap.Released, r)
+	}
+}
+
+func (snap *fileStoreSnapshot) unrelease(groupID string, tag *models.PacketTag) {
+	released := []releasedTag{}
+	for _, r := range snap.Released {
+		if r.GroupID != groupID && r.Tag.String() != tag.String() {
+			released = append(released, r)
+		}
+	}
+	snap.Released = released
+}
+
+type FileStore struct {
+	*MemoryStore
+
+	tagger           *memoryTagger
+	clock            clock.Clock
+	dir              string
+	snapshotInterval int
+	log              *writeAheadLog
+	groups           map[string]Membership
+	sequence         uint64
+	sinceSnapshot    int
+	writeLock        sync.Mutex
+}
+
+func NewFileStore(logger lager.Logger, dir string, snapshotInterval int,
+	tagBits int, tagQuarantine time.Duration, clock clock.Clock) (*FileStore, error) {
+	logger = logger.Session("file-store-recover", lager.Data{"dir": dir})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	if snapshotInterval < 1 {
+		snapshotInterval = DefaultSnapshotInterval
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create data dir: %s", err)
+	}
+
+	state, err := readSnapshot(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %s", err)
+	}
+	snapshotSequence := state.Sequence
+
+	log, payloads, discarded, err := openWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		return nil, fmt.Errorf("open log: %s", err)
+	}
+	if discarded > 0 {
+		logger.Info("discarded-torn-record", lager.Data{"bytes": discarded})
+	}
+
+	replayed := 0
+	for _, payload := range payloads {
+		var record fileStoreRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			log.Close()
+			return nil, fmt.Errorf("decode log record: %s", err)
+		}
+		if record.Sequence < snapshotSequence || (record.Sequence == snapshotSequence && record.Op != opCreateGroup) {
+			// already captured by the snapshot, but creating a group again changes nothing
+			continue
+		}
+		if err := state.apply(record); err != nil {
+			log.Close()
+			return nil, fmt.Errorf("replay log: %s", err)
+		}
+		replayed++
+	}
+
+	tagger, err := restoreMemoryTagger(tagBits, tagQuarantine, clock, state.Tags, state.Released)
+	if err != nil {
+		log.Close()
+		return nil, fmt.Errorf("restore tagger: %s", err)
+	}
+
+	memoryStore := NewMemoryStore(tagger)
+	memoryStore.Clock = clock
+	memoryStore.rules = state.Rules
+	memoryStore.revision = int64(state.Sequence)
+	memoryStore.history = trimHistory(state.History, memoryStore.HistorySize)
+	memoryStore.lastID = state.LastRuleID
+	for _, rule := range state.Rules {
+		memoryStore.trackID(rule.ID)
+	}
+	legacyRules := 0
+	for i := range memoryStore.rules {
+		if memoryStore.rules[i].ID == "" {
+			memoryStore.rules[i].ID = memoryStore.nextID()
+			memoryStore.trackID(memoryStore.rules[i].ID)
+			legacyRules++
+		}
+	}
+	for groupID, tag := range state.Tags {
+		memoryStore.tags[groupID] = tag
+	}
+	memoryStore.publish(newIndex().update(memoryStore.rules, memoryStore.rules, nil))
+
+	logger.Info("recovered", lager.Data{
+		"snapshot-seq":     snapshotSequence,
+		"replayed-records": replayed,
+		"rules":            len(state.Rules),
+		"tags":             len(state.Tags),
+		"released-tags":    len(state.Released),
+	})
+
+	fileStore := &FileStore{
+		MemoryStore:      memoryStore,
+		tagger:           tagger,
+		clock:            clock,
+		dir:              dir,
+		snapshotInterval: snapshotInterval,
+		log:              log,
+		groups:           state.Memberships,
+		sequence:         state.Sequence,
+		sinceSnapshot:    len(payloads),
+	}
+
+	if legacyRules > 0 {
+		// keep the IDs given to rules logged before rules had IDs
+		if err := fileStore.snapshot(); err != nil {
+			log.Close()
+			return nil, fmt.Errorf("snapshot rule ids: %s", err)
+		}
+		logger.Info("assigned-rule-ids", lager.Data{"rules": legacyRules})
+	}
+
+	return fileStore, nil
+}
+
+func readSnapshot(path string) (*fileStoreSnapshot, error) {
+	state := &fileStoreSnapshot{
+		Rules:       []models.Rule{},
+		Tags:        make(map[string]*models.PacketTag),
+		Released:    []releasedTag{},
+		Memberships: map[string]Membership{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("decode: %s", err)
+	}
+	return state, nil
+}
+
+func (s *FileStore) Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error) {
+	logger = logger.Session("file-store-add")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	// only writers change the rules, so the ID holds until the add
+	s.MemoryStore.lock.Lock()
+	existing, found := findRule(s.MemoryStore.index().group(rule.Destination).to, rule)
+	rule.ID = s.MemoryStore.nextID()
+	s.MemoryStore.lock.Unlock()
+	if found {
+		logger.Info("already-exists", lager.Data{"rule": existing})
+		return existing, false, nil
+	}
+
+	g1Tag, err := s.Tagger.GetTag(rule.Source)
+	if err != nil {
+		logger.Error("get-tag", err, lager.Data{"group": rule.Source})
+		return models.Rule{}, false, err
+	}
+
+	g2Tag, err := s.Tagger.GetTag(rule.Destination)
+	if err != nil {
+		logger.Error("get-tag", err, lager.Data{"group": rule.Destination})
+		s.MemoryStore.lock.Lock()
+		s.MemoryStore.releaseUnreferenced(logger, s.MemoryStore.index(), rule.Source)
+		s.MemoryStore.lock.Unlock()
+		return models.Rule{}, false, err
+	}
+
+	change := s.MemoryStore.newChange(actor, models.ChangeAdd)
+	err = s.append(fileStoreRecord{
+		Op:   opAdd,
+		Rule: rule,
+		Tags: map[string]*models.PacketTag{
+			rule.Source:      g1Tag,
+			rule.Destination: g2Tag,
+		},
+		Actor: change.Actor,
+		Time:  change.Time,
+	})
+	if err != nil {
+		logger.Error("append", err)
+		s.MemoryStore.lock.Lock()
+		s.MemoryStore.releaseUnreferenced(logger, s.MemoryStore.index(), rule.Source, rule.Destination)
+		s.MemoryStore.lock.Unlock()
+		return models.Rule{}, false, fmt.Errorf("append to log: %s", err)
+	}
+
+	s.MemoryStore.lock.Lock()
+	err = s.MemoryStore.add(logger, change, rule)
+	s.MemoryStore.lock.Unlock()
+	if err != nil {
+		return models.Rule{}, false, err
+	}
+
+	s.maybeSnapshot(logger)
+	return rule, true, nil
+}
+
+func (s *FileStore) Delete(logger lager.Logger, actor string, rule models.Rule) error {
+	logger = logger.Session("file-store-delete")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	released, found := s.releasedByDelete(rule)
+	if !found {
+		return NotFoundError{Rule: rule}
+	}
+
+	change := s.MemoryStore.newChange(actor, models.ChangeDelete)
+	err := s.append(fileStoreRecord{
+		Op:       opDelete,
+		Rule:     rule,
+		Released: released,
+		Actor:    change.Actor,
+		Time:     change.Time,
+	})
+	if err != nil {
+		logger.Error("append", err)
+		return fmt.Errorf("append to log: %s", err)
+	}
+
+	s.MemoryStore.lock.Lock()
+	err = s.MemoryStore.remove(logger, change, rule)
+	s.MemoryStore.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.maybeSnapshot(logger)
+	return nil
+}
+
+func (s *FileStore) releasedByDelete(rule models.Rule) ([]releasedTag, bool) {
+	s.MemoryStore.lock.Lock()
+	defer s.MemoryStore.lock.Unlock()
+
+	remaining, deleted := removeMatching(s.MemoryStore.rules, rule)
+	if len(deleted) == 0 {
+		return nil, false
+	}
+	return s.released(remaining, deleted[0].Source, deleted[0].Destination), true
+}
+
+// caller must hold the memory store lock
+func (s *FileStore) released(remaining []models.Rule, groups ...string) []releasedTag {
+	released := []releasedTag{}
+	now := s.clock.Now()
+	for _, group := range unreferencedGroups(remaining, groups...) {
+		released = append(released, releasedTag{
+			GroupID:    group,
+			Tag:        s.MemoryStore.tags[group],
+			ReleasedAt: now,
+		})
+	}
+	return released
+}
+
+func (s *FileStore) ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error) {
+	logger = logger.Session("file-store-apply-batch")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.applyBatch(logger, s.MemoryStore.newChange(actor, models.ChangeBatch), batch, false)
+}
+
+func (s *FileStore) Sync(logger lager.Logger, actor, owner string, rules []models.Rule, revision int64, dryRun bool) (models.BatchResult, error) {
+	logger = logger.Session("file-store-sync", lager.Data{"owner": owner, "dry-run": dryRun})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.MemoryStore.lock.Lock()
+	current := s.MemoryStore.revision
+	batch := syncBatch(s.MemoryStore.rules, owner, rules)
+	s.MemoryStore.lock.Unlock()
+	if revision != models.AnyRevision && revision != current {
+		return models.BatchResult{}, RevisionChangedError{Expected: revision, Current: current}
+	}
+
+	return s.applyBatch(logger, s.MemoryStore.newChange(actor, models.ChangeSync), batch, dryRun)
+}
+
+func (s *FileStore) Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error) {
+	logger = logger.Session("file-store-rollback", lager.Data{"revision": revision})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.MemoryStore.lock.Lock()
+	target, err := rulesAt(s.MemoryStore.rules, s.MemoryStore.revision, s.MemoryStore.history, revision)
+	batch := diffBatch(s.MemoryStore.rules, target)
+	s.MemoryStore.lock.Unlock()
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+
+	change := s.MemoryStore.newChange(actor, models.ChangeRollback)
+	change.RollbackTo = &revision
+	return s.applyBatch(logger, change, batch, false)
+}
+
+func (s *FileStore) applyBatch(logger lager.Logger, change models.Change, batch models.Batch, dryRun bool) (models.BatchResult, error) {
+	// only writers change the rules, so the plan holds until the commit
+	s.MemoryStore.lock.Lock()
+	plan, err := s.MemoryStore.planBatch(batch)
+	revision := s.MemoryStore.revision
+	s.MemoryStore.lock.Unlock()
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	if dryRun || plan.empty() {
+		return plan.preview(revision), nil
+	}
+
+	tags, tagged, err := tagGroups(logger, s.Tagger, plan.added)
+	if err != nil {
+		s.MemoryStore.lock.Lock()
+		s.MemoryStore.releaseUnreferenced(logger, s.MemoryStore.index(), tagged...)
+		s.MemoryStore.lock.Unlock()
+		return models.BatchResult{}, err
+	}
+
+	s.MemoryStore.lock.Lock()
+	released := s.released(plan.rules, plan.deletedGroups()...)
+	s.MemoryStore.lock.Unlock()
+
+	err = s.append(fileStoreRecord{
+		Op:       opBatch,
+		Tags:     tags,
+		Released: released,
+		Added:    plan.added,
+		Deleted:  plan.deleted,
+
+		Actor:      change.Actor,
+		Time:       change.Time,
+		Action:     change.Action,
+		RollbackTo: change.RollbackTo,
+	})
+	if err != nil {
+		logger.Error("append", err)
+		s.MemoryStore.lock.Lock()
+		s.MemoryStore.releaseUnreferenced(logger, s.MemoryStore.index(), tagged...)
+		s.MemoryStore.lock.Unlock()
+		return models.BatchResult{}, fmt.Errorf("append to log: %s", err)
+	}
+
+	s.MemoryStore.lock.Lock()
+	result := s.MemoryStore.commitBatch(logger, change, plan, tags)
+	s.MemoryStore.lock.Unlock()
+
+	s.maybeSnapshot(logger)
+	return result, nil
+}
+
+func (s *FileStore) memberships() map[string]Membership {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	names := make(map[string]Membership, len(s.groups))
+	mergeMemberships(names, s.groups)
+	return names
+}
+
+func (s *FileStore) createGroup(logger lager.Logger, name string, changed map[string]Membership, commit func(map[string]Membership)) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if err := s.MemoryStore.unreferenced(name); err != nil {
+		return err
+	}
+
+	err := s.append(fileStoreRecord{
+		Op:          opCreateGroup,
+		Time:        s.clock.Now(),
+		Group:       name,
+		Memberships: changed,
+	})
+	if err != nil {
+		logger.Error("append", err)
+		return fmt.Errorf("append to log: %s", err)
+	}
+	mergeMemberships(s.groups, changed)
+	commit(changed)
+
+	s.maybeSnapshot(logger)
+	return nil
+}
+
+func (s *FileStore) changeGroup(logger lager.Logger, change models.Change, joined []string, changed map[string]Membership, commit func(map[string]Membership)) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if err := s.MemoryStore.unreferenced(joined...); err != nil {
+		return err
+	}
+
+	stampMemberships(changed, int64(s.sequence+1))
+	err := s.append(fileStoreRecord{
+		Op:          opBatch,
+		Actor:       change.Actor,
+		Time:        change.Time,
+		Action:      change.Action,
+		Group:       change.Group,
+		Memberships: changed,
+	})
+	if err != nil {
+		logger.Error("append", err)
+		return fmt.Errorf("append to log: %s", err)
+	}
+	mergeMemberships(s.groups, changed)
+	commit(changed)
+
+	s.MemoryStore.lock.Lock()
+	s.MemoryStore.commitGroupChange(logger, change)
+	s.MemoryStore.lock.Unlock()
+
+	s.maybeSnapshot(logger)
+	return nil
+}
+
+func (s *FileStore) append(record fileStoreRecord) error {
+	record.Sequence = s.sequence + 1
+	if record.Op == opCreateGroup {
+		record.Sequence = s.sequence
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode: %s", err)
+	}
+
+	if err := s.log.Append(payload); err != nil {
+		return err
+	}
+
+	s.sequence = record.Sequence
+	s.sinceSnapshot++
+	return nil
+}
+
+func (s *FileStore) maybeSnapshot(logger lager.Logger) {
+	if s.sinceSnapshot < s.snapshotInterval {
+		return
+	}
+
+	// the log still holds every record
+	if err := s.snapshot(); err != nil {
+		logger.Error("snapshot", err)
+		return
+	}
+	logger.Info("snapshot", lager.Data{"seq": s.sequence})
+}
+
+func (s *FileStore) snapshot() error {
+	s.MemoryStore.lock.Lock()
+	state := fileStoreSnapshot{
+		Sequence: s.sequence,
+		Rules:    make([]models.Rule, len(s.MemoryStore.rules)),
+		Tags:     make(map[string]*models.PacketTag, len(s.MemoryStore.tags)),
+	}
+	copy(state.Rules, s.MemoryStore.rules)
+	state.LastRuleID = s.MemoryStore.lastID
+	state.History = make([]models.Change, len(s.MemoryStore.history))
+	copy(state.History, s.MemoryStore.history)
+	for groupID, tag := range s.MemoryStore.tags {
+		state.Tags[groupID] = tag
+	}
+	state.Released = s.tagger.releasedTags()
+	s.MemoryStore.lock.Unlock()
+	state.Memberships = s.groups
+
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode: %s", err)
+	}
+
+	if err := writeFileAtomic(s.dir, snapshotFileName, contents); err != nil {
+		return err
+	}
+
+	if err := s.log.Reset(); err != nil {
+		return fmt.Errorf("reset log: %s", err)
+	}
+	s.sinceSnapshot = 0
+	return nil
+}
+
+func writeFileAtomic(dir, name string, contents []byte) error {
+	tmp, err := ioutil.TempFile(dir, name)
+	if err != nil {
+		return fmt.Errorf("create temp file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %s", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("rename: %s", err)
+	}
+
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir: %s", err)
+	}
+	defer dirFile.Close()
+	if err := dirFile.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %s", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s.log.Close()
+}
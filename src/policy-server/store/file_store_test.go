@@ -0,0 +1,408 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"policy-server/models"
+	"policy-server/store"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("FileStore", func() {
+	var (
+		dataDir          string
+		walPath          string
+		snapshotInterval int
+		fileStore        *store.FileStore
+		fakeClock        *fakeclock.FakeClock
+		logger           *lagertest.TestLogger
+	)
+
+	open := func() {
+		var err error
+		fileStore, err = store.NewFileStore(logger, dataDir, snapshotInterval, 32, quarantine, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	reopen := func() {
+		Expect(fileStore.Close()).To(Succeed())
+		open()
+	}
+
+	listRules := func() []models.Rule {
+		rules, err := fileStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		return rules
+	}
+
+	getTags := func(groups ...string) []*models.PacketTag {
+		whitelists, err := fileStore.GetWhitelists(logger, groups)
+		Expect(err).NotTo(HaveOccurred())
+		tags := []*models.PacketTag{}
+		for _, w := range whitelists {
+			tags = append(tags, w.Destination.Tag)
+		}
+		return tags
+	}
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "file-store")
+		Expect(err).NotTo(HaveOccurred())
+		walPath = filepath.Join(dataDir, "rules.wal")
+		snapshotInterval = 100
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	JustBeforeEach(func() {
+		open()
+	})
+
+	AfterEach(func() {
+		Expect(fileStore.Close()).To(Succeed())
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("recovers rules and packet tags after a restart", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		Expect(fileStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+		tagsBefore := getTags("group0", "group1", "group2")
+
+		reopen()
+
+		Expect(listRules()).To(Equal([]models.Rule{
+			{ID: "2", Source: "group1", Destination: "group2"},
+		}))
+		Expect(getTags("group0", "group1", "group2")).To(Equal(tagsBefore))
+	})
+
+	It("recovers the revision after a restart", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+
+		reopen()
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+
+		Expect(fileStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+		Expect(fileStore.Revision(logger)).To(Equal(int64(3)))
+	})
+
+	It("keeps rule IDs and does not reissue them after a restart", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		Expect(fileStore.Delete(logger, "", models.Rule{ID: "2"})).To(Succeed())
+
+		reopen()
+
+		rule, created, err := fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeFalse())
+		Expect(rule.ID).To(Equal("1"))
+
+		rule, created, err = fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeTrue())
+		Expect(rule.ID).To(Equal("3"))
+	})
+
+	It("recovers batches after a restart", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		_, err := fileStore.ApplyBatch(logger, "", models.Batch{
+			Add: []models.Rule{
+				{Source: "group1", Destination: "group2"},
+				{Source: "group2", Destination: "group3"},
+			},
+			Delete: []models.Rule{{ID: "1"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+		tagsBefore := getTags("group0", "group1", "group2", "group3")
+		Expect(tagsBefore[0]).To(BeNil())
+
+		reopen()
+
+		Expect(listRules()).To(Equal([]models.Rule{
+			{ID: "2", Source: "group1", Destination: "group2"},
+			{ID: "3", Source: "group2", Destination: "group3"},
+		}))
+		Expect(getTags("group0", "group1", "group2", "group3")).To(Equal(tagsBefore))
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+
+		rule, _, err := fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group3"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rule.ID).To(Equal("4"))
+	})
+
+	It("does not reissue recovered tags to new groups", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		tagsBefore := getTags("group0", "group1")
+
+		reopen()
+
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group1"}))).To(Succeed())
+		newTag := getTags("group2")[0]
+		Expect(newTag).NotTo(BeNil())
+		Expect(tagsBefore).NotTo(ContainElement(newTag))
+	})
+
+	Describe("released tags", func() {
+		var releasedTag *models.PacketTag
+
+		JustBeforeEach(func() {
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			releasedTag = getTags("group0")[0]
+			Expect(fileStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+			Expect(getTags("group0")[0]).To(BeNil())
+			reopen()
+		})
+
+		It("keeps them in quarantine after a restart", func() {
+			fakeClock.Increment(quarantine - time.Second)
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+			Expect(getTags("group2", "group3")).NotTo(ContainElement(releasedTag))
+
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group3"}))).To(Succeed())
+			Expect(getTags("group0")[0]).To(Equal(releasedTag))
+		})
+
+		Context("when they were captured in a snapshot", func() {
+			BeforeEach(func() {
+				snapshotInterval = 2
+			})
+
+			It("keeps them in quarantine after a restart", func() {
+				Expect(filepath.Join(dataDir, "rules.snapshot")).To(BeARegularFile())
+
+				fakeClock.Increment(quarantine - time.Second)
+				Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+				Expect(getTags("group2", "group3")).NotTo(ContainElement(releasedTag))
+			})
+		})
+
+		It("reuses them once quarantine is over", func() {
+			fakeClock.Increment(quarantine)
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group2"}))).To(Succeed())
+			Expect(getTags("group2")[0]).To(Equal(releasedTag))
+
+			reopen()
+			Expect(getTags("group2")[0]).To(Equal(releasedTag))
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group0"}))).To(Succeed())
+			Expect(getTags("group0")[0]).NotTo(Equal(releasedTag))
+		})
+	})
+
+	Describe("history", func() {
+		var (
+			changedAt  time.Time
+			tagsBefore []*models.PacketTag
+		)
+
+		JustBeforeEach(func() {
+			changedAt = fakeClock.Now()
+			Expect(added(fileStore.Add(logger, "alice", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			tagsBefore = getTags("group0", "group1")
+			_, err := fileStore.Sync(logger, "bob", "team-a", []models.Rule{{Source: "group1", Destination: "group2"}}, models.AnyRevision, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileStore.Delete(logger, "carol", models.Rule{ID: "1"})).To(Succeed())
+		})
+
+		expectHistory := func() {
+			history, err := fileStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(3))
+			for i, actor := range []string{"alice", "bob", "carol"} {
+				Expect(history[i].Revision).To(Equal(int64(i + 1)))
+				Expect(history[i].Actor).To(Equal(actor))
+				Expect(history[i].Time).To(BeTemporally("==", changedAt))
+			}
+			Expect(history[1].Action).To(Equal(models.ChangeSync))
+			Expect(history[1].Added).To(Equal([]models.Rule{{ID: "2", Source: "group1", Destination: "group2", Owner: "team-a"}}))
+			Expect(history[2].Deleted).To(Equal([]models.Rule{{ID: "1", Source: "group0", Destination: "group1"}}))
+		}
+
+		It("recovers the history after a restart", func() {
+			reopen()
+			expectHistory()
+		})
+
+		Context("when it was captured in a snapshot", func() {
+			BeforeEach(func() {
+				snapshotInterval = 2
+			})
+
+			It("recovers the history after a restart", func() {
+				Expect(filepath.Join(dataDir, "rules.snapshot")).To(BeARegularFile())
+				reopen()
+				expectHistory()
+			})
+		})
+
+		It("rolls back to a revision, giving groups their old tags", func() {
+			Expect(getTags("group0")[0]).To(BeNil())
+
+			result, err := fileStore.Rollback(logger, "dave", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(int64(4)))
+			Expect(result.Added).To(Equal([]models.Rule{{ID: "3", Source: "group0", Destination: "group1"}}))
+			Expect(result.Deleted).To(Equal([]models.Rule{{ID: "2", Source: "group1", Destination: "group2", Owner: "team-a"}}))
+			Expect(getTags("group0", "group1")).To(Equal(tagsBefore))
+
+			reopen()
+
+			Expect(listRules()).To(Equal([]models.Rule{{ID: "3", Source: "group0", Destination: "group1"}}))
+			history, err := fileStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history[3].Action).To(Equal(models.ChangeRollback))
+			Expect(history[3].Actor).To(Equal("dave"))
+			Expect(*history[3].RollbackTo).To(Equal(int64(1)))
+		})
+	})
+
+	It("does not log deletes of unknown rules", func() {
+		err := fileStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+		Expect(err).To(MatchError("not found"))
+
+		info, err := os.Stat(walPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(BeZero())
+	})
+
+	Context("when the log reaches the snapshot interval", func() {
+		BeforeEach(func() {
+			snapshotInterval = 2
+		})
+
+		It("compacts the log into a snapshot", func() {
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+
+			info, err := os.Stat(walPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(BeZero())
+			Expect(filepath.Join(dataDir, "rules.snapshot")).To(BeARegularFile())
+
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+			tagsBefore := getTags("group0", "group1", "group2", "group3")
+
+			reopen()
+
+			Expect(listRules()).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+				{ID: "2", Source: "group1", Destination: "group2"},
+				{ID: "3", Source: "group2", Destination: "group3"},
+			}))
+			Expect(getTags("group0", "group1", "group2", "group3")).To(Equal(tagsBefore))
+		})
+
+		It("does not reissue the IDs of rules deleted before the snapshot", func() {
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(fileStore.Delete(logger, "", models.Rule{ID: "2"})).To(Succeed())
+			Expect(fileStore.Delete(logger, "", models.Rule{ID: "1"})).To(Succeed())
+			Expect(filepath.Join(dataDir, "rules.snapshot")).To(BeARegularFile())
+
+			reopen()
+
+			rule, _, err := fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rule.ID).To(Equal("3"))
+		})
+
+		It("skips log records already captured by the snapshot", func() {
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			staleLog, err := ioutil.ReadFile(walPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(fileStore.Close()).To(Succeed())
+
+			By("simulating a crash after the snapshot was written but before the log was reset")
+			Expect(ioutil.WriteFile(walPath, staleLog, 0600)).To(Succeed())
+			open()
+
+			Expect(listRules()).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+				{ID: "2", Source: "group1", Destination: "group2"},
+			}))
+		})
+	})
+
+	Describe("crash recovery", func() {
+		var sizeAfterFirstRecord int64
+
+		JustBeforeEach(func() {
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			info, err := os.Stat(walPath)
+			Expect(err).NotTo(HaveOccurred())
+			sizeAfterFirstRecord = info.Size()
+
+			Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(fileStore.Close()).To(Succeed())
+		})
+
+		truncateLog := func(size int64) {
+			Expect(os.Truncate(walPath, size)).To(Succeed())
+			open()
+		}
+
+		itRecoversTheCompleteRecords := func() {
+			It("recovers every complete record", func() {
+				Expect(listRules()).To(Equal([]models.Rule{
+					{ID: "1", Source: "group0", Destination: "group1"},
+				}))
+			})
+
+			It("appends new records after the last complete one", func() {
+				Expect(added(fileStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+				reopen()
+
+				Expect(listRules()).To(Equal([]models.Rule{
+					{ID: "1", Source: "group0", Destination: "group1"},
+					{ID: "2", Source: "group2", Destination: "group3"},
+				}))
+				tags := getTags("group0", "group1", "group2", "group3")
+				Expect(tags).NotTo(ContainElement(BeNil()))
+			})
+		}
+
+		Context("when the log is truncated mid-payload", func() {
+			JustBeforeEach(func() {
+				info, err := os.Stat(walPath)
+				Expect(err).NotTo(HaveOccurred())
+				truncateLog(info.Size() - 3)
+			})
+
+			itRecoversTheCompleteRecords()
+		})
+
+		Context("when the log is truncated mid-header", func() {
+			JustBeforeEach(func() {
+				truncateLog(sizeAfterFirstRecord + 5)
+			})
+
+			itRecoversTheCompleteRecords()
+		})
+
+		Context("when the last record is corrupt", func() {
+			JustBeforeEach(func() {
+				f, err := os.OpenFile(walPath, os.O_RDWR, 0600)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = f.WriteAt([]byte("garbage"), sizeAfterFirstRecord+10)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+				open()
+			})
+
+			itRecoversTheCompleteRecords()
+		})
+	})
+})
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"policy-server/models"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type whitelistRules interface {
+	GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error)
+	WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error)
+	History(logger lager.Logger) ([]models.Change, error)
+	Revision(logger lager.Logger) (int64, error)
+	Changes() <-chan struct{}
+}
+
+type GroupedWhitelists struct {
+	Rules  whitelistRules
+	Groups GroupStore
+}
+
+func (w *GroupedWhitelists) Revision(logger lager.Logger) (int64, error) {
+	return w.Rules.Revision(logger)
+}
+
+func (w *GroupedWhitelists) Changes() <-chan struct{} {
+	return w.Rules.Changes()
+}
+
+func (w *GroupedWhitelists) GetWhitelists(logger lager.Logger, names []string) ([]models.IngressWhitelist, error) {
+	memberships, err := w.Groups.Memberships(logger, nil)
+	if err != nil {
+		logger.Error("memberships", err)
+		return nil, err
+	}
+
+	fetched := map[string]models.IngressWhitelist{}
+	if len(names) == 0 {
+		all, err := w.Rules.GetWhitelists(logger, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, whitelist := range all {
+			if memberships[whitelist.Destination.ID].Group == "" {
+				names = append(names, whitelist.Destination.ID)
+				fetched[whitelist.Destination.ID] = whitelist
+			}
+		}
+	}
+
+	groups := []string{}
+	for _, name := range names {
+		groups = append(groups, groupFor(name, memberships))
+	}
+	if err := w.fetch(logger, fetched, groups); err != nil {
+		return nil, err
+	}
+
+	// members are tagged as their groups
+	groups = []string{}
+	for _, group := range fetched {
+		for _, source := range group.AllowedSources {
+			if g := memberships[source.ID].Group; g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if err := w.fetch(logger, fetched, groups); err != nil {
+		return nil, err
+	}
+
+	all := make([]models.IngressWhitelist, len(names))
+	for i, name := range names {
+		group := groupFor(name, memberships)
+		whitelist := fetched[group]
+		all[i] = models.IngressWhitelist{
+			Destination:    models.TaggedGroup{ID: name, Tag: whitelist.Destination.Tag},
+			Members:        copyMembers(memberships[group].Members),
+			AllowedSources: memberSources(whitelist.AllowedSources, memberships, fetched),
+		}
+		if group != name {
+			all[i].Destination.Group = group
+		}
+	}
+	return all, nil
+}
+
+func (w *GroupedWhitelists) fetch(logger lager.Logger, fetched map[string]models.IngressWhitelist, groups []string) error {
+	missing := []string{}
+	for _, group := range groups {
+		if _, ok := fetched[group]; !ok {
+			fetched[group] = models.IngressWhitelist{}
+			missing = append(missing, group)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	whitelists, err := w.Rules.GetWhitelists(logger, missing)
+	if err != nil {
+		return err
+	}
+	for i, group := range missing {
+		fetched[group] = whitelists[i]
+	}
+	return nil
+}
+
+func (w *GroupedWhitelists) WhitelistChanges(logger lager.Logger, since int64, names []string) (models.WhitelistChanges, error) {
+	memberships, err := w.Groups.Memberships(logger, nil)
+	if err != nil {
+		logger.Error("memberships", err)
+		return models.WhitelistChanges{}, err
+	}
+	if latestMembership(memberships) > since {
+		return models.WhitelistChanges{}, ResyncRequiredError{Since: since}
+	}
+
+	var groups []string
+	for _, name := range names {
+		groups = append(groups, groupFor(name, memberships))
+	}
+	changes, err := w.Rules.WhitelistChanges(logger, since, groups)
+	if err != nil {
+		return models.WhitelistChanges{}, err
+	}
+
+	// a group loses its tag only when a rule naming it is deleted
+	after, err := w.Groups.Memberships(logger, nil)
+	if err != nil {
+		logger.Error("memberships", err)
+		return models.WhitelistChanges{}, err
+	}
+	if latestMembership(after) != latestMembership(memberships) {
+		return models.WhitelistChanges{}, ResyncRequiredError{Since: since}
+	}
+	history, err := w.Rules.History(logger)
+	if err != nil {
+		return models.WhitelistChanges{}, err
+	}
+	if deletedFromGroups(history, since, changes.Revision, memberships) {
+		return models.WhitelistChanges{}, ResyncRequiredError{Since: since}
+	}
+
+	tags := map[string]models.IngressWhitelist{}
+	groups = []string{}
+	for _, change := range changes.Changes {
+		for _, source := range change.Added {
+			if g := memberships[source.ID].Group; g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if err := w.fetch(logger, tags, groups); err != nil {
+		return models.WhitelistChanges{}, err
+	}
+
+	byGroup := map[string]models.WhitelistChange{}
+	for _, change := range changes.Changes {
+		byGroup[change.Destination.ID] = change
+	}
+	if len(names) == 0 {
+		for _, change := range changes.Changes {
+			if memberships[change.Destination.ID].Group == "" {
+				names = append(names, change.Destination.ID)
+			}
+		}
+	}
+
+	grouped := models.WhitelistChanges{Since: changes.Since, Revision: changes.Revision, Changes: []models.WhitelistChange{}}
+	for _, name := range names {
+		group := groupFor(name, memberships)
+		change, ok := byGroup[group]
+		if !ok {
+			continue
+		}
+		change.Destination.ID = name
+		if group != name {
+			change.Destination.Group = group
+		}
+		change.Added = memberSources(change.Added, memberships, tags)
+		grouped.Changes = append(grouped.Changes, change)
+	}
+	return grouped, nil
+}
+
+func groupFor(name string, memberships map[string]Membership) string {
+	if group := memberships[name].Group; group != "" {
+		return group
+	}
+	return name
+}
+
+func memberSources(sources []models.AllowedSource, memberships map[string]Membership,
+	groups map[string]models.IngressWhitelist) []models.AllowedSource {
+	if sources == nil {
+		return nil
+	}
+	tagged := make([]models.AllowedSource, len(sources))
+	for i, source := range sources {
+		if group := memberships[source.ID].Group; group != "" {
+			source.Tag = groups[group].Destination.Tag
+		}
+		tagged[i] = source
+	}
+	return tagged
+}
+
+func copyMembers(members []string) []string {
+	if len(members) == 0 {
+		return nil
+	}
+	return append([]string{}, members...)
+}
+
+func latestMembership(memberships map[string]Membership) int64 {
+	var latest int64
+	for _, membership := range memberships {
+		if membership.Revision > latest {
+			latest = membership.Revision
+		}
+	}
+	return latest
+}
+
+func deletedFromGroups(history []models.Change, since, current int64, memberships map[string]Membership) bool {
+	covered := since == current
+	for _, change := range history {
+		if change.Revision <= since || change.Revision > current {
+			continue
+		}
+		if change.Revision == since+1 {
+			covered = true
+		}
+		for _, rule := range change.Deleted {
+			if len(memberships[rule.Source].Members) > 0 || len(memberships[rule.Destination].Members) > 0 {
+				return true
+			}
+		}
+	}
+	return !covered
+}
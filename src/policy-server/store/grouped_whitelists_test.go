@@ -0,0 +1,119 @@
+package store_test
+
+import (
+	"policy-server/fakes"
+	"policy-server/models"
+	"policy-server/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("GroupedWhitelists", func() {
+	var (
+		memStore   *store.MemoryStore
+		groupStore *store.MemoryGroupStore
+		whitelists *store.GroupedWhitelists
+		logger     *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		tagger := &fakes.Tagger{}
+		tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+			return models.PT(groupID + "-tag"), nil
+		}
+		memStore = store.NewMemoryStore(tagger)
+		groupStore = store.NewMemoryGroupStore(memStore)
+		whitelists = &store.GroupedWhitelists{Rules: memStore, Groups: groupStore}
+		logger = lagertest.NewTestLogger("test")
+
+		_, err := groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(added(memStore.Add(logger, "", models.Rule{Source: "app0", Destination: "frontends"}))).To(Succeed())
+		Expect(added(memStore.Add(logger, "", models.Rule{Source: "frontends", Destination: "backends"}))).To(Succeed())
+		Expect(added(memStore.Add(logger, "", models.Rule{Source: "app3", Destination: "app4"}))).To(Succeed())
+		_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1", "app2"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("GetWhitelists", func() {
+		It("gives the members of a group its tag and whitelist", func() {
+			all, err := whitelists.GetWhitelists(logger, []string{"app1", "frontends", "app0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all).To(Equal([]models.IngressWhitelist{
+				{
+					Destination:    models.TaggedGroup{ID: "app1", Tag: models.PT("frontends-tag"), Group: "frontends"},
+					Members:        []string{"app1", "app2"},
+					AllowedSources: []models.AllowedSource{{ID: "app0", Tag: models.PT("app0-tag")}},
+				},
+				{
+					Destination:    models.TaggedGroup{ID: "frontends", Tag: models.PT("frontends-tag")},
+					Members:        []string{"app1", "app2"},
+					AllowedSources: []models.AllowedSource{{ID: "app0", Tag: models.PT("app0-tag")}},
+				},
+				{
+					Destination: models.TaggedGroup{ID: "app0", Tag: models.PT("app0-tag")},
+				},
+			}))
+		})
+
+		It("gives members the tag of their group as sources", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "app2", Destination: "backends"}))).To(Succeed())
+
+			all, err := whitelists.GetWhitelists(logger, []string{"backends"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all[0].AllowedSources).To(Equal([]models.AllowedSource{
+				{ID: "frontends", Tag: models.PT("frontends-tag")},
+				{ID: "app2", Tag: models.PT("frontends-tag")},
+			}))
+		})
+
+		It("leaves out the apps in groups when no groups are given", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "app3", Destination: "app1"}))).To(Succeed())
+
+			all, err := whitelists.GetWhitelists(logger, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			ids := []string{}
+			for _, whitelist := range all {
+				ids = append(ids, whitelist.Destination.ID)
+			}
+			Expect(ids).To(Equal([]string{"app4", "backends", "frontends"}))
+			Expect(all[2].Members).To(Equal([]string{"app1", "app2"}))
+		})
+	})
+
+	Describe("WhitelistChanges", func() {
+		It("returns the changes to the whitelist of the group for each member", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "backends", Destination: "frontends"}))).To(Succeed())
+
+			changes, err := whitelists.WhitelistChanges(logger, 4, []string{"app2", "backends"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(Equal(models.WhitelistChanges{
+				Since:    4,
+				Revision: 5,
+				Changes: []models.WhitelistChange{{
+					Destination: models.TaggedGroup{ID: "app2", Tag: models.PT("frontends-tag"), Group: "frontends"},
+					Added:       []models.AllowedSource{{ID: "backends", Tag: models.PT("backends-tag")}},
+					Removed:     []models.AllowedSource{},
+				}},
+			}))
+		})
+
+		It("requires a resync since a revision before the members of a group changed", func() {
+			_, err := whitelists.WhitelistChanges(logger, 3, []string{"backends"})
+			Expect(err).To(Equal(store.ResyncRequiredError{Since: 3}))
+
+			_, err = whitelists.WhitelistChanges(logger, 4, []string{"backends"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("requires a resync when a rule naming a group with members was deleted", func() {
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "frontends", Destination: "backends"})).To(Succeed())
+
+			_, err := whitelists.WhitelistChanges(logger, 4, nil)
+			Expect(err).To(Equal(store.ResyncRequiredError{Since: 4}))
+		})
+	})
+})
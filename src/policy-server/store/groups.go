@@ -0,0 +1,300 @@
+package store
+
+import (
+	"fmt"
+	"policy-server/models"
+	"sort"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type GroupStore interface {
+	CreateGroup(logger lager.Logger, name string) (models.Group, error)
+	GetGroup(logger lager.Logger, name string) (models.Group, error)
+	ListGroups(logger lager.Logger) ([]models.Group, error)
+	AddMembers(logger lager.Logger, actor, name string, members []string) (models.Group, error)
+	RemoveMember(logger lager.Logger, actor, name, member string) (models.Group, error)
+	Memberships(logger lager.Logger, names []string) (map[string]Membership, error)
+}
+
+type Membership struct {
+	Group    string   `json:"group,omitempty"`
+	Members  []string `json:"members"`
+	Revision int64    `json:"revision"`
+}
+
+type GroupNotFoundError struct {
+	Name string
+}
+
+func (e GroupNotFoundError) NotFound() bool {
+	return true
+}
+
+func (e GroupNotFoundError) Error() string {
+	return fmt.Sprintf("group %s does not exist", e.Name)
+}
+
+type MemberNotFoundError struct {
+	Name   string
+	Member string
+}
+
+func (e MemberNotFoundError) NotFound() bool {
+	return true
+}
+
+func (e MemberNotFoundError) Error() string {
+	return fmt.Sprintf("%s is not a member of group %s", e.Member, e.Name)
+}
+
+type GroupConflictError struct {
+	Name string
+
+	// empty for a group
+	Group string
+}
+
+func (e GroupConflictError) Conflict() bool {
+	return true
+}
+
+func (e GroupConflictError) Error() string {
+	if e.Group == "" {
+		return fmt.Sprintf("%s is a group", e.Name)
+	}
+	return fmt.Sprintf("%s is a member of group %s", e.Name, e.Group)
+}
+
+type GroupNameInUseError struct {
+	Name string
+}
+
+func (e GroupNameInUseError) Conflict() bool {
+	return true
+}
+
+func (e GroupNameInUseError) Error() string {
+	return fmt.Sprintf("rules already name %s", e.Name)
+}
+
+// a zero Membership removes the name; commit runs only once the change is stored
+type groupRules interface {
+	newChange(actor, action string) models.Change
+	createGroup(logger lager.Logger, name string, changed map[string]Membership, commit func(map[string]Membership)) error
+	changeGroup(logger lager.Logger, change models.Change, joined []string, changed map[string]Membership, commit func(map[string]Membership)) error
+}
+
+var (
+	_ GroupStore = &MemoryGroupStore{}
+	_ GroupStore = &SQLGroupStore{}
+
+	_ groupRules = &MemoryStore{}
+	_ groupRules = &FileStore{}
+)
+
+type MemoryGroupStore struct {
+	rules groupRules
+	names map[string]Membership
+	lock  sync.Mutex
+}
+
+func NewMemoryGroupStore(rules groupRules) *MemoryGroupStore {
+	return &MemoryGroupStore{
+		rules: rules,
+		names: map[string]Membership{},
+	}
+}
+
+func NewFileGroupStore(rules *FileStore) *MemoryGroupStore {
+	s := NewMemoryGroupStore(rules)
+	s.names = rules.memberships()
+	return s
+}
+
+func (s *MemoryGroupStore) CreateGroup(logger lager.Logger, name string) (models.Group, error) {
+	logger = logger.Session("memory-group-store-create", lager.Data{"group": name})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	known := s.names[name]
+	if known.Members != nil || known.Group != "" {
+		return models.Group{}, GroupConflictError{Name: name, Group: known.Group}
+	}
+
+	// an empty group changes no whitelist
+	created := map[string]Membership{name: {Members: []string{}, Revision: known.Revision}}
+	if err := s.rules.createGroup(logger, name, created, s.commit); err != nil {
+		logger.Error("create", err)
+		return models.Group{}, err
+	}
+	return models.Group{Name: name, Members: []string{}}, nil
+}
+
+func (s *MemoryGroupStore) GetGroup(logger lager.Logger, name string) (models.Group, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	known := s.names[name]
+	if known.Members == nil {
+		return models.Group{}, GroupNotFoundError{Name: name}
+	}
+	return groupOf(name, known), nil
+}
+
+func (s *MemoryGroupStore) ListGroups(logger lager.Logger) ([]models.Group, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	groups := []models.Group{}
+	for name, known := range s.names {
+		if known.Members != nil {
+			groups = append(groups, groupOf(name, known))
+		}
+	}
+	sort.Sort(byName(groups))
+	return groups, nil
+}
+
+func (s *MemoryGroupStore) AddMembers(logger lager.Logger, actor, name string, members []string) (models.Group, error) {
+	logger = logger.Session("memory-group-store-add-members", lager.Data{"group": name, "members": members})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	group := s.names[name]
+	if group.Members == nil {
+		return models.Group{}, GroupNotFoundError{Name: name}
+	}
+
+	changed := map[string]Membership{}
+	joined := []string{}
+	for _, member := range members {
+		known, ok := changed[member]
+		if !ok {
+			known = s.names[member]
+		}
+		if known.Members != nil || (known.Group != "" && known.Group != name) {
+			return models.Group{}, GroupConflictError{Name: member, Group: known.Group}
+		}
+		if known.Group == name {
+			continue
+		}
+		changed[member] = Membership{Group: name}
+		joined = append(joined, member)
+	}
+	if len(joined) == 0 {
+		return groupOf(name, group), nil
+	}
+
+	group.Members = append(append([]string{}, group.Members...), joined...)
+	sort.Strings(group.Members)
+	changed[name] = group
+	if err := s.change(logger, actor, name, joined, changed); err != nil {
+		return models.Group{}, err
+	}
+	return groupOf(name, group), nil
+}
+
+func (s *MemoryGroupStore) RemoveMember(logger lager.Logger, actor, name, member string) (models.Group, error) {
+	logger = logger.Session("memory-group-store-remove-member", lager.Data{"group": name, "member": member})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	group := s.names[name]
+	if group.Members == nil {
+		return models.Group{}, GroupNotFoundError{Name: name}
+	}
+	if s.names[member].Group != name {
+		return models.Group{}, MemberNotFoundError{Name: name, Member: member}
+	}
+
+	remaining := []string{}
+	for _, m := range group.Members {
+		if m != member {
+			remaining = append(remaining, m)
+		}
+	}
+	group.Members = remaining
+	if err := s.change(logger, actor, name, nil, map[string]Membership{name: group, member: {}}); err != nil {
+		return models.Group{}, err
+	}
+	return groupOf(name, group), nil
+}
+
+func (s *MemoryGroupStore) Memberships(logger lager.Logger, names []string) (map[string]Membership, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	memberships := map[string]Membership{}
+	if len(names) == 0 {
+		for name := range s.names {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if known, ok := s.names[name]; ok {
+			memberships[name] = known
+		}
+	}
+	return memberships, nil
+}
+
+// caller must hold the lock
+func (s *MemoryGroupStore) change(logger lager.Logger, actor, name string, joined []string, changed map[string]Membership) error {
+	change := s.rules.newChange(actor, models.ChangeGroup)
+	change.Group = name
+	if err := s.rules.changeGroup(logger, change, joined, changed, s.commit); err != nil {
+		logger.Error("change", err)
+		return err
+	}
+	return nil
+}
+
+// caller must hold the lock
+func (s *MemoryGroupStore) commit(changed map[string]Membership) {
+	next := make(map[string]Membership, len(s.names)+len(changed))
+	for name, known := range s.names {
+		next[name] = known
+	}
+	mergeMemberships(next, changed)
+	s.names = next
+}
+
+func mergeMemberships(names, changed map[string]Membership) {
+	for name, membership := range changed {
+		if membership.Members == nil && membership.Group == "" {
+			delete(names, name)
+			continue
+		}
+		names[name] = membership
+	}
+}
+
+func stampMemberships(changed map[string]Membership, revision int64) {
+	for name, membership := range changed {
+		membership.Revision = revision
+		changed[name] = membership
+	}
+}
+
+func groupOf(name string, membership Membership) models.Group {
+	members := make([]string, len(membership.Members))
+	copy(members, membership.Members)
+	return models.Group{Name: name, Members: members}
+}
+
+type byName []models.Group
+
+func (g byName) Len() int           { return len(g) }
+func (g byName) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g byName) Less(i, j int) bool { return g[i].Name < g[j].Name }
@@ -0,0 +1,275 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"policy-server/fakes"
+	"policy-server/models"
+	"policy-server/store"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("MemoryGroupStore", func() {
+	var (
+		memStore   *store.MemoryStore
+		groupStore *store.MemoryGroupStore
+		logger     *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		tagger := &fakes.Tagger{}
+		tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+			return models.PT(groupID + "-tag"), nil
+		}
+		memStore = store.NewMemoryStore(tagger)
+		groupStore = store.NewMemoryGroupStore(memStore)
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	It("creates empty groups and lists them by name", func() {
+		group, err := groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{}}))
+		_, err = groupStore.CreateGroup(logger, "backends")
+		Expect(err).NotTo(HaveOccurred())
+
+		groups, err := groupStore.ListGroups(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(Equal([]models.Group{
+			{Name: "backends", Members: []string{}},
+			{Name: "frontends", Members: []string{}},
+		}))
+
+		Expect(memStore.Revision(logger)).To(Equal(int64(0)))
+	})
+
+	It("refuses to create a group that exists", func() {
+		_, err := groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = groupStore.CreateGroup(logger, "frontends")
+		Expect(err).To(Equal(store.GroupConflictError{Name: "frontends"}))
+	})
+
+	It("refuses to create a group that rules already name, such as an app", func() {
+		Expect(added(memStore.Add(logger, "", models.Rule{Source: "attacker-app", Destination: "victim-app"}))).To(Succeed())
+
+		_, err := groupStore.CreateGroup(logger, "victim-app")
+		Expect(err).To(Equal(store.GroupNameInUseError{Name: "victim-app"}))
+		Expect(err.(store.GroupNameInUseError).Conflict()).To(BeTrue())
+
+		_, err = groupStore.GetGroup(logger, "victim-app")
+		Expect(err).To(Equal(store.GroupNotFoundError{Name: "victim-app"}))
+	})
+
+	It("returns a not found error for a group that does not exist", func() {
+		_, err := groupStore.GetGroup(logger, "frontends")
+		Expect(err).To(Equal(store.GroupNotFoundError{Name: "frontends"}))
+
+		_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+		Expect(err).To(Equal(store.GroupNotFoundError{Name: "frontends"}))
+	})
+
+	Describe("members", func() {
+		BeforeEach(func() {
+			_, err := groupStore.CreateGroup(logger, "frontends")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "app0", Destination: "frontends"}))).To(Succeed())
+		})
+
+		It("adds and removes members, making a revision for each change", func() {
+			changes := memStore.Changes()
+
+			group, err := groupStore.AddMembers(logger, "alice", "frontends", []string{"app2", "app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{"app1", "app2"}}))
+			Expect(memStore.Revision(logger)).To(Equal(int64(2)))
+			Eventually(changes).Should(BeClosed())
+
+			history, err := memStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[1].Revision).To(Equal(int64(2)))
+			Expect(history[1].Actor).To(Equal("alice"))
+			Expect(history[1].Action).To(Equal(models.ChangeGroup))
+			Expect(history[1].Group).To(Equal("frontends"))
+			Expect(history[1].Added).To(BeEmpty())
+
+			group, err = groupStore.RemoveMember(logger, "alice", "frontends", "app1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{"app2"}}))
+			Expect(memStore.Revision(logger)).To(Equal(int64(3)))
+
+			memberships, err := groupStore.Memberships(logger, []string{"frontends", "app1", "app2", "app3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(memberships).To(Equal(map[string]store.Membership{
+				"frontends": {Members: []string{"app2"}, Revision: 3},
+				"app2":      {Group: "frontends", Revision: 2},
+			}))
+		})
+
+		It("leaves the revision alone when the apps are members already", func() {
+			_, err := groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			group, err := groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Members).To(Equal([]string{"app1"}))
+			Expect(memStore.Revision(logger)).To(Equal(int64(2)))
+		})
+
+		It("keeps an app in one group at a time, and groups out of groups", func() {
+			_, err := groupStore.CreateGroup(logger, "backends")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = groupStore.AddMembers(logger, "", "backends", []string{"app2", "app1"})
+			Expect(err).To(Equal(store.GroupConflictError{Name: "app1", Group: "frontends"}))
+			Expect(err.(store.GroupConflictError).Conflict()).To(BeTrue())
+
+			_, err = groupStore.AddMembers(logger, "", "backends", []string{"frontends"})
+			Expect(err).To(Equal(store.GroupConflictError{Name: "frontends"}))
+
+			_, err = groupStore.CreateGroup(logger, "app1")
+			Expect(err).To(Equal(store.GroupConflictError{Name: "app1", Group: "frontends"}))
+
+			group, err := groupStore.GetGroup(logger, "backends")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Members).To(BeEmpty())
+		})
+
+		It("refuses apps that rules still name, leaving their rules in effect", func() {
+			_, err := groupStore.AddMembers(logger, "", "frontends", []string{"app1", "app0"})
+			Expect(err).To(Equal(store.GroupNameInUseError{Name: "app0"}))
+
+			group, err := groupStore.GetGroup(logger, "frontends")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Members).To(BeEmpty())
+			Expect(memStore.Revision(logger)).To(Equal(int64(1)))
+		})
+
+		It("returns a not found error for an app that is not a member", func() {
+			_, err := groupStore.RemoveMember(logger, "", "frontends", "app1")
+			Expect(err).To(Equal(store.MemberNotFoundError{Name: "frontends", Member: "app1"}))
+			Expect(err.(store.MemberNotFoundError).NotFound()).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("MemoryGroupStore in a directory", func() {
+	var (
+		dataDir    string
+		fileStore  *store.FileStore
+		groupStore *store.MemoryGroupStore
+		logger     *lagertest.TestLogger
+		fakeClock  *fakeclock.FakeClock
+	)
+
+	open := func() {
+		var err error
+		fileStore, err = store.NewFileStore(logger, dataDir, 0, 32, quarantine, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		groupStore = store.NewFileGroupStore(fileStore)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "group-store")
+		Expect(err).NotTo(HaveOccurred())
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		open()
+	})
+
+	AfterEach(func() {
+		Expect(fileStore.Close()).To(Succeed())
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("keeps the groups and the revisions of their changes across restarts", func() {
+		_, err := groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = groupStore.AddMembers(logger, "alice", "frontends", []string{"app1", "app2"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = groupStore.RemoveMember(logger, "alice", "frontends", "app2")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fileStore.Close()).To(Succeed())
+		open()
+
+		group, err := groupStore.GetGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(group.Members).To(Equal([]string{"app1"}))
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+
+		history, err := fileStore.History(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].Group).To(Equal("frontends"))
+		Expect(history[0].Action).To(Equal(models.ChangeGroup))
+
+		memberships, err := groupStore.Memberships(logger, []string{"app1", "app2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(memberships).To(Equal(map[string]store.Membership{
+			"app1": {Group: "frontends", Revision: 1},
+		}))
+	})
+
+	It("keeps groups created since the last snapshot, and the members of groups in snapshots", func() {
+		Expect(fileStore.Close()).To(Succeed())
+		var err error
+		fileStore, err = store.NewFileStore(logger, dataDir, 3, 32, quarantine, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		groupStore = store.NewFileGroupStore(fileStore)
+
+		_, err = groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app2"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = groupStore.CreateGroup(logger, "backends")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fileStore.Close()).To(Succeed())
+		open()
+
+		groups, err := groupStore.ListGroups(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(groups).To(Equal([]models.Group{
+			{Name: "backends", Members: []string{}},
+			{Name: "frontends", Members: []string{"app1", "app2"}},
+		}))
+		Expect(fileStore.Revision(logger)).To(Equal(int64(2)))
+	})
+
+	It("changes nothing when the change cannot be logged", func() {
+		_, err := groupStore.CreateGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileStore.Close()).To(Succeed())
+
+		_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+		Expect(err).To(HaveOccurred())
+
+		group, err := groupStore.GetGroup(logger, "frontends")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(group.Members).To(BeEmpty())
+		Expect(fileStore.Revision(logger)).To(Equal(int64(0)))
+		Expect(fileStore.History(logger)).To(BeEmpty())
+
+		open()
+	})
+
+	It("refuses to create a group that rules already name", func() {
+		Expect(added(fileStore.Add(logger, "", models.Rule{Source: "app1", Destination: "app2"}))).To(Succeed())
+
+		_, err := groupStore.CreateGroup(logger, "app2")
+		Expect(err).To(Equal(store.GroupNameInUseError{Name: "app2"}))
+	})
+})
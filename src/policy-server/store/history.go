@@ -0,0 +1,72 @@
+package store
+
+import (
+	"fmt"
+	"policy-server/models"
+)
+
+const DefaultHistorySize = 1000
+
+type RevisionNotFoundError struct {
+	Revision int64
+}
+
+func (e RevisionNotFoundError) NotFound() bool {
+	return true
+}
+
+func (e RevisionNotFoundError) Error() string {
+	return fmt.Sprintf("revision %d not found in history", e.Revision)
+}
+
+type RevisionChangedError struct {
+	Expected int64
+	Current  int64
+}
+
+func (e RevisionChangedError) Conflict() bool {
+	return true
+}
+
+func (e RevisionChangedError) Error() string {
+	return fmt.Sprintf("rules changed since revision %d, now at revision %d", e.Expected, e.Current)
+}
+
+// the history must hold every change after the target revision
+func rulesAt(rules []models.Rule, current int64, history []models.Change, target int64) ([]models.Rule, error) {
+	if target < 0 || target > current {
+		return nil, RevisionNotFoundError{Revision: target}
+	}
+
+	restored := make([]models.Rule, len(rules))
+	copy(restored, rules)
+
+	next := current
+	for i := len(history) - 1; i >= 0 && next > target; i-- {
+		change := history[i]
+		if change.Revision != next {
+			break
+		}
+		for _, rule := range change.Added {
+			restored, _ = removeMatching(restored, models.Rule{ID: rule.ID})
+		}
+		restored = append(restored, change.Deleted...)
+		next--
+	}
+	if next != target {
+		return nil, RevisionNotFoundError{Revision: target}
+	}
+	return restored, nil
+}
+
+func trimHistory(history []models.Change, size int) []models.Change {
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+	if len(history) <= size {
+		return history
+	}
+	trimmed := make([]models.Change, size)
+	copy(trimmed, history[len(history)-size:])
+	return trimmed
+}
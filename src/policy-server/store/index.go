@@ -0,0 +1,226 @@
+package store
+
+import (
+	"policy-server/models"
+	"sort"
+)
+
+const indexShards = 256
+
+type index struct {
+	revision int64
+	rules    []models.Rule
+	groups   [indexShards]map[string]*groupIndex
+
+	destinations []string
+
+	// only until the index is sealed
+	cloned  map[int]bool
+	changed []string
+	touched []string
+}
+
+type groupIndex struct {
+	tag       *models.PacketTag
+	from      []models.Rule
+	to        []models.Rule
+	whitelist []models.AllowedSource
+}
+
+func newIndex() *index {
+	x := &index{destinations: []string{}}
+	for i := range x.groups {
+		x.groups[i] = map[string]*groupIndex{}
+	}
+	return x
+}
+
+func shardOf(group string) int {
+	// FNV-1a
+	hash := uint32(2166136261)
+	for i := 0; i < len(group); i++ {
+		hash ^= uint32(group[i])
+		hash *= 16777619
+	}
+	return int(hash % indexShards)
+}
+
+func (x *index) group(name string) groupIndex {
+	if g, ok := x.groups[shardOf(name)][name]; ok {
+		return *g
+	}
+	return groupIndex{}
+}
+
+func (x *index) set(name string, g *groupIndex) {
+	shard := shardOf(name)
+	if !x.cloned[shard] {
+		groups := make(map[string]*groupIndex, len(x.groups[shard])+1)
+		for group, known := range x.groups[shard] {
+			groups[group] = known
+		}
+		x.groups[shard] = groups
+		x.cloned[shard] = true
+	}
+	if g == nil {
+		delete(x.groups[shard], name)
+	} else {
+		x.groups[shard][name] = g
+	}
+}
+
+func (x *index) update(rules []models.Rule, added, deleted []models.Rule) *index {
+	next := *x
+	next.rules = rules
+	next.cloned = map[int]bool{}
+	next.changed, next.touched = nil, nil
+
+	removed := map[string]bool{}
+	for _, rule := range deleted {
+		removed[rule.ID] = true
+	}
+	from := rulesByGroup(added, deleted, func(rule models.Rule) string { return rule.Source })
+	to := rulesByGroup(added, deleted, func(rule models.Rule) string { return rule.Destination })
+
+	seen := map[string]bool{}
+	for _, rules := range [][]models.Rule{added, deleted} {
+		for _, rule := range rules {
+			for _, group := range []string{rule.Source, rule.Destination} {
+				if seen[group] {
+					continue
+				}
+				seen[group] = true
+				next.changed = append(next.changed, group)
+
+				g := next.group(group)
+				if addedFrom, ok := from[group]; ok {
+					g.from = withRules(g.from, removed, addedFrom)
+				}
+				if addedTo, ok := to[group]; ok {
+					g.to = withRules(g.to, removed, addedTo)
+					next.touched = append(next.touched, group)
+				}
+				next.set(group, &g)
+			}
+		}
+	}
+	return &next
+}
+
+func (x *index) references(group string) bool {
+	g := x.group(group)
+	return len(g.from) > 0 || len(g.to) > 0
+}
+
+func (x *index) seal(revision int64, tags map[string]*models.PacketTag) {
+	x.revision = revision
+
+	joined, left := []string{}, map[string]bool{}
+	for _, group := range x.touched {
+		g := x.group(group)
+		wasDestination := len(g.whitelist) > 0
+		g.whitelist = nil
+		if len(g.to) > 0 {
+			g.whitelist = allowedSources(g.to, tags)
+		}
+		x.set(group, &g)
+
+		isDestination := len(g.whitelist) > 0
+		if isDestination && !wasDestination {
+			joined = append(joined, group)
+		} else if wasDestination && !isDestination {
+			left[group] = true
+		}
+	}
+	if len(joined) > 0 || len(left) > 0 {
+		x.destinations = mergeGroups(x.destinations, joined, left)
+	}
+
+	for _, group := range x.changed {
+		g := x.group(group)
+		tag, tagged := tags[group]
+		if !tagged && len(g.from) == 0 && len(g.to) == 0 {
+			x.set(group, nil)
+			continue
+		}
+		if g.tag != tag {
+			g.tag = tag
+			x.set(group, &g)
+		}
+	}
+
+	x.cloned, x.changed, x.touched = nil, nil, nil
+}
+
+func (x *index) whitelist(group string) (models.IngressWhitelist, bool) {
+	whitelist := models.IngressWhitelist{Destination: models.TaggedGroup{ID: group}}
+	g := x.group(group)
+	if g.tag == nil {
+		return whitelist, false
+	}
+	whitelist.Destination.Tag = g.tag
+	if len(g.whitelist) > 0 {
+		whitelist.AllowedSources = make([]models.AllowedSource, len(g.whitelist))
+		copy(whitelist.AllowedSources, g.whitelist)
+	}
+	return whitelist, true
+}
+
+func allowedSources(rules []models.Rule, tags map[string]*models.PacketTag) []models.AllowedSource {
+	seen := map[models.AllowedSource]bool{}
+	sources := []models.AllowedSource{}
+	for _, rule := range rules {
+		source := models.AllowedSource{
+			ID:        rule.Source,
+			Tag:       tags[rule.Source],
+			Protocol:  rule.Protocol,
+			StartPort: rule.StartPort,
+			EndPort:   rule.EndPort,
+		}
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+func rulesByGroup(added, deleted []models.Rule, key func(models.Rule) string) map[string][]models.Rule {
+	byGroup := map[string][]models.Rule{}
+	for _, rule := range deleted {
+		if _, ok := byGroup[key(rule)]; !ok {
+			byGroup[key(rule)] = []models.Rule{}
+		}
+	}
+	for _, rule := range added {
+		byGroup[key(rule)] = append(byGroup[key(rule)], rule)
+	}
+	return byGroup
+}
+
+func withRules(rules []models.Rule, removed map[string]bool, added []models.Rule) []models.Rule {
+	next := make([]models.Rule, 0, len(rules)+len(added))
+	for _, rule := range rules {
+		if !removed[rule.ID] {
+			next = append(next, rule)
+		}
+	}
+	return append(next, added...)
+}
+
+func mergeGroups(groups, joined []string, left map[string]bool) []string {
+	sort.Strings(joined)
+	merged := make([]string, 0, len(groups)+len(joined))
+	i := 0
+	for _, group := range groups {
+		for i < len(joined) && joined[i] < group {
+			merged = append(merged, joined[i])
+			i++
+		}
+		if !left[group] {
+			merged = append(merged, group)
+		}
+	}
+	return append(merged, joined[i:]...)
+}
@@ -0,0 +1,310 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"policy-server/models"
+	"sort"
+
+	"github.com/pivotal-golang/lager"
+)
+
+type SQLGroupStore struct {
+	rules *SQLStore
+	db    *Database
+}
+
+func NewSQLGroupStore(rules *SQLStore) *SQLGroupStore {
+	return &SQLGroupStore{rules: rules, db: rules.db}
+}
+
+func (s *SQLGroupStore) CreateGroup(logger lager.Logger, name string) (models.Group, error) {
+	logger = logger.Session("sql-group-store-create", lager.Data{"group": name})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	err := s.update(logger, "", name, func(tx *sql.Tx, revision int64, known map[string]Membership) (bool, error) {
+		if m := known[name]; m.Members != nil || m.Group != "" {
+			return false, GroupConflictError{Name: name, Group: m.Group}
+		}
+
+		if err := s.unreferenced(tx, name); err != nil {
+			return false, err
+		}
+
+		// an empty group changes no whitelist
+		_, err := tx.Exec(s.db.rebind(`INSERT INTO policy_groups (name, revision) VALUES (?, 0)`), name)
+		if err != nil && isUniqueViolation(err) {
+			return false, GroupConflictError{Name: name}
+		}
+		if err != nil {
+			return false, fmt.Errorf("insert group: %s", err)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return models.Group{}, err
+	}
+	return models.Group{Name: name, Members: []string{}}, nil
+}
+
+func (s *SQLGroupStore) GetGroup(logger lager.Logger, name string) (models.Group, error) {
+	var revision int64
+	err := s.db.conn.QueryRow(s.db.rebind(`SELECT revision FROM policy_groups WHERE name = ?`), name).Scan(&revision)
+	if err == sql.ErrNoRows {
+		return models.Group{}, GroupNotFoundError{Name: name}
+	}
+	if err != nil {
+		return models.Group{}, fmt.Errorf("select group: %s", err)
+	}
+
+	rows, err := s.db.conn.Query(s.db.rebind(`SELECT member FROM group_members WHERE group_name = ? ORDER BY member`), name)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("select members: %s", err)
+	}
+	defer rows.Close()
+
+	group := models.Group{Name: name, Members: []string{}}
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return models.Group{}, fmt.Errorf("scan member: %s", err)
+		}
+		group.Members = append(group.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return models.Group{}, fmt.Errorf("select members: %s", err)
+	}
+	return group, nil
+}
+
+func (s *SQLGroupStore) ListGroups(logger lager.Logger) ([]models.Group, error) {
+	known, err := selectMemberships(s.db.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []models.Group{}
+	for name, membership := range known {
+		if membership.Members != nil {
+			groups = append(groups, groupOf(name, membership))
+		}
+	}
+	sort.Sort(byName(groups))
+	return groups, nil
+}
+
+func (s *SQLGroupStore) AddMembers(logger lager.Logger, actor, name string, members []string) (models.Group, error) {
+	logger = logger.Session("sql-group-store-add-members", lager.Data{"group": name, "members": members})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	var group models.Group
+	err := s.update(logger, actor, name, func(tx *sql.Tx, revision int64, known map[string]Membership) (bool, error) {
+		if known[name].Members == nil {
+			return false, GroupNotFoundError{Name: name}
+		}
+		group = groupOf(name, known[name])
+
+		joined := false
+		for _, member := range members {
+			m := known[member]
+			if m.Members != nil || (m.Group != "" && m.Group != name) {
+				return false, GroupConflictError{Name: member, Group: m.Group}
+			}
+			if m.Group == name {
+				continue
+			}
+			if err := s.unreferenced(tx, member); err != nil {
+				return false, err
+			}
+
+			_, err := tx.Exec(s.db.rebind(`INSERT INTO group_members (member, group_name, revision) VALUES (?, ?, ?)`),
+				member, name, revision)
+			if err != nil {
+				return false, fmt.Errorf("insert member: %s", err)
+			}
+			known[member] = Membership{Group: name, Revision: revision}
+			group.Members = append(group.Members, member)
+			joined = true
+		}
+		if !joined {
+			return false, nil
+		}
+
+		_, err := tx.Exec(s.db.rebind(`UPDATE policy_groups SET revision = ? WHERE name = ?`), revision, name)
+		if err != nil {
+			return false, fmt.Errorf("update group: %s", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return models.Group{}, err
+	}
+	sort.Strings(group.Members)
+	return group, nil
+}
+
+func (s *SQLGroupStore) RemoveMember(logger lager.Logger, actor, name, member string) (models.Group, error) {
+	logger = logger.Session("sql-group-store-remove-member", lager.Data{"group": name, "member": member})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	var group models.Group
+	err := s.update(logger, actor, name, func(tx *sql.Tx, revision int64, known map[string]Membership) (bool, error) {
+		if known[name].Members == nil {
+			return false, GroupNotFoundError{Name: name}
+		}
+		if known[member].Group != name {
+			return false, MemberNotFoundError{Name: name, Member: member}
+		}
+
+		_, err := tx.Exec(s.db.rebind(`DELETE FROM group_members WHERE member = ?`), member)
+		if err != nil {
+			return false, fmt.Errorf("delete member: %s", err)
+		}
+		_, err = tx.Exec(s.db.rebind(`UPDATE policy_groups SET revision = ? WHERE name = ?`), revision, name)
+		if err != nil {
+			return false, fmt.Errorf("update group: %s", err)
+		}
+
+		group = models.Group{Name: name, Members: []string{}}
+		for _, m := range known[name].Members {
+			if m != member {
+				group.Members = append(group.Members, m)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+func (s *SQLGroupStore) Memberships(logger lager.Logger, names []string) (map[string]Membership, error) {
+	known, err := selectMemberships(s.db.conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return known, nil
+	}
+
+	memberships := map[string]Membership{}
+	for _, name := range names {
+		if membership, ok := known[name]; ok {
+			memberships[name] = membership
+		}
+	}
+	return memberships, nil
+}
+
+func (s *SQLGroupStore) update(logger lager.Logger, actor, name string,
+	change func(tx *sql.Tx, revision int64, known map[string]Membership) (bool, error)) error {
+	s.rules.writeLock.Lock()
+	defer s.rules.writeLock.Unlock()
+
+	tx, err := s.db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	var current int64
+	err = tx.QueryRow(s.db.forUpdate(`SELECT revision FROM policy_revision`)).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("select revision: %s", err)
+	}
+
+	known, err := selectMemberships(tx)
+	if err != nil {
+		return err
+	}
+
+	changed, err := change(tx, current+1, known)
+	if err != nil {
+		logger.Error("change", err)
+		return err
+	}
+
+	if changed {
+		record := s.rules.newChange(actor, models.ChangeGroup)
+		record.Group = name
+		revision, err := s.rules.recordChange(tx, record)
+		if err != nil {
+			return err
+		}
+		if revision != current+1 {
+			return fmt.Errorf("revision changed from %d to %d", current, revision)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %s", err)
+	}
+	if changed {
+		s.rules.notify()
+	}
+	return nil
+}
+
+// a group takes over the rules of its members, so rules may not name them yet
+func (s *SQLGroupStore) unreferenced(tx *sql.Tx, name string) error {
+	var named int
+	err := tx.QueryRow(s.db.rebind(`SELECT COUNT(*) FROM rules WHERE source = ? OR destination = ?`), name, name).Scan(&named)
+	if err != nil {
+		return fmt.Errorf("select rules: %s", err)
+	}
+	if named > 0 {
+		return GroupNameInUseError{Name: name}
+	}
+	return nil
+}
+
+func selectMemberships(q queryer) (map[string]Membership, error) {
+	known := map[string]Membership{}
+
+	rows, err := q.Query(`SELECT name, revision FROM policy_groups`)
+	if err != nil {
+		return nil, fmt.Errorf("select groups: %s", err)
+	}
+	for rows.Next() {
+		var name string
+		membership := Membership{Members: []string{}}
+		if err := rows.Scan(&name, &membership.Revision); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan group: %s", err)
+		}
+		known[name] = membership
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("select groups: %s", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("close rows: %s", err)
+	}
+
+	rows, err = q.Query(`SELECT member, group_name, revision FROM group_members ORDER BY member`)
+	if err != nil {
+		return nil, fmt.Errorf("select members: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member string
+		var membership Membership
+		if err := rows.Scan(&member, &membership.Group, &membership.Revision); err != nil {
+			return nil, fmt.Errorf("scan member: %s", err)
+		}
+		known[member] = membership
+		if group, ok := known[membership.Group]; ok {
+			group.Members = append(group.Members, member)
+			known[membership.Group] = group
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("select members: %s", err)
+	}
+	return known, nil
+}
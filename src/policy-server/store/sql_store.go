@@ -0,0 +1,716 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"policy-server/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+type SQLStore struct {
+	changeNotifier
+
+	Tagger      Tagger
+	Publisher   Publisher
+	Clock       clock.Clock
+	HistorySize int
+	db          *Database
+	tagger      *sqlTagger
+	writeLock   sync.Mutex
+}
+
+func NewSQLStore(db *Database, tagBits int, quarantine time.Duration, clock clock.Clock) (*SQLStore, error) {
+	tagger, err := newSQLTagger(db, tagBits, quarantine, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{
+		Tagger:      tagger,
+		Clock:       clock,
+		HistorySize: DefaultHistorySize,
+		db:          db,
+		tagger:      tagger,
+	}, nil
+}
+
+func (s *SQLStore) GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error) {
+	tx, err := s.db.beginSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	sources, destinations, err := s.selectSources(tx, groups)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		groups = destinations
+	}
+	if len(groups) == 0 {
+		return []models.IngressWhitelist{}, nil
+	}
+
+	tags, err := s.tagger.selectTags(tx, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]models.IngressWhitelist, len(groups))
+	for i, destGroup := range groups {
+		all[i].Destination.ID = destGroup
+		tag, ok := tags[destGroup]
+		if !ok {
+			logger.Info("no-tag-found", lager.Data{"group": destGroup})
+			continue
+		}
+		all[i].Destination.Tag = tag
+		all[i].AllowedSources = sources[destGroup]
+	}
+	logger.Info("built-whitelist", lager.Data{"whitelist": all})
+	return all, nil
+}
+
+func (s *SQLStore) selectSources(q queryer, groups []string) (map[string][]models.AllowedSource, []string, error) {
+	where := ""
+	var args []interface{}
+	if len(groups) > 0 {
+		var in string
+		in, args = inList(groups)
+		where = `WHERE rules.destination IN ` + in
+	}
+
+	// rules with different owners may allow the same traffic
+	rows, err := q.Query(s.db.rebind(`
+		SELECT rules.destination, rules.source, packet_tags.tag_value, rules.protocol, rules.start_port, rules.end_port
+		FROM rules LEFT JOIN packet_tags ON rules.source = packet_tags.group_id AND packet_tags.released_at IS NULL
+		`+where+`
+		GROUP BY rules.destination, rules.source, packet_tags.tag_value, rules.protocol, rules.start_port, rules.end_port
+		ORDER BY rules.destination, MIN(rules.id)`), args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("select sources: %s", err)
+	}
+	defer rows.Close()
+
+	sources := map[string][]models.AllowedSource{}
+	destinations := []string{}
+	for rows.Next() {
+		var destination string
+		var source models.AllowedSource
+		var value sql.NullInt64
+		if err := rows.Scan(&destination, &source.ID, &value, &source.Protocol, &source.StartPort, &source.EndPort); err != nil {
+			return nil, nil, fmt.Errorf("scan source: %s", err)
+		}
+		if value.Valid {
+			source.Tag, err = intToPacketTag(value.Int64, s.tagger.TagBits)
+			if err != nil {
+				return nil, nil, fmt.Errorf("tag of %s: %s", source.ID, err)
+			}
+		}
+		if _, ok := sources[destination]; !ok {
+			destinations = append(destinations, destination)
+		}
+		sources[destination] = append(sources[destination], source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read sources: %s", err)
+	}
+	return sources, destinations, nil
+}
+
+func (s *SQLStore) Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error) {
+	logger = logger.Session("sql-store-add")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	existing, found, err := s.findRule(rule)
+	if err != nil {
+		return models.Rule{}, false, err
+	}
+	if found {
+		logger.Info("already-exists", lager.Data{"rule": existing})
+		return existing, false, nil
+	}
+
+	g1Tag, err := s.Tagger.GetTag(rule.Source)
+	if err != nil {
+		logger.Error("get-tag", err, lager.Data{"group": rule.Source})
+		return models.Rule{}, false, err
+	}
+
+	g2Tag, err := s.Tagger.GetTag(rule.Destination)
+	if err != nil {
+		logger.Error("get-tag", err, lager.Data{"group": rule.Destination})
+		s.releaseUnreferenced(logger, rule.Source)
+		return models.Rule{}, false, err
+	}
+
+	revision, newTags, err := s.insertRule(s.newChange(actor, models.ChangeAdd), &rule, g1Tag, g2Tag)
+	if err == errRuleExists {
+		// another server added it since the find
+		existing, found, err := s.findRule(rule)
+		if err == nil && found {
+			logger.Info("already-exists", lager.Data{"rule": existing})
+			return existing, false, nil
+		}
+	}
+	if err != nil {
+		s.releaseUnreferenced(logger, rule.Source, rule.Destination)
+		return models.Rule{}, false, err
+	}
+	s.notify()
+	publish(s.Publisher, ruleAddedEvents(revision, rule, newTags)...)
+	logger.Info("added", lager.Data{"rule": rule, "group1-tag": g1Tag, "group2-tag": g2Tag})
+
+	return rule, true, nil
+}
+
+func (s *SQLStore) findRule(rule models.Rule) (models.Rule, bool, error) {
+	var id int64
+	err := s.db.conn.QueryRow(s.db.rebind(`
+		SELECT id FROM rules WHERE `+sameRule+` ORDER BY id LIMIT 1`),
+		sameRuleArgs(rule)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return models.Rule{}, false, nil
+	}
+	if err != nil {
+		return models.Rule{}, false, fmt.Errorf("find rule: %s", err)
+	}
+	rule.ID = strconv.FormatInt(id, 10)
+	return rule, true, nil
+}
+
+func (s *SQLStore) insertRule(change models.Change, rule *models.Rule, g1Tag, g2Tag *models.PacketTag) (int64, map[string]*models.PacketTag, error) {
+	tx, err := s.db.conn.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	newTags, err := s.untagged(tx, map[string]*models.PacketTag{rule.Source: g1Tag, rule.Destination: g2Tag})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := s.insertRuleTx(tx, rule); err != nil {
+		return 0, nil, err
+	}
+
+	change.Added = []models.Rule{*rule}
+	revision, err := s.recordChange(tx, change)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("commit: %s", err)
+	}
+	return revision, newTags, nil
+}
+
+var errRuleExists = errors.New("rule exists")
+
+func (s *SQLStore) insertRuleTx(tx *sql.Tx, rule *models.Rule) error {
+	id, err := s.db.insertReturningID(tx, `INSERT INTO rules (source, destination, protocol, start_port, end_port, owner) VALUES (?, ?, ?, ?, ?, ?)`,
+		sameRuleArgs(*rule)...)
+	if err != nil && isUniqueViolation(err) {
+		return errRuleExists
+	}
+	if err != nil {
+		return fmt.Errorf("insert rule: %s", err)
+	}
+	rule.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+func (s *SQLStore) untagged(tx *sql.Tx, tags map[string]*models.PacketTag) (map[string]*models.PacketTag, error) {
+	newTags := map[string]*models.PacketTag{}
+	for groupID, tag := range tags {
+		var references int
+		err := tx.QueryRow(s.db.rebind(`SELECT COUNT(*) FROM rules WHERE source = ? OR destination = ?`), groupID, groupID).Scan(&references)
+		if err != nil {
+			return nil, fmt.Errorf("count references: %s", err)
+		}
+		if references == 0 {
+			newTags[groupID] = tag
+		}
+	}
+	return newTags, nil
+}
+
+// caller must hold the write lock
+func (s *SQLStore) releaseUnreferenced(logger lager.Logger, groups ...string) {
+	tx, err := s.db.conn.Begin()
+	if err != nil {
+		logger.Error("release-tags", err, lager.Data{"groups": groups})
+		return
+	}
+	defer tx.Rollback()
+
+	released, err := s.tagger.releaseUnreferenced(tx, groups)
+	if err != nil {
+		logger.Error("release-tags", err, lager.Data{"groups": groups})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error("release-tags", err, lager.Data{"groups": groups})
+		return
+	}
+	s.released(logger, released)
+}
+
+func (s *SQLStore) released(logger lager.Logger, groups []string) {
+	s.tagger.countFreed(len(groups))
+	for _, group := range groups {
+		logger.Info("released-tag", lager.Data{"group": group})
+	}
+}
+
+func (s *SQLStore) Delete(logger lager.Logger, actor string, rule models.Rule) error {
+	logger = logger.Session("sql-store-delete")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	deleted, revision, released, err := s.deleteRules(s.newChange(actor, models.ChangeDelete), rule)
+	if err != nil {
+		return err
+	}
+
+	s.released(logger, released)
+	s.notify()
+	for _, r := range deleted {
+		publish(s.Publisher, ruleDeletedEvent(revision, r))
+	}
+
+	logger.Info("deleted", lager.Data{"rules": deleted})
+	return nil
+}
+
+func (s *SQLStore) deleteRules(change models.Change, request models.Rule) ([]models.Rule, int64, []string, error) {
+	where := sameRule
+	args := sameRuleArgs(request)
+	if request.ID != "" {
+		id, err := strconv.ParseInt(request.ID, 10, 64)
+		if err != nil {
+			return nil, 0, nil, NotFoundError{Rule: request}
+		}
+		where = `id = ?`
+		args = []interface{}{id}
+	}
+
+	tx, err := s.db.conn.Begin()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := selectRules(tx, s.db.rebind(`
+		SELECT `+ruleColumns+` FROM rules WHERE `+where+` ORDER BY id`), args...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(deleted) == 0 {
+		return nil, 0, nil, NotFoundError{Rule: request}
+	}
+
+	_, err = tx.Exec(s.db.rebind(`DELETE FROM rules WHERE `+where), args...)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("delete rule: %s", err)
+	}
+
+	groups := []string{}
+	for _, rule := range deleted {
+		groups = append(groups, rule.Source, rule.Destination)
+	}
+	released, err := s.tagger.releaseUnreferenced(tx, groups)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	change.Deleted = deleted
+	revision, err := s.recordChange(tx, change)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, nil, fmt.Errorf("commit: %s", err)
+	}
+	return deleted, revision, released, nil
+}
+
+func (s *SQLStore) ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error) {
+	logger = logger.Session("sql-store-apply-batch")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	rules, err := s.List(logger)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	return s.applyBatch(logger, s.newChange(actor, models.ChangeBatch), rules, models.AnyRevision, batch, false)
+}
+
+func (s *SQLStore) Sync(logger lager.Logger, actor, owner string, desired []models.Rule, revision int64, dryRun bool) (models.BatchResult, error) {
+	logger = logger.Session("sql-store-sync", lager.Data{"owner": owner, "dry-run": dryRun})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	tx, err := s.db.beginSnapshot()
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("begin: %s", err)
+	}
+	current, rules, _, err := s.snapshot(tx)
+	tx.Rollback()
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	if revision != models.AnyRevision && revision != current {
+		return models.BatchResult{}, RevisionChangedError{Expected: revision, Current: current}
+	}
+	return s.applyBatch(logger, s.newChange(actor, models.ChangeSync), rules, revision, syncBatch(rules, owner, desired), dryRun)
+}
+
+func (s *SQLStore) Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error) {
+	logger = logger.Session("sql-store-rollback", lager.Data{"revision": revision})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	tx, err := s.db.beginSnapshot()
+	if err != nil {
+		return models.BatchResult{}, fmt.Errorf("begin: %s", err)
+	}
+	current, rules, history, err := s.snapshot(tx)
+	tx.Rollback()
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+
+	target, err := rulesAt(rules, current, history, revision)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+
+	change := s.newChange(actor, models.ChangeRollback)
+	change.RollbackTo = &revision
+	return s.applyBatch(logger, change, rules, models.AnyRevision, diffBatch(rules, target), false)
+}
+
+func (s *SQLStore) snapshot(tx *sql.Tx) (int64, []models.Rule, []models.Change, error) {
+	var revision int64
+	err := tx.QueryRow(`SELECT revision FROM policy_revision`).Scan(&revision)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("select revision: %s", err)
+	}
+
+	rules, err := selectRules(tx, `SELECT `+ruleColumns+` FROM rules ORDER BY id`)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	history, err := selectHistory(tx)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return revision, rules, history, nil
+}
+
+// the plan is only applied at the given revision, unless that is AnyRevision
+// caller must hold the write lock
+func (s *SQLStore) applyBatch(logger lager.Logger, change models.Change, rules []models.Rule, revision int64, batch models.Batch, dryRun bool) (models.BatchResult, error) {
+	plan, err := planBatch(rules, batch)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	if dryRun || plan.empty() {
+		revision, err := s.Revision(logger)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		return plan.preview(revision), nil
+	}
+
+	tags, tagged, err := tagGroups(logger, s.Tagger, plan.added)
+	if err != nil {
+		s.releaseUnreferenced(logger, tagged...)
+		return models.BatchResult{}, err
+	}
+
+	revision, newTags, released, err := s.applyPlan(change, plan, revision, tags)
+	if err != nil {
+		logger.Error("apply", err)
+		s.releaseUnreferenced(logger, tagged...)
+		return models.BatchResult{}, err
+	}
+
+	s.released(logger, released)
+	s.notify()
+	publish(s.Publisher, batchEvents(revision, plan, newTags)...)
+	logger.Info("applied", lager.Data{"added": plan.added, "deleted": plan.deleted, "revision": revision})
+
+	return models.BatchResult{Revision: revision, Added: plan.added, Deleted: plan.deleted}, nil
+}
+
+func (s *SQLStore) applyPlan(change models.Change, plan batchPlan, at int64, tags map[string]*models.PacketTag) (int64, map[string]*models.PacketTag, []string, error) {
+	tx, err := s.db.conn.Begin()
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	if at != models.AnyRevision {
+		var current int64
+		err := tx.QueryRow(s.db.forUpdate(`SELECT revision FROM policy_revision`)).Scan(&current)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("select revision: %s", err)
+		}
+		if current != at {
+			return 0, nil, nil, RevisionChangedError{Expected: at, Current: current}
+		}
+	}
+
+	newTags, err := s.untagged(tx, tags)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	for _, rule := range plan.deleted {
+		id, err := strconv.ParseInt(rule.ID, 10, 64)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("parse rule id: %s", err)
+		}
+		result, err := tx.Exec(s.db.rebind(`DELETE FROM rules WHERE id = ?`), id)
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("delete rule: %s", err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("delete rule: %s", err)
+		}
+		if deleted == 0 {
+			return 0, nil, nil, NotFoundError{Rule: rule}
+		}
+	}
+
+	for i := range plan.added {
+		if err := s.insertRuleTx(tx, &plan.added[i]); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	released, err := s.tagger.releaseUnreferenced(tx, plan.deletedGroups())
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	change.Added, change.Deleted = plan.added, plan.deleted
+	revision, err := s.recordChange(tx, change)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, nil, fmt.Errorf("commit: %s", err)
+	}
+	return revision, newTags, released, nil
+}
+
+const (
+	ruleColumns = `id, source, destination, protocol, start_port, end_port, owner`
+
+	// sameRule matches the rules equal to the one given by sameRuleArgs
+	sameRule = `source = ? AND destination = ? AND protocol = ? AND start_port = ? AND end_port = ? AND owner = ?`
+)
+
+func sameRuleArgs(rule models.Rule) []interface{} {
+	return []interface{}{rule.Source, rule.Destination, rule.Protocol, rule.StartPort, rule.EndPort, rule.Owner}
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func selectRules(q queryer, query string, args ...interface{}) ([]models.Rule, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select rules: %s", err)
+	}
+	defer rows.Close()
+
+	rules := []models.Rule{}
+	for rows.Next() {
+		var id int64
+		var rule models.Rule
+		if err := rows.Scan(&id, &rule.Source, &rule.Destination, &rule.Protocol, &rule.StartPort, &rule.EndPort, &rule.Owner); err != nil {
+			return nil, fmt.Errorf("scan rule: %s", err)
+		}
+		rule.ID = strconv.FormatInt(id, 10)
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read rules: %s", err)
+	}
+	return rules, nil
+}
+
+func bumpRevision(tx *sql.Tx) (int64, error) {
+	_, err := tx.Exec(`UPDATE policy_revision SET revision = revision + 1`)
+	if err != nil {
+		return 0, fmt.Errorf("bump revision: %s", err)
+	}
+
+	var revision int64
+	err = tx.QueryRow(`SELECT revision FROM policy_revision`).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("select revision: %s", err)
+	}
+	return revision, nil
+}
+
+func (s *SQLStore) newChange(actor, action string) models.Change {
+	return models.Change{Time: s.Clock.Now(), Actor: actor, Action: action}
+}
+
+func (s *SQLStore) recordChange(tx *sql.Tx, change models.Change) (int64, error) {
+	revision, err := bumpRevision(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	added, err := json.Marshal(change.Added)
+	if err != nil {
+		return 0, fmt.Errorf("encode added rules: %s", err)
+	}
+	deleted, err := json.Marshal(change.Deleted)
+	if err != nil {
+		return 0, fmt.Errorf("encode deleted rules: %s", err)
+	}
+
+	_, err = tx.Exec(s.db.rebind(`
+		INSERT INTO policy_history (revision, created_at, actor, action, rollback_to, group_name, added, deleted)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		revision, change.Time.UnixNano(), change.Actor, change.Action, change.RollbackTo, change.Group,
+		string(added), string(deleted))
+	if err != nil {
+		return 0, fmt.Errorf("insert history: %s", err)
+	}
+
+	size := s.HistorySize
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+	_, err = tx.Exec(s.db.rebind(`DELETE FROM policy_history WHERE revision <= ?`), revision-int64(size))
+	if err != nil {
+		return 0, fmt.Errorf("trim history: %s", err)
+	}
+	return revision, nil
+}
+
+func selectHistory(q queryer) ([]models.Change, error) {
+	rows, err := q.Query(`
+		SELECT revision, created_at, actor, action, rollback_to, group_name, added, deleted
+		FROM policy_history ORDER BY revision`)
+	if err != nil {
+		return nil, fmt.Errorf("select history: %s", err)
+	}
+	defer rows.Close()
+
+	history := []models.Change{}
+	for rows.Next() {
+		var change models.Change
+		var createdAt int64
+		var rollbackTo sql.NullInt64
+		var added, deleted string
+		err := rows.Scan(&change.Revision, &createdAt, &change.Actor, &change.Action, &rollbackTo, &change.Group,
+			&added, &deleted)
+		if err != nil {
+			return nil, fmt.Errorf("scan change: %s", err)
+		}
+		change.Time = time.Unix(0, createdAt).UTC()
+		if rollbackTo.Valid {
+			change.RollbackTo = &rollbackTo.Int64
+		}
+		if err := json.Unmarshal([]byte(added), &change.Added); err != nil {
+			return nil, fmt.Errorf("decode added rules: %s", err)
+		}
+		if err := json.Unmarshal([]byte(deleted), &change.Deleted); err != nil {
+			return nil, fmt.Errorf("decode deleted rules: %s", err)
+		}
+		history = append(history, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %s", err)
+	}
+	return history, nil
+}
+
+func (s *SQLStore) WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error) {
+	logger = logger.Session("sql-store-whitelist-changes", lager.Data{"since": since})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	tx, err := s.db.beginSnapshot()
+	if err != nil {
+		return models.WhitelistChanges{}, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	revision, rules, history, err := s.snapshot(tx)
+	if err != nil {
+		return models.WhitelistChanges{}, err
+	}
+	tags, err := s.tagger.selectTags(tx, nil)
+	if err != nil {
+		return models.WhitelistChanges{}, err
+	}
+	return whitelistChanges(rules, revision, history, since, groups, func(group string) (*models.PacketTag, error) {
+		return tags[group], nil
+	})
+}
+
+func (s *SQLStore) History(logger lager.Logger) ([]models.Change, error) {
+	logger = logger.Session("sql-store-history")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	return selectHistory(s.db.conn)
+}
+
+func (s *SQLStore) Revision(logger lager.Logger) (int64, error) {
+	var revision int64
+	err := s.db.conn.QueryRow(`SELECT revision FROM policy_revision`).Scan(&revision)
+	if err != nil {
+		return 0, fmt.Errorf("select revision: %s", err)
+	}
+	return revision, nil
+}
+
+func (s *SQLStore) List(logger lager.Logger) ([]models.Rule, error) {
+	logger = logger.Session("sql-store-list")
+	logger.Info("start")
+	defer logger.Info("done")
+
+	return selectRules(s.db.conn, `SELECT `+ruleColumns+` FROM rules ORDER BY id`)
+}
@@ -0,0 +1,598 @@
+package store_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"policy-server/events"
+	"policy-server/models"
+	"policy-server/store"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("SQLStore", func() {
+	var (
+		dataDir   string
+		dbPath    string
+		db        *store.Database
+		tagger    store.Tagger
+		sqlStore  *store.SQLStore
+		fakeClock *fakeclock.FakeClock
+		logger    *lagertest.TestLogger
+	)
+
+	open := func() {
+		var err error
+		db, err = store.NewDatabase("sqlite3", dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		sqlStore, err = store.NewSQLStore(db, 32, quarantine, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		tagger = sqlStore.Tagger
+	}
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "sql-store")
+		Expect(err).NotTo(HaveOccurred())
+		dbPath = filepath.Join(dataDir, "policy.db")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		open()
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("supports add, list and delete", func() {
+		rules, err := sqlStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(BeEmpty())
+
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+
+		rules, err = sqlStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]models.Rule{
+			{ID: "1", Source: "group0", Destination: "group1"},
+			{ID: "2", Source: "group1", Destination: "group2"},
+		}))
+
+		Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+
+		rules, err = sqlStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]models.Rule{
+			{ID: "2", Source: "group1", Destination: "group2"},
+		}))
+	})
+
+	It("keeps the protocol and ports of each rule", func() {
+		tcpRule := models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp", StartPort: 8080, EndPort: 8090}
+		udpRule := models.Rule{Source: "group0", Destination: "group1", Protocol: "udp", StartPort: 53, EndPort: 53}
+		Expect(added(sqlStore.Add(logger, "", tcpRule))).To(Succeed())
+		udpRule, _, err := sqlStore.Add(logger, "", udpRule)
+		Expect(err).NotTo(HaveOccurred())
+
+		whitelists, err := sqlStore.GetWhitelists(logger, []string{"group1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(whitelists[0].AllowedSources).To(HaveLen(2))
+		Expect(whitelists[0].AllowedSources[0].Protocol).To(Equal("tcp"))
+		Expect(whitelists[0].AllowedSources[0].StartPort).To(Equal(8080))
+		Expect(whitelists[0].AllowedSources[0].EndPort).To(Equal(8090))
+
+		err = sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp"})
+		Expect(err).To(MatchError("not found"))
+		Expect(sqlStore.Delete(logger, "", tcpRule)).To(Succeed())
+
+		rules, err := sqlStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]models.Rule{udpRule}))
+	})
+
+	It("returns an error when deleting a rule that does not exist", func() {
+		err := sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+		Expect(err).To(MatchError("not found"))
+	})
+
+	It("returns the existing rule when an equal one is added", func() {
+		rule, created, err := sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeTrue())
+
+		again, created, err := sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeFalse())
+		Expect(again).To(Equal(rule))
+		Expect(sqlStore.Revision(logger)).To(Equal(int64(1)))
+	})
+
+	It("keeps servers that share the database from adding the same rule twice", func() {
+		rule, _, err := sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1", Owner: "team-a"})
+		Expect(err).NotTo(HaveOccurred())
+
+		conn, err := sql.Open("sqlite3", dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		_, err = conn.Exec(`INSERT INTO rules (source, destination, protocol, start_port, end_port, owner) VALUES ('group0', 'group1', '', 0, 0, 'team-a')`)
+		Expect(err).To(MatchError(ContainSubstring("UNIQUE")))
+
+		other, err := store.NewSQLStore(db, 32, quarantine, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		again, created, err := other.Add(logger, "", models.Rule{Source: "group0", Destination: "group1", Owner: "team-a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created).To(BeFalse())
+		Expect(again).To(Equal(rule))
+	})
+
+	It("deletes a rule by its ID", func() {
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		kept, _, err := sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(sqlStore.Delete(logger, "", models.Rule{ID: "1"})).To(Succeed())
+		Expect(sqlStore.Delete(logger, "", models.Rule{ID: "1"})).To(MatchError("not found"))
+		Expect(sqlStore.Delete(logger, "", models.Rule{ID: "not-a-number"})).To(MatchError("not found"))
+
+		rules, err := sqlStore.List(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]models.Rule{kept}))
+	})
+
+	Describe("ApplyBatch", func() {
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		})
+
+		It("applies every change under a single revision", func() {
+			result, err := sqlStore.ApplyBatch(logger, "", models.Batch{
+				Add: []models.Rule{
+					{Source: "group2", Destination: "group3"},
+					{Source: "group0", Destination: "group1"},
+				},
+				Delete: []models.Rule{{Source: "group1", Destination: "group2"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 3,
+				Added:    []models.Rule{{ID: "3", Source: "group2", Destination: "group3"}},
+				Deleted:  []models.Rule{{ID: "2", Source: "group1", Destination: "group2"}},
+			}))
+
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+				{ID: "3", Source: "group2", Destination: "group3"},
+			}))
+
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).NotTo(BeNil())
+			Expect(whitelists[0].AllowedSources).To(HaveLen(1))
+		})
+
+		It("changes nothing when a rule to delete does not exist", func() {
+			_, err := sqlStore.ApplyBatch(logger, "", models.Batch{
+				Add:    []models.Rule{{Source: "group2", Destination: "group3"}},
+				Delete: []models.Rule{{ID: "1"}, {ID: "7"}},
+			})
+			Expect(err).To(MatchError("not found"))
+
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(2))
+			Expect(sqlStore.Revision(logger)).To(Equal(int64(2)))
+
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(BeNil())
+		})
+	})
+
+	Describe("Sync", func() {
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1", Owner: "team-a"}))).To(Succeed())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1", Owner: "team-b"}))).To(Succeed())
+		})
+
+		It("replaces the rules of the owner and leaves the other rules alone", func() {
+			desired := []models.Rule{{Source: "group1", Destination: "group2"}}
+
+			result, err := sqlStore.Sync(logger, "", "team-a", desired, models.AnyRevision, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(int64(2)))
+			Expect(result.Added).To(Equal([]models.Rule{{Source: "group1", Destination: "group2", Owner: "team-a"}}))
+			Expect(sqlStore.Revision(logger)).To(Equal(int64(2)))
+
+			result, err = sqlStore.Sync(logger, "", "team-a", desired, models.AnyRevision, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 3,
+				Added:    []models.Rule{{ID: "3", Source: "group1", Destination: "group2", Owner: "team-a"}},
+				Deleted:  []models.Rule{{ID: "1", Source: "group0", Destination: "group1", Owner: "team-a"}},
+			}))
+
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{
+				{ID: "2", Source: "group0", Destination: "group1", Owner: "team-b"},
+				{ID: "3", Source: "group1", Destination: "group2", Owner: "team-a"},
+			}))
+		})
+
+		It("refuses to sync when the rules changed since the given revision", func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group3", Destination: "group4", Owner: "team-a"}))).To(Succeed())
+
+			_, err := sqlStore.Sync(logger, "", "team-a", []models.Rule{}, 2, false)
+			Expect(err).To(Equal(store.RevisionChangedError{Expected: 2, Current: 3}))
+
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(3))
+		})
+
+		It("lists traffic allowed by several owners once in the whitelist", func() {
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].AllowedSources).To(HaveLen(1))
+		})
+	})
+
+	Describe("history", func() {
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "alice", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			_, err := sqlStore.ApplyBatch(logger, "bob", models.Batch{
+				Add:    []models.Rule{{Source: "group1", Destination: "group2"}},
+				Delete: []models.Rule{{ID: "1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("keeps the changes in the database", func() {
+			Expect(db.Close()).To(Succeed())
+			open()
+
+			history, err := sqlStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Actor).To(Equal("alice"))
+			Expect(history[0].Action).To(Equal(models.ChangeAdd))
+			Expect(history[0].Time).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(history[1].Revision).To(Equal(int64(2)))
+			Expect(history[1].Actor).To(Equal("bob"))
+			Expect(history[1].Added).To(Equal([]models.Rule{{ID: "2", Source: "group1", Destination: "group2"}}))
+			Expect(history[1].Deleted).To(Equal([]models.Rule{{ID: "1", Source: "group0", Destination: "group1"}}))
+			Expect(history[1].RollbackTo).To(BeNil())
+		})
+
+		It("only keeps the most recent changes", func() {
+			sqlStore.HistorySize = 1
+			Expect(sqlStore.Delete(logger, "carol", models.Rule{ID: "2"})).To(Succeed())
+
+			history, err := sqlStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(1))
+			Expect(history[0].Revision).To(Equal(int64(3)))
+		})
+
+		It("rolls back to a revision", func() {
+			result, err := sqlStore.Rollback(logger, "dave", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 3,
+				Added:    []models.Rule{{ID: "3", Source: "group0", Destination: "group1"}},
+				Deleted:  []models.Rule{{ID: "2", Source: "group1", Destination: "group2"}},
+			}))
+
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{{ID: "3", Source: "group0", Destination: "group1"}}))
+
+			history, err := sqlStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history[2].Action).To(Equal(models.ChangeRollback))
+			Expect(*history[2].RollbackTo).To(Equal(int64(1)))
+
+			_, err = sqlStore.Rollback(logger, "dave", 4)
+			Expect(err).To(Equal(store.RevisionNotFoundError{Revision: 4}))
+		})
+	})
+
+	Describe("GetWhitelists", func() {
+		var whitelists []models.IngressWhitelist
+
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{
+				Source:      "group0",
+				Destination: "group1",
+			}))).To(Succeed())
+
+			var err error
+			whitelists, err = sqlStore.GetWhitelists(logger, []string{"group0", "group1", "some-other-group"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the whitelist for each requested group", func() {
+			Expect(whitelists).To(HaveLen(3))
+			Expect(whitelists[0].Destination.ID).To(Equal("group0"))
+			Expect(whitelists[1].Destination.ID).To(Equal("group1"))
+			Expect(whitelists[2].Destination.ID).To(Equal("some-other-group"))
+		})
+
+		It("returns distinct packet tags for each known group", func() {
+			Expect(whitelists[0].Destination.Tag).NotTo(BeNil())
+			Expect(whitelists[1].Destination.Tag).NotTo(BeNil())
+			Expect(*whitelists[0].Destination.Tag).NotTo(Equal(*whitelists[1].Destination.Tag))
+			Expect(whitelists[2].Destination.Tag).To(BeNil())
+		})
+
+		It("returns the AllowedSources for each destination", func() {
+			Expect(whitelists[0].AllowedSources).To(BeEmpty())
+			Expect(whitelists[1].AllowedSources).To(Equal([]models.AllowedSource{
+				{ID: "group0", Tag: whitelists[0].Destination.Tag},
+			}))
+			Expect(whitelists[2].AllowedSources).To(BeEmpty())
+		})
+
+		It("returns the whitelist of every destination group when no groups are given", func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group0"}))).To(Succeed())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group0"}))).To(Succeed())
+
+			all, err := sqlStore.GetWhitelists(logger, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(all).To(HaveLen(2))
+			Expect(all[0].Destination.ID).To(Equal("group0"))
+			Expect(all[0].AllowedSources).To(HaveLen(2))
+			Expect(all[1].Destination.ID).To(Equal("group1"))
+			Expect(all[1].AllowedSources).To(HaveLen(1))
+		})
+	})
+
+	Describe("WhitelistChanges", func() {
+		It("returns the changes to each destination since a revision in the history", func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			before, err := sqlStore.GetWhitelists(logger, []string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group1"}))).To(Succeed())
+			Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+
+			changes, err := sqlStore.WhitelistChanges(logger, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Revision).To(Equal(int64(3)))
+			Expect(changes.Changes).To(HaveLen(1))
+
+			now, err := sqlStore.GetWhitelists(logger, []string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(before[0].Apply(changes.Changes[0])).To(Equal(now[0]))
+
+			_, err = sqlStore.WhitelistChanges(logger, 4, nil)
+			Expect(err).To(MatchError(store.ResyncRequiredError{Since: 4}))
+		})
+	})
+
+	It("keeps a revision that every change bumps", func() {
+		changes := sqlStore.Changes()
+		Expect(sqlStore.Revision(logger)).To(Equal(int64(0)))
+
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+		Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).NotTo(Succeed())
+		Expect(sqlStore.Revision(logger)).To(Equal(int64(3)))
+		Expect(changes).To(BeClosed())
+
+		Expect(db.Close()).To(Succeed())
+		open()
+		Expect(sqlStore.Revision(logger)).To(Equal(int64(3)))
+	})
+
+	It("publishes changes with their revisions", func() {
+		broadcaster := events.NewBroadcaster(10, 10)
+		sqlStore.Publisher = broadcaster
+		sub := broadcaster.Subscribe("")
+
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+		sub.Close()
+
+		published := []string{}
+		for event := range sub.Events {
+			published = append(published, fmt.Sprintf("%d %s %s", event.Revision, event.Type, event.Group))
+		}
+		Expect(published).To(Equal([]string{
+			"1 rule-added ",
+			"1 tag-assigned group0",
+			"1 tag-assigned group1",
+			"2 rule-added ",
+			"2 tag-assigned group2",
+			"3 rule-deleted ",
+		}))
+	})
+
+	Describe("releasing tags", func() {
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		})
+
+		It("releases the tag of a group once no rule mentions it", func() {
+			Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group0", "group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(BeNil())
+			Expect(whitelists[1].Destination.Tag).NotTo(BeNil())
+
+			stats, err := tagger.Stats()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Freed).To(Equal(1))
+			Expect(stats.InUse).To(Equal(2))
+		})
+
+		It("releases the tags of groups that a batch leaves without rules, but not those it adds again", func() {
+			_, err := sqlStore.ApplyBatch(logger, "", models.Batch{
+				Delete: []models.Rule{{Source: "group0", Destination: "group1"}, {Source: "group1", Destination: "group2"}},
+				Add:    []models.Rule{{Source: "group2", Destination: "group3"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group0", "group1", "group2", "group3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(BeNil())
+			Expect(whitelists[1].Destination.Tag).To(BeNil())
+			Expect(whitelists[3].AllowedSources).To(Equal([]models.AllowedSource{
+				{ID: "group2", Tag: whitelists[2].Destination.Tag},
+			}))
+
+			stats, err := tagger.Stats()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Freed).To(Equal(2))
+			Expect(stats.InUse).To(Equal(2))
+		})
+	})
+
+	Describe("groups", func() {
+		var groupStore *store.SQLGroupStore
+
+		BeforeEach(func() {
+			groupStore = store.NewSQLGroupStore(sqlStore)
+			_, err := groupStore.CreateGroup(logger, "frontends")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "frontends"}))).To(Succeed())
+		})
+
+		It("adds and removes members, recording each change in the history", func() {
+			group, err := groupStore.AddMembers(logger, "alice", "frontends", []string{"app2", "app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{"app1", "app2"}}))
+
+			group, err = groupStore.RemoveMember(logger, "alice", "frontends", "app2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group).To(Equal(models.Group{Name: "frontends", Members: []string{"app1"}}))
+			Expect(sqlStore.Revision(logger)).To(Equal(int64(3)))
+
+			history, err := sqlStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(3))
+			Expect(history[1].Actor).To(Equal("alice"))
+			Expect(history[1].Action).To(Equal(models.ChangeGroup))
+			Expect(history[1].Group).To(Equal("frontends"))
+
+			memberships, err := groupStore.Memberships(logger, []string{"frontends", "app1", "app2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(memberships).To(Equal(map[string]store.Membership{
+				"frontends": {Members: []string{"app1"}, Revision: 3},
+				"app1":      {Group: "frontends", Revision: 2},
+			}))
+		})
+
+		It("refuses names that are taken", func() {
+			_, err := groupStore.CreateGroup(logger, "backends")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = groupStore.CreateGroup(logger, "frontends")
+			Expect(err).To(Equal(store.GroupConflictError{Name: "frontends"}))
+			_, err = groupStore.AddMembers(logger, "", "backends", []string{"app1"})
+			Expect(err).To(Equal(store.GroupConflictError{Name: "app1", Group: "frontends"}))
+			_, err = groupStore.RemoveMember(logger, "", "backends", "app1")
+			Expect(err).To(Equal(store.MemberNotFoundError{Name: "backends", Member: "app1"}))
+		})
+
+		It("refuses names that rules already use", func() {
+			_, err := groupStore.CreateGroup(logger, "group0")
+			Expect(err).To(Equal(store.GroupNameInUseError{Name: "group0"}))
+		})
+
+		It("keeps the groups when the database is reopened", func() {
+			_, err := groupStore.AddMembers(logger, "", "frontends", []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(db.Close()).To(Succeed())
+			open()
+			groupStore = store.NewSQLGroupStore(sqlStore)
+
+			groups, err := groupStore.ListGroups(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups).To(Equal([]models.Group{{Name: "frontends", Members: []string{"app1"}}}))
+
+			grouped := &store.GroupedWhitelists{Rules: sqlStore, Groups: groupStore}
+			whitelists, err := grouped.GetWhitelists(logger, []string{"app1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Group).To(Equal("frontends"))
+			Expect(whitelists[0].AllowedSources).To(HaveLen(1))
+		})
+	})
+
+	Context("when the database is reopened", func() {
+		var originalTags []*models.PacketTag
+		var releasedTag *models.PacketTag
+
+		BeforeEach(func() {
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(sqlStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			var err error
+			releasedTag, err = tagger.GetTag("group2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sqlStore.Delete(logger, "", models.Rule{Source: "group1", Destination: "group2"})).To(Succeed())
+
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group0", "group1", "group2"})
+			Expect(err).NotTo(HaveOccurred())
+			originalTags = nil
+			for _, w := range whitelists {
+				originalTags = append(originalTags, w.Destination.Tag)
+			}
+			Expect(originalTags[2]).To(BeNil())
+
+			Expect(db.Close()).To(Succeed())
+			open()
+		})
+
+		It("keeps the rules", func() {
+			rules, err := sqlStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+			}))
+		})
+
+		It("keeps the packet tags", func() {
+			whitelists, err := sqlStore.GetWhitelists(logger, []string{"group0", "group1", "group2"})
+			Expect(err).NotTo(HaveOccurred())
+			for i, w := range whitelists {
+				Expect(w.Destination.Tag).To(Equal(originalTags[i]))
+			}
+		})
+
+		It("does not reissue an existing tag to a new group", func() {
+			newTag, err := tagger.GetTag("group3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(originalTags).NotTo(ContainElement(newTag))
+			Expect(newTag).NotTo(Equal(releasedTag))
+
+			oldTag, err := tagger.GetTag("group1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(oldTag).To(Equal(originalTags[1]))
+		})
+	})
+
+	It("rejects unsupported drivers", func() {
+		_, err := store.NewDatabase("some-driver", "")
+		Expect(err).To(MatchError(`unsupported database driver: "some-driver"`))
+	})
+})
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"policy-server/models"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+)
+
+const maxTagAllocationAttempts = 5
+
+type sqlTagger struct {
+	TagBits    uint
+	Quarantine time.Duration
+
+	db        *Database
+	clock     clock.Clock
+	allocated int
+	freed     int
+	lock      sync.Mutex
+}
+
+func NewSQLTagger(db *Database, tagBits int, quarantine time.Duration, clock clock.Clock) (Tagger, error) {
+	return newSQLTagger(db, tagBits, quarantine, clock)
+}
+
+func newSQLTagger(db *Database, tagBits int, quarantine time.Duration, clock clock.Clock) (*sqlTagger, error) {
+	if err := validateTagBits(tagBits); err != nil {
+		return nil, err
+	}
+	return &sqlTagger{
+		TagBits:    uint(tagBits),
+		Quarantine: quarantine,
+		db:         db,
+		clock:      clock,
+	}, nil
+}
+
+func (t *sqlTagger) GetTag(groupID string) (*models.PacketTag, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for attempt := 0; attempt < maxTagAllocationAttempts; attempt++ {
+		tag, err := t.findOrAllocate(groupID)
+		if err != nil && isUniqueViolation(err) {
+			// another server allocated the same value first
+			continue
+		}
+		return tag, err
+	}
+	return nil, fmt.Errorf("allocate tag for %s: too many conflicts", groupID)
+}
+
+func (t *sqlTagger) findOrAllocate(groupID string) (*models.PacketTag, error) {
+	tx, err := t.db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin: %s", err)
+	}
+	defer tx.Rollback()
+
+	var value int64
+	var releasedAt sql.NullInt64
+	err = tx.QueryRow(t.db.rebind(`SELECT tag_value, released_at FROM packet_tags WHERE group_id = ?`), groupID).Scan(&value, &releasedAt)
+	if err == nil && !releasedAt.Valid {
+		return intToPacketTag(value, t.TagBits)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("select tag: %s", err)
+	}
+
+	if err == nil {
+		// the group is back during quarantine, so it keeps its old tag
+		_, err = tx.Exec(t.db.rebind(`UPDATE packet_tags SET released_at = NULL WHERE group_id = ?`), groupID)
+		if err != nil {
+			return nil, fmt.Errorf("reclaim tag: %s", err)
+		}
+	} else {
+		value, err = t.nextValue(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(t.db.rebind(`INSERT INTO packet_tags (group_id, tag_value) VALUES (?, ?)`), groupID, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newTag, err := intToPacketTag(value, t.TagBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %s", err)
+	}
+	t.allocated++
+	return newTag, nil
+}
+
+func (t *sqlTagger) nextValue(tx *sql.Tx) (int64, error) {
+	var value int64
+	var previousOwner string
+	cutoff := t.clock.Now().Add(-t.Quarantine).UnixNano()
+	err := tx.QueryRow(t.db.rebind(`
+		SELECT group_id, tag_value FROM packet_tags
+		WHERE released_at IS NOT NULL AND released_at <= ?
+		ORDER BY released_at LIMIT 1`), cutoff).Scan(&previousOwner, &value)
+	if err == nil {
+		_, err = tx.Exec(t.db.rebind(`DELETE FROM packet_tags WHERE group_id = ?`), previousOwner)
+		if err != nil {
+			return 0, fmt.Errorf("reuse tag: %s", err)
+		}
+		return value, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("select released tag: %s", err)
+	}
+
+	err = tx.QueryRow(`SELECT COALESCE(MAX(tag_value), 0) + 1 FROM packet_tags`).Scan(&value)
+	if err != nil {
+		return 0, fmt.Errorf("select next tag: %s", err)
+	}
+	return value, nil
+}
+
+func (t *sqlTagger) ReleaseTag(groupID string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result, err := t.db.conn.Exec(t.db.rebind(`UPDATE packet_tags SET released_at = ? WHERE group_id = ? AND released_at IS NULL`),
+		t.clock.Now().UnixNano(), groupID)
+	if err != nil {
+		return fmt.Errorf("release tag: %s", err)
+	}
+	released, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %s", err)
+	}
+	t.freed += int(released)
+	return nil
+}
+
+func (t *sqlTagger) selectTags(q queryer, groups []string) (map[string]*models.PacketTag, error) {
+	query := `SELECT group_id, tag_value FROM packet_tags WHERE released_at IS NULL`
+	var args []interface{}
+	if len(groups) > 0 {
+		var in string
+		in, args = inList(groups)
+		query += ` AND group_id IN ` + in
+	}
+
+	rows, err := q.Query(t.db.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("select tags: %s", err)
+	}
+	defer rows.Close()
+
+	tags := map[string]*models.PacketTag{}
+	for rows.Next() {
+		var groupID string
+		var value int64
+		if err := rows.Scan(&groupID, &value); err != nil {
+			return nil, fmt.Errorf("scan tag: %s", err)
+		}
+		tag, err := intToPacketTag(value, t.TagBits)
+		if err != nil {
+			return nil, fmt.Errorf("tag of %s: %s", groupID, err)
+		}
+		tags[groupID] = tag
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read tags: %s", err)
+	}
+	return tags, nil
+}
+
+func (t *sqlTagger) releaseUnreferenced(tx *sql.Tx, groups []string) ([]string, error) {
+	now := t.clock.Now().UnixNano()
+	released := []string{}
+	for _, group := range groups {
+		result, err := tx.Exec(t.db.rebind(`
+			UPDATE packet_tags SET released_at = ?
+			WHERE group_id = ? AND released_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM rules WHERE source = ? OR destination = ?)`),
+			now, group, group, group)
+		if err != nil {
+			return nil, fmt.Errorf("release tag: %s", err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("rows affected: %s", err)
+		}
+		if updated > 0 {
+			released = append(released, group)
+		}
+	}
+	return released, nil
+}
+
+func (t *sqlTagger) countFreed(freed int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.freed += freed
+}
+
+func (t *sqlTagger) Stats() (models.TagStats, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stats := models.TagStats{
+		Allocated: t.allocated,
+		Freed:     t.freed,
+		Capacity:  tagCapacity(t.TagBits),
+	}
+	err := t.db.conn.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN released_at IS NULL THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN released_at IS NULL THEN 0 ELSE 1 END), 0)
+		FROM packet_tags`).Scan(&stats.InUse, &stats.Quarantined)
+	if err != nil {
+		return models.TagStats{}, fmt.Errorf("count tags: %s", err)
+	}
+	return stats, nil
+}
@@ -1,85 +1,182 @@
 package store
 
 import (
-	"errors"
-	"fmt"
 	"policy-server/models"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 )
 
+type Store interface {
+	Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error)
+	Delete(logger lager.Logger, actor string, rule models.Rule) error
+	ApplyBatch(logger lager.Logger, actor string, batch models.Batch) (models.BatchResult, error)
+	Sync(logger lager.Logger, actor, owner string, rules []models.Rule, revision int64, dryRun bool) (models.BatchResult, error)
+	Rollback(logger lager.Logger, actor string, revision int64) (models.BatchResult, error)
+	History(logger lager.Logger) ([]models.Change, error)
+	List(logger lager.Logger) ([]models.Rule, error)
+	GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error)
+	WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error)
+	Revision(logger lager.Logger) (int64, error)
+	Changes() <-chan struct{}
+}
+
+type NotFoundError struct {
+	Rule models.Rule
+}
+
+func (e NotFoundError) NotFound() bool {
+	return true
+}
+
+func (e NotFoundError) Error() string {
+	return "not found"
+}
+
+// Publish is called with the store locked, so it must not block
+type Publisher interface {
+	Publish(events ...models.Event)
+}
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &SQLStore{}
+	_ Store = &FileStore{}
+)
+
 type MemoryStore struct {
-	Tagger Tagger
-	tags   map[string]*models.PacketTag
-	rules  []models.Rule
-	lock   sync.Mutex
+	changeNotifier
+
+	Tagger      Tagger
+	Publisher   Publisher
+	Clock       clock.Clock
+	HistorySize int
+	tags        map[string]*models.PacketTag
+	rules       []models.Rule
+	lastID      int
+	revision    int64
+	history     []models.Change
+	lock        sync.Mutex
+	published   atomic.Value
 }
 
 func NewMemoryStore(tagger Tagger) *MemoryStore {
 	return &MemoryStore{
-		Tagger: tagger,
-		tags:   make(map[string]*models.PacketTag),
+		Tagger:      tagger,
+		Clock:       clock.NewClock(),
+		HistorySize: DefaultHistorySize,
+		tags:        make(map[string]*models.PacketTag),
 	}
 }
 
-func (s *MemoryStore) GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error) {
-	all := make([]models.IngressWhitelist, len(groups))
+func (s *MemoryStore) index() *index {
+	if x, ok := s.published.Load().(*index); ok {
+		return x
+	}
+	return newIndex()
+}
 
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// caller must hold the lock
+func (s *MemoryStore) publish(x *index) {
+	x.seal(s.revision, s.tags)
+	s.published.Store(x)
+}
 
-	for i, destGroup := range groups {
-		all[i].Destination.ID = destGroup
+func (s *MemoryStore) GetWhitelists(logger lager.Logger, groups []string) ([]models.IngressWhitelist, error) {
+	x := s.index()
+	if len(groups) == 0 {
+		groups = x.destinations
+	}
+
+	all := make([]models.IngressWhitelist, len(groups))
+	for i, group := range groups {
 		var found bool
-		all[i].Destination.Tag, found = s.tags[destGroup]
+		all[i], found = x.whitelist(group)
 		if !found {
-			logger.Info("no-tag-found", lager.Data{"group": destGroup})
-			continue
-		}
-		for _, rule := range s.rules {
-			if rule.Destination != destGroup {
-				continue
-			}
-			all[i].AllowedSources = append(all[i].AllowedSources, models.TaggedGroup{
-				ID:  rule.Source,
-				Tag: s.tags[rule.Source],
-			})
+			logger.Info("no-tag-found", lager.Data{"group": group})
 		}
 	}
-	logger.Info("built-whitelist", lager.Data{"whitelist": all})
+	logger.Debug("built-whitelist", lager.Data{"whitelist": all})
 	return all, nil
 }
 
-func (s *MemoryStore) Add(logger lager.Logger, rule models.Rule) error {
+func (s *MemoryStore) Add(logger lager.Logger, actor string, rule models.Rule) (models.Rule, bool, error) {
 	logger = logger.Session("memory-store-add")
 	logger.Info("start")
 	defer logger.Info("done")
 
+	// a concurrent delete must not release a tag this rule is about to use
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if existing, ok := findRule(s.index().group(rule.Destination).to, rule); ok {
+		logger.Info("already-exists", lager.Data{"rule": existing})
+		return existing, false, nil
+	}
+
+	rule.ID = s.nextID()
+	if err := s.add(logger, s.newChange(actor, models.ChangeAdd), rule); err != nil {
+		return models.Rule{}, false, err
+	}
+	return rule, true, nil
+}
+
+func (s *MemoryStore) nextID() string {
+	return strconv.Itoa(s.lastID + 1)
+}
+
+func (s *MemoryStore) newChange(actor, action string) models.Change {
+	return models.Change{Time: s.Clock.Now(), Actor: actor, Action: action}
+}
+
+// caller must hold the lock
+func (s *MemoryStore) record(change models.Change) {
+	change.Revision = s.revision
+	s.history = trimHistory(append(s.history, change), s.HistorySize)
+}
+
+// caller must hold the lock
+func (s *MemoryStore) add(logger lager.Logger, change models.Change, rule models.Rule) error {
 	g1Tag, err := s.Tagger.GetTag(rule.Source)
 	if err != nil {
 		logger.Error("get-tag", err, lager.Data{"group": rule.Source})
-		return fmt.Errorf("get tag: %s", err)
+		return err
 	}
 
 	g2Tag, err := s.Tagger.GetTag(rule.Destination)
 	if err != nil {
 		logger.Error("get-tag", err, lager.Data{"group": rule.Destination})
-		return fmt.Errorf("get tag: %s", err)
+		s.releaseUnreferenced(logger, s.index(), rule.Source)
+		return err
 	}
 
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	newTags := map[string]*models.PacketTag{}
+	for group, tag := range map[string]*models.PacketTag{rule.Source: g1Tag, rule.Destination: g2Tag} {
+		if _, ok := s.tags[group]; !ok {
+			newTags[group] = tag
+		}
+	}
 
 	s.rules = append(s.rules, rule)
+	next := s.index().update(s.rules, []models.Rule{rule}, nil)
+	s.trackID(rule.ID)
 	s.tags[rule.Source] = g1Tag
 	s.tags[rule.Destination] = g2Tag
+	s.revision++
+	change.Added = []models.Rule{rule}
+	s.record(change)
+	s.publish(next)
+	s.notify()
+	publish(s.Publisher, ruleAddedEvents(s.revision, rule, newTags)...)
 	logger.Info("added", lager.Data{"rule": rule, "group1-tag": g1Tag, "group2-tag": g2Tag})
 
 	return nil
 }
 
-func (s *MemoryStore) Delete(logger lager.Logger, rule models.Rule) error {
+func (s *MemoryStore) Delete(logger lager.Logger, actor string, rule models.Rule) error {
 	logger = logger.Session("memory-store-delete")
 	logger.Info("start")
 	defer logger.Info("done")
@@ -87,34 +184,217 @@ func (s *MemoryStore) Delete(logger lager.Logger, rule models.Rule) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	newRules := []models.Rule{}
+	return s.remove(logger, s.newChange(actor, models.ChangeDelete), rule)
+}
 
-	for _, r := range s.rules {
-		if !rule.Equals(r) {
-			newRules = append(newRules, r)
-		}
+// caller must hold the lock
+func (s *MemoryStore) remove(logger lager.Logger, change models.Change, rule models.Rule) error {
+	newRules, deleted := removeMatching(s.rules, rule)
+	if len(deleted) == 0 {
+		return NotFoundError{Rule: rule}
 	}
 
-	if len(newRules) == len(s.rules) {
-		return errors.New("not found")
+	s.rules = newRules
+	next := s.index().update(s.rules, nil, deleted)
+	s.releaseUnreferenced(logger, next, deleted[0].Source, deleted[0].Destination)
+	s.revision++
+	change.Deleted = deleted
+	s.record(change)
+	s.publish(next)
+	s.notify()
+	for _, r := range deleted {
+		publish(s.Publisher, ruleDeletedEvent(s.revision, r))
 	}
 
-	s.rules = newRules
+	logger.Info("deleted", lager.Data{"rules": deleted})
+	return nil
+}
+
+func (s *MemoryStore) createGroup(logger lager.Logger, name string, changed map[string]Membership, commit func(map[string]Membership)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	logger.Info("deleted", lager.Data{"rule": rule})
+	if err := s.unreferenced(name); err != nil {
+		return err
+	}
+	commit(changed)
 	return nil
 }
 
+func (s *MemoryStore) changeGroup(logger lager.Logger, change models.Change, joined []string, changed map[string]Membership, commit func(map[string]Membership)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.unreferenced(joined...); err != nil {
+		return err
+	}
+	stampMemberships(changed, s.revision+1)
+	commit(changed)
+	s.commitGroupChange(logger, change)
+	return nil
+}
+
+// a group takes over the rules of its members, so rules may not name them yet
+func (s *MemoryStore) unreferenced(names ...string) error {
+	x := s.index()
+	for _, name := range names {
+		if x.references(name) {
+			return GroupNameInUseError{Name: name}
+		}
+	}
+	return nil
+}
+
+// caller must hold the lock
+func (s *MemoryStore) commitGroupChange(logger lager.Logger, change models.Change) {
+	s.revision++
+	s.record(change)
+	s.publish(s.index().update(s.rules, nil, nil))
+	s.notify()
+	logger.Info("changed-group", lager.Data{"group": change.Group, "revision": s.revision})
+}
+
+func (s *MemoryStore) trackID(id string) {
+	s.lastID = laterID(s.lastID, id)
+}
+
+func laterID(last int, id string) int {
+	if n, err := strconv.Atoi(id); err == nil && n > last {
+		return n
+	}
+	return last
+}
+
+func removeMatching(rules []models.Rule, request models.Rule) ([]models.Rule, []models.Rule) {
+	kept := make([]models.Rule, 0, len(rules))
+	removed := []models.Rule{}
+	for _, r := range rules {
+		if deleteMatches(request, r) {
+			removed = append(removed, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	return kept, removed
+}
+
+func deleteMatches(request, rule models.Rule) bool {
+	if request.ID != "" {
+		return request.ID == rule.ID
+	}
+	return request.Equals(rule)
+}
+
+// caller must hold the lock
+func (s *MemoryStore) releaseUnreferenced(logger lager.Logger, x *index, groups ...string) {
+	released := map[string]bool{}
+	for _, group := range groups {
+		if x.references(group) || released[group] {
+			continue
+		}
+		released[group] = true
+		delete(s.tags, group)
+		if err := s.Tagger.ReleaseTag(group); err != nil {
+			logger.Error("release-tag", err, lager.Data{"group": group})
+			continue
+		}
+		logger.Info("released-tag", lager.Data{"group": group})
+	}
+}
+
+func unreferencedGroups(rules []models.Rule, candidates ...string) []string {
+	unreferenced := []string{}
+	for _, group := range candidates {
+		found := false
+		for _, r := range rules {
+			if r.Source == group || r.Destination == group {
+				found = true
+				break
+			}
+		}
+		for _, u := range unreferenced {
+			if u == group {
+				found = true
+			}
+		}
+		if !found {
+			unreferenced = append(unreferenced, group)
+		}
+	}
+	return unreferenced
+}
+
+func (s *MemoryStore) WhitelistChanges(logger lager.Logger, since int64, groups []string) (models.WhitelistChanges, error) {
+	logger = logger.Session("memory-store-whitelist-changes", lager.Data{"since": since})
+	logger.Info("start")
+	defer logger.Info("done")
+
+	s.lock.Lock()
+	x, history := s.index(), s.history
+	s.lock.Unlock()
+
+	return whitelistChanges(x.rules, x.revision, history, since, groups, func(group string) (*models.PacketTag, error) {
+		return x.group(group).tag, nil
+	})
+}
+
 func (s *MemoryStore) List(logger lager.Logger) ([]models.Rule, error) {
 	logger = logger.Session("memory-store-list")
 	logger.Info("start")
 	defer logger.Info("done")
 
+	rules := s.index().rules
+	toReturn := make([]models.Rule, len(rules))
+	copy(toReturn, rules)
+
+	return toReturn, nil
+}
+
+func (s *MemoryStore) Revision(logger lager.Logger) (int64, error) {
+	return s.index().revision, nil
+}
+
+func (s *MemoryStore) History(logger lager.Logger) ([]models.Change, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	toReturn := make([]models.Rule, len(s.rules))
-	copy(toReturn, s.rules)
+	history := make([]models.Change, len(s.history))
+	copy(history, s.history)
+	return history, nil
+}
 
-	return toReturn, nil
+func publish(publisher Publisher, events ...models.Event) {
+	if publisher != nil {
+		publisher.Publish(events...)
+	}
+}
+
+func ruleAddedEvents(revision int64, rule models.Rule, newTags map[string]*models.PacketTag) []models.Event {
+	events := []models.Event{{
+		Type:     models.EventRuleAdded,
+		Revision: revision,
+		Rule:     &rule,
+	}}
+	for _, group := range []string{rule.Source, rule.Destination} {
+		tag, ok := newTags[group]
+		if !ok {
+			continue
+		}
+		delete(newTags, group)
+		events = append(events, models.Event{
+			Type:     models.EventTagAssigned,
+			Revision: revision,
+			Group:    group,
+			Tag:      tag,
+		})
+	}
+	return events
+}
+
+func ruleDeletedEvent(revision int64, rule models.Rule) models.Event {
+	return models.Event{
+		Type:     models.EventRuleDeleted,
+		Revision: revision,
+		Rule:     &rule,
+	}
 }
@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"fmt"
+	"policy-server/models"
+	"policy-server/store"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	benchmarkRules  = 100000
+	benchmarkGroups = 10000
+)
+
+func benchmarkStore(b *testing.B) *store.MemoryStore {
+	tagger, err := store.NewMemoryTagger(32, time.Minute, clock.NewClock())
+	if err != nil {
+		b.Fatal(err)
+	}
+	memStore := store.NewMemoryStore(tagger)
+	memStore.HistorySize = 1
+
+	batch := models.Batch{}
+	for i := 0; i < benchmarkRules; i++ {
+		batch.Add = append(batch.Add, models.Rule{
+			Source:      fmt.Sprintf("group%d", (i*7+i/benchmarkGroups)%benchmarkGroups),
+			Destination: fmt.Sprintf("group%d", i%benchmarkGroups),
+			Protocol:    models.ProtocolTCP,
+			StartPort:   8080,
+			EndPort:     8080,
+		})
+	}
+	if _, err := memStore.ApplyBatch(lager.NewLogger("bench"), "", batch); err != nil {
+		b.Fatal(err)
+	}
+	return memStore
+}
+
+func BenchmarkGetWhitelists(b *testing.B) {
+	memStore := benchmarkStore(b)
+	logger := lager.NewLogger("bench")
+
+	for _, count := range []int{1, 10} {
+		b.Run(fmt.Sprintf("%d groups", count), func(b *testing.B) {
+			groups := []string{}
+			for i := 0; i < count; i++ {
+				groups = append(groups, fmt.Sprintf("group%d", i*13))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				whitelists, err := memStore.GetWhitelists(logger, groups)
+				if err != nil || len(whitelists[0].AllowedSources) == 0 {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	b.Run("every group", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			whitelists, err := memStore.GetWhitelists(logger, nil)
+			if err != nil || len(whitelists) != benchmarkGroups {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetWhitelistsWhileWriting(b *testing.B) {
+	memStore := benchmarkStore(b)
+	logger := lager.NewLogger("bench")
+
+	var writes int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rule := models.Rule{Source: "writer", Destination: "group1"}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, _, err := memStore.Add(logger, "", rule); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := memStore.Delete(logger, "", rule); err != nil {
+				b.Error(err)
+				return
+			}
+			atomic.AddInt64(&writes, 2)
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := memStore.GetWhitelists(logger, []string{"group1"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	<-done
+	b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+}
+
+func BenchmarkAddAndDelete(b *testing.B) {
+	memStore := benchmarkStore(b)
+	logger := lager.NewLogger("bench")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rule := models.Rule{Source: "new-group", Destination: fmt.Sprintf("group%d", i%benchmarkGroups)}
+		if _, _, err := memStore.Add(logger, "", rule); err != nil {
+			b.Fatal(err)
+		}
+		if err := memStore.Delete(logger, "", rule); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package store_test
 
 import (
+	"policy-server/models"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -11,3 +13,7 @@ func TestStore(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Store Suite")
 }
+
+func added(_ models.Rule, _ bool, err error) error {
+	return err
+}
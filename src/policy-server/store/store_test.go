@@ -1,12 +1,16 @@
 package store_test
 
 import (
+	"errors"
+	"policy-server/events"
 	"policy-server/fakes"
 	"policy-server/models"
 	"policy-server/store"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
 	"github.com/pivotal-golang/lager/lagertest"
 )
 
@@ -28,17 +32,510 @@ var _ = Describe("Store", func() {
 	})
 
 	Describe("tagging", func() {
-		It("gets a tag for each rule", func() {
-			Expect(memStore.Add(logger, models.Rule{
+		It("gets a tag for each new rule", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{
 				Source:      "group0",
 				Destination: "group1",
-			})).To(Succeed())
+			}))).To(Succeed())
 			Expect(tagCallCount).To(Equal(2))
-			Expect(memStore.Add(logger, models.Rule{
+			Expect(added(memStore.Add(logger, "", models.Rule{
 				Source:      "group0",
 				Destination: "group1",
-			})).To(Succeed())
-			Expect(tagCallCount).To(Equal(4))
+			}))).To(Succeed())
+			Expect(tagCallCount).To(Equal(2))
+		})
+	})
+
+	Describe("rule IDs", func() {
+		It("gives each new rule an ID and returns the existing rule when it is added again", func() {
+			rule, created, err := memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(rule.ID).To(Equal("1"))
+
+			again, created, err := memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(again).To(Equal(rule))
+			Expect(memStore.Revision(logger)).To(Equal(int64(1)))
+
+			other, created, err := memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(other.ID).To(Equal("2"))
+		})
+
+		It("deletes a rule by its ID", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			kept, _, err := memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(memStore.Delete(logger, "", models.Rule{ID: "1"})).To(Succeed())
+			Expect(memStore.Delete(logger, "", models.Rule{ID: "1"})).To(MatchError("not found"))
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{kept}))
+		})
+	})
+
+	Describe("releasing tags", func() {
+		var released []string
+
+		BeforeEach(func() {
+			released = []string{}
+			tagger.ReleaseTagStub = func(groupID string) error {
+				released = append(released, groupID)
+				return nil
+			}
+
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		})
+
+		It("releases the tag of a group once no rule mentions it", func() {
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+			Expect(released).To(Equal([]string{"group0"}))
+
+			whitelists, err := memStore.GetWhitelists(logger, []string{"group0", "group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(BeNil())
+			Expect(whitelists[1].Destination.Tag).NotTo(BeNil())
+
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group1", Destination: "group2"})).To(Succeed())
+			Expect(released).To(ConsistOf("group0", "group1", "group2"))
+		})
+
+		It("releases a group that only allowed itself exactly once", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group3", Destination: "group3"}))).To(Succeed())
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group3", Destination: "group3"})).To(Succeed())
+			Expect(released).To(Equal([]string{"group3"}))
+		})
+
+		Context("when tagging the destination fails", func() {
+			BeforeEach(func() {
+				tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+					if groupID == "group4" {
+						return nil, errors.New("exhausted")
+					}
+					return models.PT(groupID + "-tag"), nil
+				}
+			})
+
+			It("releases the tag it just gave to a new source", func() {
+				Expect(added(memStore.Add(logger, "", models.Rule{Source: "group3", Destination: "group4"}))).NotTo(Succeed())
+				Expect(released).To(Equal([]string{"group3"}))
+			})
+		})
+	})
+
+	Describe("revisions", func() {
+		It("bumps the revision and notifies watchers on every change", func() {
+			Expect(memStore.Revision(logger)).To(Equal(int64(0)))
+			changes := memStore.Changes()
+
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(memStore.Revision(logger)).To(Equal(int64(1)))
+			Expect(changes).To(BeClosed())
+
+			changes = memStore.Changes()
+			Expect(changes).NotTo(BeClosed())
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+			Expect(memStore.Revision(logger)).To(Equal(int64(2)))
+			Expect(changes).To(BeClosed())
+		})
+
+		It("leaves the revision alone when nothing changes", func() {
+			changes := memStore.Changes()
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).NotTo(Succeed())
+			Expect(memStore.Revision(logger)).To(Equal(int64(0)))
+			Expect(changes).NotTo(BeClosed())
+		})
+	})
+
+	Describe("events", func() {
+		var sub *events.Subscription
+
+		receive := func() models.Event {
+			var event models.Event
+			Expect(sub.Events).To(Receive(&event))
+			return event
+		}
+
+		BeforeEach(func() {
+			broadcaster := events.NewBroadcaster(10, 10)
+			memStore.Publisher = broadcaster
+			sub = broadcaster.Subscribe("")
+		})
+
+		It("publishes added rules and newly assigned tags", func() {
+			rule := models.Rule{Source: "group0", Destination: "group1"}
+			rule, _, err := memStore.Add(logger, "", rule)
+			Expect(err).NotTo(HaveOccurred())
+
+			event := receive()
+			Expect(event.Type).To(Equal(models.EventRuleAdded))
+			Expect(event.Revision).To(Equal(int64(1)))
+			Expect(*event.Rule).To(Equal(rule))
+
+			event = receive()
+			Expect(event.Type).To(Equal(models.EventTagAssigned))
+			Expect(event.Revision).To(Equal(int64(1)))
+			Expect(event.Group).To(Equal("group0"))
+			Expect(event.Tag).To(Equal(models.PT("group0-tag")))
+
+			Expect(receive().Group).To(Equal("group1"))
+
+			By("not repeating tags that groups already have")
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+			Expect(receive().Type).To(Equal(models.EventRuleAdded))
+			Expect(receive().Group).To(Equal("group2"))
+			Expect(sub.Events).NotTo(Receive())
+		})
+
+		It("publishes deleted rules", func() {
+			rule := models.Rule{Source: "group0", Destination: "group1"}
+			rule, _, err := memStore.Add(logger, "", rule)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(memStore.Delete(logger, "", rule)).To(Succeed())
+
+			receive()
+			receive()
+			receive()
+			event := receive()
+			Expect(event.Type).To(Equal(models.EventRuleDeleted))
+			Expect(event.Revision).To(Equal(int64(2)))
+			Expect(*event.Rule).To(Equal(rule))
+		})
+	})
+
+	Describe("ApplyBatch", func() {
+		var released []string
+
+		BeforeEach(func() {
+			released = []string{}
+			tagger.ReleaseTagStub = func(groupID string) error {
+				released = append(released, groupID)
+				return nil
+			}
+
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2"}))).To(Succeed())
+		})
+
+		It("applies every change under a single revision", func() {
+			result, err := memStore.ApplyBatch(logger, "", models.Batch{
+				Add: []models.Rule{
+					{Source: "group2", Destination: "group3"},
+					{Source: "group0", Destination: "group1"},
+					{Source: "group2", Destination: "group3"},
+				},
+				Delete: []models.Rule{{ID: "2"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 3,
+				Added:    []models.Rule{{ID: "3", Source: "group2", Destination: "group3"}},
+				Deleted:  []models.Rule{{ID: "2", Source: "group1", Destination: "group2"}},
+			}))
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+				{ID: "3", Source: "group2", Destination: "group3"},
+			}))
+			Expect(released).To(BeEmpty())
+
+			whitelists, err := memStore.GetWhitelists(logger, []string{"group3"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].Destination.Tag).To(Equal(models.PT("group3-tag")))
+		})
+
+		It("releases the tags of groups that no rule mentions any more", func() {
+			_, err := memStore.ApplyBatch(logger, "", models.Batch{
+				Delete: []models.Rule{{Source: "group1", Destination: "group2"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(released).To(Equal([]string{"group2"}))
+		})
+
+		It("does not bump the revision when nothing changes", func() {
+			result, err := memStore.ApplyBatch(logger, "", models.Batch{
+				Add: []models.Rule{{Source: "group0", Destination: "group1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Revision).To(Equal(int64(2)))
+			Expect(result.Added).To(BeEmpty())
+		})
+
+		It("changes nothing when a rule to delete does not exist", func() {
+			_, err := memStore.ApplyBatch(logger, "", models.Batch{
+				Add:    []models.Rule{{Source: "group2", Destination: "group3"}},
+				Delete: []models.Rule{{ID: "2"}, {Source: "group3", Destination: "group4"}},
+			})
+			Expect(err).To(BeAssignableToTypeOf(store.NotFoundError{}))
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(2))
+			Expect(memStore.Revision(logger)).To(Equal(int64(2)))
+		})
+
+		Context("when tagging a group fails", func() {
+			BeforeEach(func() {
+				tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+					if groupID == "group5" {
+						return nil, errors.New("exhausted")
+					}
+					return models.PT(groupID + "-tag"), nil
+				}
+			})
+
+			It("changes nothing and releases the tags it just gave out", func() {
+				_, err := memStore.ApplyBatch(logger, "", models.Batch{
+					Add: []models.Rule{
+						{Source: "group2", Destination: "group3"},
+						{Source: "group4", Destination: "group5"},
+					},
+					Delete: []models.Rule{{ID: "1"}},
+				})
+				Expect(err).To(MatchError("exhausted"))
+				Expect(released).To(ConsistOf("group3", "group4"))
+
+				rules, err := memStore.List(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).To(HaveLen(2))
+				Expect(memStore.Revision(logger)).To(Equal(int64(2)))
+			})
+		})
+
+		It("publishes the changes with the batch revision", func() {
+			broadcaster := events.NewBroadcaster(10, 10)
+			memStore.Publisher = broadcaster
+			sub := broadcaster.Subscribe("")
+
+			_, err := memStore.ApplyBatch(logger, "", models.Batch{
+				Add:    []models.Rule{{Source: "group2", Destination: "group3"}},
+				Delete: []models.Rule{{ID: "1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			types := []string{}
+			for i := 0; i < 3; i++ {
+				var event models.Event
+				Expect(sub.Events).To(Receive(&event))
+				Expect(event.Revision).To(Equal(int64(3)))
+				types = append(types, event.Type)
+			}
+			Expect(types).To(Equal([]string{
+				models.EventRuleDeleted,
+				models.EventRuleAdded,
+				models.EventTagAssigned,
+			}))
+			Expect(sub.Events).NotTo(Receive())
+		})
+	})
+
+	Describe("Sync", func() {
+		BeforeEach(func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1", Owner: "team-a"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2", Owner: "team-a"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group2", Owner: "team-b"}))).To(Succeed())
+		})
+
+		desired := []models.Rule{
+			{Source: "group0", Destination: "group1"},
+			{Source: "group2", Destination: "group3"},
+		}
+
+		It("replaces the rules of the owner and leaves the other rules alone", func() {
+			result, err := memStore.Sync(logger, "", "team-a", desired, models.AnyRevision, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 5,
+				Added:    []models.Rule{{ID: "5", Source: "group2", Destination: "group3", Owner: "team-a"}},
+				Deleted:  []models.Rule{{ID: "3", Source: "group1", Destination: "group2", Owner: "team-a"}},
+			}))
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{
+				{ID: "1", Source: "group0", Destination: "group1"},
+				{ID: "2", Source: "group0", Destination: "group1", Owner: "team-a"},
+				{ID: "4", Source: "group1", Destination: "group2", Owner: "team-b"},
+				{ID: "5", Source: "group2", Destination: "group3", Owner: "team-a"},
+			}))
+
+			By("changing nothing when synced again")
+			result, err = memStore.Sync(logger, "", "team-a", desired, models.AnyRevision, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{Revision: 5, Added: []models.Rule{}, Deleted: []models.Rule{}}))
+		})
+
+		It("refuses to sync when the rules changed since the given revision", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group3", Destination: "group4", Owner: "team-a"}))).To(Succeed())
+
+			_, err := memStore.Sync(logger, "", "team-a", desired, 4, false)
+			Expect(err).To(Equal(store.RevisionChangedError{Expected: 4, Current: 5}))
+			Expect(err.(store.RevisionChangedError).Conflict()).To(BeTrue())
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(5))
+
+			_, err = memStore.Sync(logger, "", "team-a", desired, 5, false)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("only previews the changes on a dry run", func() {
+			result, err := memStore.Sync(logger, "", "team-a", desired, models.AnyRevision, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(models.BatchResult{
+				Revision: 4,
+				Added:    []models.Rule{{Source: "group2", Destination: "group3", Owner: "team-a"}},
+				Deleted:  []models.Rule{{ID: "3", Source: "group1", Destination: "group2", Owner: "team-a"}},
+			}))
+
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(HaveLen(4))
+			Expect(memStore.Revision(logger)).To(Equal(int64(4)))
+		})
+
+		It("lists traffic allowed by several owners once in the whitelist", func() {
+			whitelists, err := memStore.GetWhitelists(logger, []string{"group1", "group2"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].AllowedSources).To(HaveLen(1))
+			Expect(whitelists[1].AllowedSources).To(HaveLen(1))
+		})
+	})
+
+	Describe("history", func() {
+		var fakeClock *fakeclock.FakeClock
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+			memStore.Clock = fakeClock
+
+			Expect(added(memStore.Add(logger, "alice", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+			fakeClock.Increment(time.Minute)
+			_, err := memStore.ApplyBatch(logger, "bob", models.Batch{
+				Add:    []models.Rule{{Source: "group1", Destination: "group2"}},
+				Delete: []models.Rule{{ID: "1"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(memStore.Delete(logger, "carol", models.Rule{ID: "2"})).To(Succeed())
+		})
+
+		It("records who made each change, when, and what it changed", func() {
+			history, err := memStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(Equal([]models.Change{
+				{
+					Revision: 1,
+					Time:     time.Unix(1000, 0),
+					Actor:    "alice",
+					Action:   models.ChangeAdd,
+					Added:    []models.Rule{{ID: "1", Source: "group0", Destination: "group1"}},
+				},
+				{
+					Revision: 2,
+					Time:     time.Unix(1060, 0),
+					Actor:    "bob",
+					Action:   models.ChangeBatch,
+					Added:    []models.Rule{{ID: "2", Source: "group1", Destination: "group2"}},
+					Deleted:  []models.Rule{{ID: "1", Source: "group0", Destination: "group1"}},
+				},
+				{
+					Revision: 3,
+					Time:     time.Unix(1060, 0),
+					Actor:    "carol",
+					Action:   models.ChangeDelete,
+					Deleted:  []models.Rule{{ID: "2", Source: "group1", Destination: "group2"}},
+				},
+			}))
+		})
+
+		It("only keeps the most recent changes", func() {
+			memStore.HistorySize = 2
+			Expect(added(memStore.Add(logger, "alice", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+
+			history, err := memStore.History(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Revision).To(Equal(int64(3)))
+			Expect(history[1].Revision).To(Equal(int64(4)))
+		})
+
+		Describe("Rollback", func() {
+			It("restores the rules as they were, under a new revision", func() {
+				result, err := memStore.Rollback(logger, "dave", 1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(models.BatchResult{
+					Revision: 4,
+					Added:    []models.Rule{{ID: "3", Source: "group0", Destination: "group1"}},
+					Deleted:  []models.Rule{},
+				}))
+
+				rules, err := memStore.List(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rules).To(Equal([]models.Rule{{ID: "3", Source: "group0", Destination: "group1"}}))
+
+				history, err := memStore.History(logger)
+				Expect(err).NotTo(HaveOccurred())
+				rolledBack := int64(1)
+				Expect(history[3]).To(Equal(models.Change{
+					Revision:   4,
+					Time:       time.Unix(1060, 0),
+					Actor:      "dave",
+					Action:     models.ChangeRollback,
+					RollbackTo: &rolledBack,
+					Added:      result.Added,
+					Deleted:    result.Deleted,
+				}))
+			})
+
+			It("leaves rules that are in both revisions alone", func() {
+				Expect(added(memStore.Add(logger, "alice", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+
+				result, err := memStore.Rollback(logger, "dave", 2)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Added).To(Equal([]models.Rule{{ID: "4", Source: "group1", Destination: "group2"}}))
+				Expect(result.Deleted).To(Equal([]models.Rule{{ID: "3", Source: "group0", Destination: "group1"}}))
+			})
+
+			It("can undo a rollback", func() {
+				_, err := memStore.Rollback(logger, "dave", 1)
+				Expect(err).NotTo(HaveOccurred())
+
+				result, err := memStore.Rollback(logger, "dave", 3)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Revision).To(Equal(int64(5)))
+				Expect(memStore.List(logger)).To(BeEmpty())
+			})
+
+			It("changes nothing when rolling back to the current revision", func() {
+				result, err := memStore.Rollback(logger, "dave", 3)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(models.BatchResult{Revision: 3, Added: []models.Rule{}, Deleted: []models.Rule{}}))
+				Expect(memStore.History(logger)).To(HaveLen(3))
+			})
+
+			It("rejects revisions that are not in the history", func() {
+				_, err := memStore.Rollback(logger, "dave", 4)
+				Expect(err).To(Equal(store.RevisionNotFoundError{Revision: 4}))
+
+				memStore.HistorySize = 1
+				Expect(added(memStore.Add(logger, "alice", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+				_, err = memStore.Rollback(logger, "dave", 2)
+				Expect(err).To(Equal(store.RevisionNotFoundError{Revision: 2}))
+				Expect(err.(store.RevisionNotFoundError).NotFound()).To(BeTrue())
+
+				_, err = memStore.Rollback(logger, "dave", 3)
+				Expect(err).NotTo(HaveOccurred())
+			})
 		})
 	})
 
@@ -46,10 +543,10 @@ var _ = Describe("Store", func() {
 		var whitelists []models.IngressWhitelist
 
 		BeforeEach(func() {
-			Expect(memStore.Add(logger, models.Rule{
+			Expect(added(memStore.Add(logger, "", models.Rule{
 				Source:      "group0",
 				Destination: "group1",
-			})).To(Succeed())
+			}))).To(Succeed())
 
 			var err error
 			whitelists, err = memStore.GetWhitelists(logger, []string{"group0", "group1"})
@@ -71,7 +568,7 @@ var _ = Describe("Store", func() {
 		It("returns the AllowedSourceTags for each destination", func() {
 			Expect(whitelists[0].AllowedSources).To(HaveLen(0))
 			Expect(whitelists[1].AllowedSources).To(HaveLen(1))
-			Expect(whitelists[1].AllowedSources).To(ContainElement(BeEquivalentTo(models.TaggedGroup{
+			Expect(whitelists[1].AllowedSources).To(ContainElement(BeEquivalentTo(models.AllowedSource{
 				ID:  "group0",
 				Tag: models.PT("group0-tag"),
 			})))
@@ -95,5 +592,174 @@ var _ = Describe("Store", func() {
 				Expect(*whitelists[2].Destination.Tag).To(BeEquivalentTo([]byte("group0-tag")))
 			})
 		})
+
+		Context("when no groups are given", func() {
+			It("returns the whitelist of every destination group, in order", func() {
+				Expect(added(memStore.Add(logger, "", models.Rule{Source: "group1", Destination: "group0"}))).To(Succeed())
+				Expect(added(memStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group0"}))).To(Succeed())
+
+				all, err := memStore.GetWhitelists(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(all).To(HaveLen(2))
+				Expect(all[0].Destination.ID).To(Equal("group0"))
+				Expect(all[0].AllowedSources).To(HaveLen(2))
+				Expect(all[1].Destination.ID).To(Equal("group1"))
+				Expect(all[1].AllowedSources).To(HaveLen(1))
+			})
+
+			It("leaves out groups that are no longer the destination of a rule", func() {
+				Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+
+				all, err := memStore.GetWhitelists(logger, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(all).To(BeEmpty())
+
+				whitelists, err := memStore.GetWhitelists(logger, []string{"group1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(whitelists[0].Destination.Tag).To(BeNil())
+			})
+		})
+
+		It("is not changed by changes to the whitelists it returned", func() {
+			whitelists[1].AllowedSources[0].ID = "changed"
+
+			again, err := memStore.GetWhitelists(logger, []string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again[0].AllowedSources[0].ID).To(Equal("group0"))
+		})
+	})
+
+	Describe("WhitelistChanges", func() {
+		BeforeEach(func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+		})
+
+		It("returns the sources added to and removed from each destination since the revision", func() {
+			before, err := memStore.GetWhitelists(logger, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group1"}))).To(Succeed())
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{
+				Source: "group0", Destination: "group3", Protocol: "tcp", StartPort: 80, EndPort: 80,
+			}))).To(Succeed())
+
+			changes, err := memStore.WhitelistChanges(logger, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(Equal(models.WhitelistChanges{
+				Since:    1,
+				Revision: 4,
+				Changes: []models.WhitelistChange{
+					{
+						Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("group1-tag")},
+						Added:       []models.AllowedSource{{ID: "group2", Tag: models.PT("group2-tag")}},
+						Removed:     []models.AllowedSource{{ID: "group0"}},
+					},
+					{
+						Destination: models.TaggedGroup{ID: "group3", Tag: models.PT("group3-tag")},
+						Added: []models.AllowedSource{{
+							ID: "group0", Tag: models.PT("group0-tag"), Protocol: "tcp", StartPort: 80, EndPort: 80,
+						}},
+						Removed: []models.AllowedSource{},
+					},
+				},
+			}))
+
+			now, err := memStore.GetWhitelists(logger, nil)
+			Expect(err).NotTo(HaveOccurred())
+			applied := []models.IngressWhitelist{before[0].Apply(changes.Changes[0]), models.IngressWhitelist{}.Apply(changes.Changes[1])}
+			Expect(applied).To(Equal(now))
+		})
+
+		It("only returns the changes to the requested groups", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group1"}))).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group3"}))).To(Succeed())
+
+			changes, err := memStore.WhitelistChanges(logger, 1, []string{"group3", "group4"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Changes).To(HaveLen(1))
+			Expect(changes.Changes[0].Destination.ID).To(Equal("group3"))
+		})
+
+		It("leaves out changes that undo each other", func() {
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group2", Destination: "group1"}))).To(Succeed())
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group2", Destination: "group1"})).To(Succeed())
+
+			changes, err := memStore.WhitelistChanges(logger, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes).To(Equal(models.WhitelistChanges{Since: 1, Revision: 3, Changes: []models.WhitelistChange{}}))
+		})
+
+		It("removes and adds again the sources of groups that went away and came back", func() {
+			tagger.GetTagStub = func(groupID string) (*models.PacketTag, error) {
+				return models.PT(groupID + "-new-tag"), nil
+			}
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1"})).To(Succeed())
+			Expect(added(memStore.Add(logger, "", models.Rule{Source: "group0", Destination: "group1"}))).To(Succeed())
+
+			changes, err := memStore.WhitelistChanges(logger, 1, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Changes).To(Equal([]models.WhitelistChange{{
+				Destination: models.TaggedGroup{ID: "group1", Tag: models.PT("group1-new-tag")},
+				Added:       []models.AllowedSource{{ID: "group0", Tag: models.PT("group0-new-tag")}},
+				Removed:     []models.AllowedSource{{ID: "group0"}},
+			}}))
+		})
+
+		It("requires a resync since revisions that are not in the history", func() {
+			memStore.HistorySize = 2
+			for _, group := range []string{"group2", "group3", "group4"} {
+				Expect(added(memStore.Add(logger, "", models.Rule{Source: group, Destination: "group1"}))).To(Succeed())
+			}
+
+			_, err := memStore.WhitelistChanges(logger, 1, nil)
+			Expect(err).To(MatchError(store.ResyncRequiredError{Since: 1}))
+			_, err = memStore.WhitelistChanges(logger, 5, nil)
+			Expect(err).To(MatchError(store.ResyncRequiredError{Since: 5}))
+
+			changes, err := memStore.WhitelistChanges(logger, 2, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Changes[0].Added).To(Equal([]models.AllowedSource{
+				{ID: "group3", Tag: models.PT("group3-tag")},
+				{ID: "group4", Tag: models.PT("group4-tag")},
+			}))
+
+			changes, err = memStore.WhitelistChanges(logger, 4, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Changes).To(BeEmpty())
+		})
+	})
+
+	Describe("protocol and port rules", func() {
+		var tcpRule, udpRule models.Rule
+
+		BeforeEach(func() {
+			tcpRule = models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp", StartPort: 8080, EndPort: 8090}
+			udpRule = models.Rule{Source: "group0", Destination: "group1", Protocol: "udp", StartPort: 53, EndPort: 53}
+			var err error
+			tcpRule, _, err = memStore.Add(logger, "", tcpRule)
+			Expect(err).NotTo(HaveOccurred())
+			udpRule, _, err = memStore.Add(logger, "", udpRule)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("lists the permitted ports in the whitelist", func() {
+			whitelists, err := memStore.GetWhitelists(logger, []string{"group1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(whitelists[0].AllowedSources).To(Equal([]models.AllowedSource{
+				{ID: "group0", Tag: models.PT("group0-tag"), Protocol: "tcp", StartPort: 8080, EndPort: 8090},
+				{ID: "group0", Tag: models.PT("group0-tag"), Protocol: "udp", StartPort: 53, EndPort: 53},
+			}))
+		})
+
+		It("only deletes the rule that matches the whole tuple", func() {
+			err := memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp", StartPort: 8080, EndPort: 8080})
+			Expect(err).To(MatchError("not found"))
+
+			Expect(memStore.Delete(logger, "", models.Rule{Source: "group0", Destination: "group1", Protocol: "tcp", StartPort: 8080, EndPort: 8090})).To(Succeed())
+			rules, err := memStore.List(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]models.Rule{udpRule}))
+		})
 	})
 })
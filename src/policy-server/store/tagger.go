@@ -2,42 +2,126 @@ package store
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"policy-server/models"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
 )
 
+const MaxTagBits = 32
+
+type TagSpaceExhaustedError struct {
+	TagBits uint
+}
+
+func (e TagSpaceExhaustedError) TagSpaceExhausted() bool {
+	return true
+}
+
+func (e TagSpaceExhaustedError) Error() string {
+	return fmt.Sprintf("packet tag space exhausted: all %d tags of %d bits are in use or in quarantine",
+		tagCapacity(e.TagBits), e.TagBits)
+}
+
+// int64 so that 32 bits fit on 32-bit platforms too
+func tagCapacity(tagBits uint) int64 {
+	return int64(1)<<tagBits - 1
+}
+
+func validateTagBits(tagBits int) error {
+	if tagBits < 1 || tagBits > MaxTagBits {
+		return fmt.Errorf("invalid tag bits %d: must be between 1 and %d", tagBits, MaxTagBits)
+	}
+	return nil
+}
+
 type Tagger interface {
 	GetTag(groupID string) (*models.PacketTag, error)
+	ReleaseTag(groupID string) error
+	Stats() (models.TagStats, error)
+}
+
+type releasedTag struct {
+	GroupID    string            `json:"group_id"`
+	Tag        *models.PacketTag `json:"tag"`
+	ReleasedAt time.Time         `json:"released_at"`
 }
 
 type memoryTagger struct {
-	TagLength uint
+	TagBits    uint
+	Quarantine time.Duration
 
-	tags map[string]*models.PacketTag
-	lock sync.Mutex
+	clock     clock.Clock
+	tags      map[string]*models.PacketTag
+	released  []releasedTag
+	lastValue int64
+	allocated int
+	freed     int
+	lock      sync.Mutex
 }
 
-func NewMemoryTagger(tagLength int) (Tagger, error) {
-	if tagLength < 1 || tagLength > 8 {
-		return nil, errors.New("invalid tag length")
+func NewMemoryTagger(tagBits int, quarantine time.Duration, clock clock.Clock) (Tagger, error) {
+	if err := validateTagBits(tagBits); err != nil {
+		return nil, err
 	}
 	return &memoryTagger{
-		TagLength: uint(tagLength),
-		tags:      make(map[string]*models.PacketTag),
+		TagBits:    uint(tagBits),
+		Quarantine: quarantine,
+		clock:      clock,
+		tags:       make(map[string]*models.PacketTag),
 	}, nil
 }
 
-func (t *memoryTagger) intToPacketTag(x int) (*models.PacketTag, error) {
+func restoreMemoryTagger(tagBits int, quarantine time.Duration, clock clock.Clock,
+	tags map[string]*models.PacketTag, released []releasedTag) (*memoryTagger, error) {
+	tagger, err := NewMemoryTagger(tagBits, quarantine, clock)
+	if err != nil {
+		return nil, err
+	}
+	t := tagger.(*memoryTagger)
+	for groupID, tag := range tags {
+		t.tags[groupID] = tag
+		if value := packetTagToInt(tag); value > t.lastValue {
+			t.lastValue = value
+		}
+	}
+	for _, r := range released {
+		t.released = append(t.released, r)
+		if value := packetTagToInt(r.Tag); value > t.lastValue {
+			t.lastValue = value
+		}
+	}
+	sortReleased(t.released)
+	return t, nil
+}
+
+func sortReleased(released []releasedTag) {
+	sort.Sort(byReleasedAt(released))
+}
+
+type byReleasedAt []releasedTag
+
+func (r byReleasedAt) Len() int           { return len(r) }
+func (r byReleasedAt) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byReleasedAt) Less(i, j int) bool { return r[i].ReleasedAt.Before(r[j].ReleasedAt) }
+
+func packetTagToInt(pt *models.PacketTag) int64 {
 	buffer := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buffer, uint64(x))
-	if x >= 1<<t.TagLength {
-		return nil, fmt.Errorf("not enough bytes to represent %d", x)
+	copy(buffer, *pt)
+	return int64(binary.LittleEndian.Uint64(buffer))
+}
+
+func intToPacketTag(x int64, tagBits uint) (*models.PacketTag, error) {
+	if x < 1 || x > tagCapacity(tagBits) {
+		return nil, TagSpaceExhaustedError{TagBits: tagBits}
 	}
-	pt := models.PacketTag(buffer[0:t.TagLength])
+	buffer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buffer, uint64(x))
+	pt := models.PacketTag(buffer[0 : (tagBits+7)/8])
 	return &pt, nil
-
 }
 
 func (t *memoryTagger) GetTag(groupID string) (*models.PacketTag, error) {
@@ -47,10 +131,72 @@ func (t *memoryTagger) GetTag(groupID string) (*models.PacketTag, error) {
 	if tag, ok := t.tags[groupID]; ok {
 		return tag, nil
 	}
-	newTag, err := t.intToPacketTag(len(t.tags) + 1)
+
+	for i, r := range t.released {
+		if r.GroupID == groupID {
+			return t.reuse(i, groupID), nil
+		}
+	}
+
+	if len(t.released) > 0 && !t.clock.Now().Before(t.released[0].ReleasedAt.Add(t.Quarantine)) {
+		return t.reuse(0, groupID), nil
+	}
+
+	newTag, err := intToPacketTag(t.lastValue+1, t.TagBits)
 	if err != nil {
-		return nil, fmt.Errorf("form new packet tag: %s", err)
+		return nil, err
 	}
+	t.lastValue++
 	t.tags[groupID] = newTag
+	t.allocated++
 	return newTag, nil
 }
+
+func (t *memoryTagger) reuse(i int, groupID string) *models.PacketTag {
+	tag := t.released[i].Tag
+	t.released = append(t.released[:i], t.released[i+1:]...)
+	t.tags[groupID] = tag
+	t.allocated++
+	return tag
+}
+
+func (t *memoryTagger) ReleaseTag(groupID string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	tag, ok := t.tags[groupID]
+	if !ok {
+		return nil
+	}
+
+	delete(t.tags, groupID)
+	t.released = append(t.released, releasedTag{
+		GroupID:    groupID,
+		Tag:        tag,
+		ReleasedAt: t.clock.Now(),
+	})
+	t.freed++
+	return nil
+}
+
+func (t *memoryTagger) Stats() (models.TagStats, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return models.TagStats{
+		Allocated:   t.allocated,
+		Freed:       t.freed,
+		InUse:       len(t.tags),
+		Quarantined: len(t.released),
+		Capacity:    tagCapacity(t.TagBits),
+	}, nil
+}
+
+func (t *memoryTagger) releasedTags() []releasedTag {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	released := make([]releasedTag, len(t.released))
+	copy(released, t.released)
+	return released
+}
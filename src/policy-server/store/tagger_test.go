@@ -1,39 +1,235 @@
 package store_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"policy-server/models"
 	"policy-server/store"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
 )
 
+const quarantine = 10 * time.Minute
+
 var _ = Describe("Tagger", func() {
 	var (
-		tagger store.Tagger
+		tagger    store.Tagger
+		tagBits   int
+		fakeClock *fakeclock.FakeClock
 	)
 
+	itBehavesLikeATagger := func() {
+		It("is consistent: the same input always yields the same output", func() {
+			tag1, err := tagger.GetTag("input1")
+			Expect(err).NotTo(HaveOccurred())
+
+			tag1again, err := tagger.GetTag("input1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tag1).To(Equal(tag1again))
+		})
+
+		It("is injective: distinct inputs yield distinct outputs", func() {
+			tag1, err := tagger.GetTag("input1")
+			Expect(err).NotTo(HaveOccurred())
+
+			tag2, err := tagger.GetTag("input2")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tag1).NotTo(Equal(tag2))
+		})
+
+		It("encodes tags in as few bytes as the tag bits allow", func() {
+			tag, err := tagger.GetTag("input1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*tag).To(HaveLen(4))
+		})
+
+		Context("when the tag space is small", func() {
+			BeforeEach(func() {
+				tagBits = 2
+			})
+
+			It("allocates every non-zero value and then reports exhaustion", func() {
+				seen := map[string]bool{}
+				for _, group := range []string{"input1", "input2", "input3"} {
+					tag, err := tagger.GetTag(group)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(*tag).To(HaveLen(1))
+					seen[string(*tag)] = true
+				}
+				Expect(seen).To(HaveLen(3))
+
+				_, err := tagger.GetTag("input4")
+				Expect(err).To(Equal(store.TagSpaceExhaustedError{TagBits: 2}))
+
+				By("still returning the tags of known groups")
+				_, err = tagger.GetTag("input1")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("makes released tags available again once quarantine is over", func() {
+				for _, group := range []string{"input1", "input2", "input3"} {
+					_, err := tagger.GetTag(group)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(tagger.ReleaseTag("input1")).To(Succeed())
+
+				_, err := tagger.GetTag("input4")
+				Expect(err).To(BeAssignableToTypeOf(store.TagSpaceExhaustedError{}))
+
+				fakeClock.Increment(quarantine)
+				_, err = tagger.GetTag("input4")
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the tag space is wider than a nibble", func() {
+			BeforeEach(func() {
+				tagBits = 12
+			})
+
+			It("allocates more than 16 distinct two-byte tags", func() {
+				seen := map[string]bool{}
+				for i := 0; i < 300; i++ {
+					tag, err := tagger.GetTag(fmt.Sprintf("input%d", i))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(*tag).To(HaveLen(2))
+					seen[string(*tag)] = true
+				}
+				Expect(seen).To(HaveLen(300))
+			})
+		})
+
+		Describe("releasing tags", func() {
+			var releasedTag *models.PacketTag
+
+			JustBeforeEach(func() {
+				var err error
+				releasedTag, err = tagger.GetTag("input1")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = tagger.GetTag("input2")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tagger.ReleaseTag("input1")).To(Succeed())
+			})
+
+			It("does not reuse the tag for another group during quarantine", func() {
+				fakeClock.Increment(quarantine - time.Second)
+
+				tag, err := tagger.GetTag("input3")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tag).NotTo(Equal(releasedTag))
+			})
+
+			It("gives the same tag back to the group if it returns during quarantine", func() {
+				fakeClock.Increment(quarantine - time.Second)
+
+				tag, err := tagger.GetTag("input1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tag).To(Equal(releasedTag))
+			})
+
+			It("reuses the tag for another group once quarantine is over", func() {
+				fakeClock.Increment(quarantine)
+
+				tag, err := tagger.GetTag("input3")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tag).To(Equal(releasedTag))
+
+				By("giving the original group a fresh tag if it returns")
+				tag, err = tagger.GetTag("input1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tag).NotTo(Equal(releasedTag))
+			})
+
+			It("ignores groups that have no tag", func() {
+				Expect(tagger.ReleaseTag("input1")).To(Succeed())
+				Expect(tagger.ReleaseTag("unknown")).To(Succeed())
+
+				stats, err := tagger.Stats()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stats.Freed).To(Equal(1))
+			})
+
+			It("reports allocation and free counts", func() {
+				stats, err := tagger.Stats()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stats).To(Equal(models.TagStats{
+					Allocated:   2,
+					Freed:       1,
+					InUse:       1,
+					Quarantined: 1,
+					Capacity:    4294967295,
+				}))
+
+				fakeClock.Increment(quarantine)
+				_, err = tagger.GetTag("input3")
+				Expect(err).NotTo(HaveOccurred())
+
+				stats, err = tagger.Stats()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stats).To(Equal(models.TagStats{
+					Allocated:   3,
+					Freed:       1,
+					InUse:       2,
+					Quarantined: 0,
+					Capacity:    4294967295,
+				}))
+			})
+		})
+	}
+
 	BeforeEach(func() {
-		var err error
-		tagger, err = store.NewMemoryTagger(4)
-		Expect(err).NotTo(HaveOccurred())
+		tagBits = 32
+		fakeClock = fakeclock.NewFakeClock(time.Now())
 	})
 
-	It("is consistent: the same input always yields the same output", func() {
-		tag1, err := tagger.GetTag("input1")
-		Expect(err).NotTo(HaveOccurred())
+	It("rejects tag widths it cannot represent", func() {
+		_, err := store.NewMemoryTagger(0, quarantine, fakeClock)
+		Expect(err).To(MatchError("invalid tag bits 0: must be between 1 and 32"))
 
-		tag1again, err := tagger.GetTag("input1")
-		Expect(err).NotTo(HaveOccurred())
-		Expect(tag1).To(Equal(tag1again))
+		_, err = store.NewMemoryTagger(33, quarantine, fakeClock)
+		Expect(err).To(MatchError("invalid tag bits 33: must be between 1 and 32"))
 	})
 
-	It("is injective: distinct inputs yield distinct outputs", func() {
-		tag1, err := tagger.GetTag("input1")
-		Expect(err).NotTo(HaveOccurred())
+	Describe("memory tagger", func() {
+		JustBeforeEach(func() {
+			var err error
+			tagger, err = store.NewMemoryTagger(tagBits, quarantine, fakeClock)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		itBehavesLikeATagger()
+	})
+
+	Describe("sql tagger", func() {
+		var (
+			dataDir string
+			db      *store.Database
+		)
+
+		JustBeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "sql-tagger")
+			Expect(err).NotTo(HaveOccurred())
+
+			db, err = store.NewDatabase("sqlite3", filepath.Join(dataDir, "policy.db"))
+			Expect(err).NotTo(HaveOccurred())
+
+			tagger, err = store.NewSQLTagger(db, tagBits, quarantine, fakeClock)
+			Expect(err).NotTo(HaveOccurred())
+		})
 
-		tag2, err := tagger.GetTag("input2")
-		Expect(err).NotTo(HaveOccurred())
+		AfterEach(func() {
+			Expect(db.Close()).To(Succeed())
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
 
-		Expect(tag1).NotTo(Equal(tag2))
+		itBehavesLikeATagger()
 	})
 })
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const walHeaderLength = 8
+
+const maxWALRecordLength = 64 << 20
+
+var errTornRecord = errors.New("torn record")
+
+type writeAheadLog struct {
+	file   *os.File
+	offset int64
+}
+
+func openWAL(path string) (*writeAheadLog, [][]byte, int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("open: %s", err)
+	}
+
+	var payloads [][]byte
+	var offset int64
+	for {
+		payload, err := readWALRecord(file)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornRecord {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("read: %s", err)
+		}
+		payloads = append(payloads, payload)
+		offset += int64(walHeaderLength + len(payload))
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, 0, fmt.Errorf("stat: %s", err)
+	}
+
+	discarded := info.Size() - offset
+	if discarded > 0 {
+		if err := file.Truncate(offset); err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("truncate: %s", err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("sync: %s", err)
+		}
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, 0, fmt.Errorf("seek: %s", err)
+	}
+
+	return &writeAheadLog{file: file, offset: offset}, payloads, discarded, nil
+}
+
+func readWALRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, walHeaderLength)
+	n, err := io.ReadFull(r, header)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		return nil, errTornRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxWALRecordLength {
+		return nil, errTornRecord
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errTornRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, errTornRecord
+	}
+	return payload, nil
+}
+
+func (w *writeAheadLog) Append(payload []byte) error {
+	record := make([]byte, walHeaderLength+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[walHeaderLength:], payload)
+
+	if _, err := w.file.Write(record); err != nil {
+		w.rewind()
+		return fmt.Errorf("write: %s", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.rewind()
+		return fmt.Errorf("sync: %s", err)
+	}
+	w.offset += int64(len(record))
+	return nil
+}
+
+func (w *writeAheadLog) rewind() {
+	w.file.Truncate(w.offset)
+	w.file.Seek(w.offset, io.SeekStart)
+}
+
+func (w *writeAheadLog) Reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate: %s", err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %s", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync: %s", err)
+	}
+	w.offset = 0
+	return nil
+}
+
+func (w *writeAheadLog) Close() error {
+	return w.file.Close()
+}
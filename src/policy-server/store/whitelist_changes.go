@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"policy-server/models"
+	"sort"
+)
+
+type ResyncRequiredError struct {
+	Since int64
+}
+
+func (e ResyncRequiredError) ResyncRequired() bool {
+	return true
+}
+
+func (e ResyncRequiredError) Error() string {
+	return fmt.Sprintf("no changes since revision %d in history", e.Since)
+}
+
+func whitelistChanges(rules []models.Rule, current int64, history []models.Change, since int64,
+	groups []string, tagOf func(group string) (*models.PacketTag, error)) (models.WhitelistChanges, error) {
+	result := models.WhitelistChanges{Since: since, Revision: current, Changes: []models.WhitelistChange{}}
+
+	first := len(history) - int(current-since)
+	if since < 0 || since > current || first < 0 {
+		return models.WhitelistChanges{}, ResyncRequiredError{Since: since}
+	}
+	recent := history[first:]
+	for i, change := range recent {
+		if change.Revision != since+int64(i)+1 {
+			return models.WhitelistChanges{}, ResyncRequiredError{Since: since}
+		}
+	}
+
+	wanted := map[string]bool{}
+	for _, group := range groups {
+		wanted[group] = true
+	}
+	touched := map[string]bool{}
+	references := map[string]int{}
+	for _, change := range recent {
+		for _, rule := range append(append([]models.Rule{}, change.Added...), change.Deleted...) {
+			if len(wanted) == 0 || wanted[rule.Destination] {
+				touched[rule.Destination] = true
+			}
+			references[rule.Source], references[rule.Destination] = 0, 0
+		}
+	}
+
+	now, then := []models.Rule{}, map[string]models.Rule{}
+	for _, rule := range rules {
+		if touched[rule.Destination] {
+			now = append(now, rule)
+			then[rule.ID] = rule
+		}
+		for _, group := range []string{rule.Source, rule.Destination} {
+			if _, ok := references[group]; ok {
+				references[group]++
+			}
+		}
+	}
+	referencedNow := map[string]bool{}
+	for group, count := range references {
+		referencedNow[group] = count > 0
+	}
+
+	// a group that went away may have come back with another tag
+	went := map[string]bool{}
+	for i := len(recent) - 1; i >= 0; i-- {
+		for _, rule := range recent[i].Added {
+			delete(then, rule.ID)
+			references[rule.Source]--
+			references[rule.Destination]--
+		}
+		for _, rule := range recent[i].Deleted {
+			if touched[rule.Destination] {
+				then[rule.ID] = rule
+			}
+			references[rule.Source]++
+			references[rule.Destination]++
+		}
+		if i == 0 {
+			break
+		}
+		for group, count := range references {
+			if count == 0 {
+				went[group] = true
+			}
+		}
+	}
+	returned := map[string]bool{}
+	for group := range went {
+		returned[group] = referencedNow[group] && references[group] > 0
+	}
+
+	before := map[string]map[models.AllowedSource]bool{}
+	for _, rule := range then {
+		if before[rule.Destination] == nil {
+			before[rule.Destination] = map[models.AllowedSource]bool{}
+		}
+		before[rule.Destination][untaggedSource(rule)] = true
+	}
+	after := map[string][]models.AllowedSource{}
+	seen := map[models.Rule]bool{}
+	for _, rule := range now {
+		// rules with different owners may allow the same traffic
+		key := rule
+		key.ID, key.Owner = "", ""
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		after[rule.Destination] = append(after[rule.Destination], untaggedSource(rule))
+	}
+
+	destinations := []string{}
+	for group := range touched {
+		destinations = append(destinations, group)
+	}
+	sort.Strings(destinations)
+
+	for _, destination := range destinations {
+		change := models.WhitelistChange{
+			Destination: models.TaggedGroup{ID: destination},
+			Added:       []models.AllowedSource{},
+			Removed:     []models.AllowedSource{},
+		}
+		kept := map[models.AllowedSource]bool{}
+		for _, source := range after[destination] {
+			kept[source] = true
+			if before[destination][source] && !returned[source.ID] {
+				continue
+			}
+			tag, err := tagOf(source.ID)
+			if err != nil {
+				return models.WhitelistChanges{}, fmt.Errorf("get tag: %s", err)
+			}
+			source.Tag = tag
+			change.Added = append(change.Added, source)
+		}
+		for source := range before[destination] {
+			if !kept[source] || returned[source.ID] {
+				change.Removed = append(change.Removed, source)
+			}
+		}
+		if len(change.Added) == 0 && len(change.Removed) == 0 && !returned[destination] {
+			continue
+		}
+		sort.Sort(bySource(change.Removed))
+
+		tag, err := tagOf(destination)
+		if err != nil {
+			return models.WhitelistChanges{}, fmt.Errorf("get tag: %s", err)
+		}
+		change.Destination.Tag = tag
+		result.Changes = append(result.Changes, change)
+	}
+	return result, nil
+}
+
+func untaggedSource(rule models.Rule) models.AllowedSource {
+	return models.AllowedSource{
+		ID:        rule.Source,
+		Protocol:  rule.Protocol,
+		StartPort: rule.StartPort,
+		EndPort:   rule.EndPort,
+	}
+}
+
+type bySource []models.AllowedSource
+
+func (s bySource) Len() int      { return len(s) }
+func (s bySource) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySource) Less(i, j int) bool {
+	if s[i].ID != s[j].ID {
+		return s[i].ID < s[j].ID
+	}
+	if s[i].Protocol != s[j].Protocol {
+		return s[i].Protocol < s[j].Protocol
+	}
+	if s[i].StartPort != s[j].StartPort {
+		return s[i].StartPort < s[j].StartPort
+	}
+	return s[i].EndPort < s[j].EndPort
+}
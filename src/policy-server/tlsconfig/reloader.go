@@ -0,0 +1,133 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+const DefaultReloadInterval = time.Second
+
+type CertificateReloader struct {
+	Logger   lager.Logger
+	Clock    clock.Clock
+	Interval time.Duration
+
+	certPath string
+	keyPath  string
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	version fileVersion
+}
+
+type fileVersion struct {
+	certModTime time.Time
+	certSize    int64
+	keyModTime  time.Time
+	keySize     int64
+}
+
+func NewCertificateReloader(logger lager.Logger, certPath, keyPath string, clock clock.Clock) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		Logger:   logger,
+		Clock:    clock,
+		Interval: DefaultReloadInterval,
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertificateReloader) Reload() error {
+	version, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load certificate: %s", err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.version = version
+	return nil
+}
+
+func (r *CertificateReloader) stat() (fileVersion, error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("stat certificate: %s", err)
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("stat key: %s", err)
+	}
+	return fileVersion{
+		certModTime: certInfo.ModTime(),
+		certSize:    certInfo.Size(),
+		keyModTime:  keyInfo.ModTime(),
+		keySize:     keyInfo.Size(),
+	}, nil
+}
+
+func (r *CertificateReloader) changed() bool {
+	version, err := r.stat()
+	if err != nil {
+		// the files may be on their way back
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return version != r.version
+}
+
+func (r *CertificateReloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := r.Clock.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	close(ready)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-hangups:
+			r.reload("sighup")
+		case <-ticker.C():
+			if r.changed() {
+				r.reload("files-changed")
+			}
+		}
+	}
+}
+
+func (r *CertificateReloader) reload(reason string) {
+	logger := r.Logger.Session("reload-certificate", lager.Data{"reason": reason})
+	if err := r.Reload(); err != nil {
+		logger.Error("reload", err)
+		return
+	}
+	logger.Info("reloaded")
+}
@@ -0,0 +1,117 @@
+package tlsconfig_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"policy-server/tlsconfig"
+	"time"
+
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("CertificateReloader", func() {
+	var (
+		dir       string
+		certPath  string
+		keyPath   string
+		serial    *big.Int
+		fakeClock *fakeclock.FakeClock
+		logger    *lagertest.TestLogger
+		reloader  *tlsconfig.CertificateReloader
+	)
+
+	servedSerial := func() *big.Int {
+		cert, err := reloader.GetCertificate(nil)
+		Expect(err).NotTo(HaveOccurred())
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		return parsed.SerialNumber
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "reloader")
+		Expect(err).NotTo(HaveOccurred())
+		certPath = filepath.Join(dir, "server.crt")
+		keyPath = filepath.Join(dir, "server.key")
+		serial = writeCertificate(certPath, keyPath)
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		reloader, err = tlsconfig.NewCertificateReloader(logger, certPath, keyPath, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("serves the certificate in the files", func() {
+		Expect(servedSerial()).To(Equal(serial))
+	})
+
+	It("fails without a valid pair", func() {
+		_, err := tlsconfig.NewCertificateReloader(logger, certPath, filepath.Join(dir, "missing.key"), fakeClock)
+		Expect(err).To(MatchError(ContainSubstring("stat key")))
+
+		Expect(ioutil.WriteFile(keyPath, []byte("not a key"), 0600)).To(Succeed())
+		_, err = tlsconfig.NewCertificateReloader(logger, certPath, keyPath, fakeClock)
+		Expect(err).To(MatchError(ContainSubstring("load certificate")))
+	})
+
+	It("serves a new certificate once it is reloaded", func() {
+		newSerial := writeCertificate(certPath, keyPath)
+		Expect(servedSerial()).To(Equal(serial))
+
+		Expect(reloader.Reload()).To(Succeed())
+		Expect(servedSerial()).To(Equal(newSerial))
+	})
+
+	Context("when running", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			process = ifrit.Invoke(reloader)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+
+		It("reloads the certificate when the files change", func() {
+			newSerial := writeCertificate(certPath, keyPath)
+
+			fakeClock.Increment(tlsconfig.DefaultReloadInterval)
+			Eventually(servedSerial).Should(Equal(newSerial))
+		})
+
+		It("keeps the old certificate until the files make a valid pair", func() {
+			certPEM, err := ioutil.ReadFile(certPath)
+			Expect(err).NotTo(HaveOccurred())
+			newSerial := writeCertificate(certPath, keyPath)
+			newCertPEM, err := ioutil.ReadFile(certPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("rotating only the key")
+			Expect(ioutil.WriteFile(certPath, certPEM, 0600)).To(Succeed())
+			fakeClock.Increment(tlsconfig.DefaultReloadInterval)
+			Eventually(logger).Should(gbytes.Say("reload-certificate.reload"))
+			Expect(servedSerial()).To(Equal(serial))
+
+			By("rotating the certificate too")
+			Expect(ioutil.WriteFile(certPath, newCertPEM, 0600)).To(Succeed())
+			fakeClock.Increment(tlsconfig.DefaultReloadInterval)
+			Eventually(servedSerial).Should(Equal(newSerial))
+		})
+	})
+})
@@ -0,0 +1,39 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func Restrict(config *tls.Config, minVersion string, cipherSuites []string) error {
+	config.MinVersion = tls.VersionTLS12
+	if minVersion != "" {
+		version, ok := versions[minVersion]
+		if !ok {
+			return fmt.Errorf("unsupported min_version %q", minVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if len(cipherSuites) == 0 {
+		return nil
+	}
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	config.CipherSuites = []uint16{}
+	for _, name := range cipherSuites {
+		id, ok := secure[name]
+		if !ok {
+			return fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return nil
+}
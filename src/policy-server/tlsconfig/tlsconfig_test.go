@@ -0,0 +1,52 @@
+package tlsconfig_test
+
+import (
+	"crypto/tls"
+	"policy-server/tlsconfig"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Restrict", func() {
+	var config *tls.Config
+
+	BeforeEach(func() {
+		config = &tls.Config{}
+	})
+
+	It("accepts TLS 1.2 and newer with the default cipher suites", func() {
+		Expect(tlsconfig.Restrict(config, "", nil)).To(Succeed())
+		Expect(config.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+		Expect(config.CipherSuites).To(BeNil())
+	})
+
+	It("sets the minimum version", func() {
+		Expect(tlsconfig.Restrict(config, "1.3", nil)).To(Succeed())
+		Expect(config.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+	})
+
+	It("sets the cipher suites by name", func() {
+		Expect(tlsconfig.Restrict(config, "1.2", []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		})).To(Succeed())
+		Expect(config.CipherSuites).To(Equal([]uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		}))
+	})
+
+	It("rejects old versions", func() {
+		err := tlsconfig.Restrict(config, "1.0", nil)
+		Expect(err).To(MatchError(`unsupported min_version "1.0"`))
+	})
+
+	It("rejects unknown and insecure cipher suites", func() {
+		err := tlsconfig.Restrict(config, "", []string{"TLS_RSA_WITH_RC4_128_SHA"})
+		Expect(err).To(MatchError(`unknown or insecure cipher suite "TLS_RSA_WITH_RC4_128_SHA"`))
+
+		err = tlsconfig.Restrict(config, "", []string{"bogus"})
+		Expect(err).To(MatchError(ContainSubstring("bogus")))
+	})
+})